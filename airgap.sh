@@ -0,0 +1,271 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Air-Gap Transfer
+#
+# Description:
+# Moves everything an offline build needs to a machine without internet
+# access. "export" (on a connected machine) packs the cached extracts and
+# boundaries of the given regions, the region index, the Docker image (Java,
+# GraphHopper and its config) and these scripts into one archive with
+# checksums. "import" (on the air-gapped machine) verifies the archive, loads
+# the image and fills ./cache, after which ./run.sh <region> --offline works.
+#
+# Usage:
+# ./airgap.sh export us/virginia malta               # output/vns-airgap-<date>.tar
+# ./airgap.sh export us/virginia --output kit.tar    # choose the archive name
+# ./airgap.sh export malta --no-image                # data only (image already there)
+# ./airgap.sh import /media/usb/vns-airgap-20250901.tar
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+[ -f "$(dirname "$0")/region-names.sh" ] && . "$(dirname "$0")/region-names.sh"
+
+CACHE_DIR="./cache"
+INDEX_CACHE_FILE="${CACHE_DIR}/geofabrik-index.json"
+REGISTRY_IMAGE="ghcr.io/joshuafuller/atak-vns-offline-routing-generator:latest"
+LOCAL_IMAGE="vns-data-generator:latest"
+SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
+
+usage() {
+    echo "Usage: ./airgap.sh export <region> [<region> ...] [--output <file.tar>] [--no-image]"
+    echo "       ./airgap.sh import <file.tar>"
+}
+
+# Portable SHA-256: sha256sum on Linux/Git Bash, shasum on macOS
+sha256_tool() {
+    if command -v sha256sum >/dev/null 2>&1; then
+        echo "sha256sum"
+    elif command -v shasum >/dev/null 2>&1; then
+        echo "shasum -a 256"
+    else
+        echo "❌ Error: sha256sum or shasum is required but neither is installed." >&2
+        exit 1
+    fi
+}
+
+# Cached files of a region; prints nothing for a missing extract
+region_cache_files() {
+    local name="$1"
+    local file
+    for file in "${CACHE_DIR}/${name}.osm.pbf" "${CACHE_DIR}/${name}.poly" "${CACHE_DIR}/${name}.kml" \
+        "${CACHE_DIR}/${name}.timestamp."*; do
+        if [ -f "$file" ]; then
+            echo "$file"
+        fi
+    done
+}
+
+export_bundle() {
+    local regions=()
+    local archive=""
+    local include_image=true
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --output)
+                archive="$2"
+                shift
+                ;;
+            --output=*)
+                archive="${1#*=}"
+                ;;
+            --no-image)
+                include_image=false
+                ;;
+            -*)
+                echo "Error: Unknown option '$1'"
+                usage
+                exit 1
+                ;;
+            *)
+                regions+=("$1")
+                ;;
+        esac
+        shift
+    done
+    if [ ${#regions[@]} -eq 0 ]; then
+        echo "Error: No regions given."
+        usage
+        exit 1
+    fi
+    archive="${archive:-./output/vns-airgap-$(date +%Y%m%d).tar}"
+
+    echo "📦 VNS Air-Gap Export"
+    echo "====================="
+
+    # Aliases and codes (NC, USA) to index IDs: the cache is keyed by the ID
+    local region
+    if load_region_index; then
+        local resolved=()
+        for region in "${regions[@]}"; do
+            resolve_region "$region"
+            resolved+=("$RESOLVED_REGION")
+        done
+        regions=("${resolved[@]}")
+    fi
+
+    # Download whatever is not cached yet
+    local missing=()
+    for region in "${regions[@]}"; do
+        if [ ! -f "${CACHE_DIR}/$(basename "$region").osm.pbf" ]; then
+            missing+=("$region")
+        fi
+    done
+    if [ ${#missing[@]} -gt 0 ]; then
+        echo "📥 Downloading missing extracts: ${missing[*]}"
+        if ! "${SCRIPT_DIR}/run.sh" "${missing[@]}" --download-only; then
+            echo "❌ Error: Download failed - nothing exported"
+            exit 1
+        fi
+    fi
+    if [ ! -s "$INDEX_CACHE_FILE" ]; then
+        echo "❌ Error: No cached region index at ${INDEX_CACHE_FILE} - run ./list-regions.sh once"
+        exit 1
+    fi
+
+    local staging
+    staging="$(dirname "$archive")/.airgap-staging.$$"
+    rm -rf "$staging"
+    mkdir -p "${staging}/cache" "${staging}/tool"
+    trap 'rm -rf "$staging"' EXIT
+
+    local file
+    for region in "${regions[@]}"; do
+        if [ ! -f "${CACHE_DIR}/$(basename "$region").osm.pbf" ]; then
+            echo "❌ Error: No cached extract for ${region} - nothing exported"
+            exit 1
+        fi
+        for file in $(region_cache_files "$(basename "$region")"); do
+            cp -p "$file" "${staging}/cache/"
+        done
+        echo "  ✅ ${region}"
+    done
+    cp -p "$INDEX_CACHE_FILE" "${staging}/cache/"
+
+    # The scripts, so the tool itself can be installed from the archive
+    for file in "$SCRIPT_DIR"/*.sh "$SCRIPT_DIR"/aliases.tsv "$SCRIPT_DIR"/Dockerfile; do
+        if [ -f "$file" ]; then
+            cp -p "$file" "${staging}/tool/"
+        fi
+    done
+
+    # Java, GraphHopper and its config all live in the image
+    local image=""
+    if [ "$include_image" = "true" ]; then
+        if docker pull "$REGISTRY_IMAGE" >/dev/null 2>&1 || docker image inspect "$REGISTRY_IMAGE" >/dev/null 2>&1; then
+            image="$REGISTRY_IMAGE"
+        elif docker image inspect "$LOCAL_IMAGE" >/dev/null 2>&1; then
+            image="$LOCAL_IMAGE"
+        else
+            echo "❌ Error: No Docker image to export - run ./run.sh once, or use --no-image"
+            exit 1
+        fi
+        echo "🐳 Saving Docker image ${image}..."
+        docker save "$image" | gzip > "${staging}/image.tar.gz"
+        # gzip succeeds on the truncated stream of a failed save
+        local save_status=("${PIPESTATUS[@]}")
+        if [ "${save_status[0]}" -ne 0 ] || [ "${save_status[1]}" -ne 0 ]; then
+            echo "❌ Error: docker save failed"
+            exit 1
+        fi
+    fi
+
+    {
+        echo "created=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+        echo "host=$(hostname)"
+        echo "image=${image}"
+        echo "regions=${regions[*]}"
+    } > "${staging}/MANIFEST"
+
+    local sha256
+    sha256=$(sha256_tool)
+    (cd "$staging" && find . -type f ! -name SHA256SUMS | sed 's|^\./||' | sort | xargs $sha256 > SHA256SUMS)
+
+    mkdir -p "$(dirname "$archive")"
+    tar -cf "${archive}.tmp" -C "$staging" . && mv "${archive}.tmp" "$archive"
+    (cd "$(dirname "$archive")" && $sha256 "$(basename "$archive")" > "$(basename "$archive").sha256")
+
+    echo ""
+    echo "✅ Air-gap archive: ${archive} ($(du -h "$archive" | cut -f1))"
+    echo "   Copy it (and its .sha256) to the offline machine, then run there:"
+    echo "   ./airgap.sh import $(basename "$archive")"
+    echo "   Without the tool installed yet: tar -xf $(basename "$archive") --strip-components=2 ./tool"
+}
+
+import_bundle() {
+    local archive="$1"
+    if [ -z "$archive" ] || [ ! -f "$archive" ]; then
+        echo "Error: Archive not found: ${archive:-<none given>}"
+        usage
+        exit 1
+    fi
+
+    echo "📦 VNS Air-Gap Import"
+    echo "====================="
+
+    local staging="${CACHE_DIR}/.airgap-import.$$"
+    rm -rf "$staging"
+    mkdir -p "$staging"
+    trap 'rm -rf "$staging"' EXIT
+
+    if ! tar -xf "$archive" -C "$staging"; then
+        echo "❌ Error: Could not unpack ${archive}"
+        exit 1
+    fi
+    if [ ! -f "${staging}/SHA256SUMS" ] || [ ! -f "${staging}/MANIFEST" ]; then
+        echo "❌ Error: ${archive} is not an air-gap archive from ./airgap.sh export"
+        exit 1
+    fi
+
+    local sha256
+    sha256=$(sha256_tool)
+    if ! (cd "$staging" && $sha256 -c --quiet SHA256SUMS); then
+        echo "❌ Error: Checksum mismatch - the archive was damaged in transit. Copy it again."
+        exit 1
+    fi
+    echo "🔐 Checksums OK"
+
+    if [ -f "${staging}/image.tar.gz" ]; then
+        echo "🐳 Loading Docker image $(sed -n 's/^image=//p' "${staging}/MANIFEST")..."
+        if ! docker load < "${staging}/image.tar.gz"; then
+            echo "❌ Error: docker load failed"
+            exit 1
+        fi
+    fi
+
+    # Copy into place first, then rename, so an interrupted import never
+    # leaves a half-written extract in the cache
+    local file
+    mkdir -p "$CACHE_DIR"
+    for file in "${staging}/cache/"*; do
+        cp -p "$file" "${CACHE_DIR}/.$(basename "$file").tmp"
+        mv "${CACHE_DIR}/.$(basename "$file").tmp" "${CACHE_DIR}/$(basename "$file")"
+    done
+    # Mark the index as freshly checked so nothing tries to revalidate it
+    touch "${INDEX_CACHE_FILE}.checked"
+
+    echo ""
+    echo "✅ Imported into ${CACHE_DIR}. Build offline with:"
+    local region
+    for region in $(sed -n 's/^regions=//p' "${staging}/MANIFEST"); do
+        echo "   ./run.sh ${region} --offline"
+    done
+}
+
+main() {
+    local command="$1"
+    shift || true
+    case "$command" in
+        export) export_bundle "$@" ;;
+        import) import_bundle "$@" ;;
+        -h|--help)
+            usage
+            ;;
+        *)
+            usage
+            exit 1
+            ;;
+    esac
+}
+
+main "$@"
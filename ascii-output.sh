@@ -0,0 +1,81 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - ASCII Output Mode
+#
+# Description:
+# Sourced at the top of the other scripts. With VNS_ASCII=true (or TERM=dumb)
+# it rewrites emoji and box-drawing characters in what the script prints to
+# stdout into plain ASCII, for terminals that render them as boxes (older
+# Windows consoles) and for screen readers. The scripts never print colour, so NO_COLOR needs no
+# special handling and there is no colour theme to choose: VNS_ASCII is the
+# only output setting.
+# ==============================================================================
+
+# The patterns are multibyte characters: run sed under a UTF-8 locale so it
+# reads them the same whatever the user's locale is (C.UTF-8 on Linux,
+# en_US.UTF-8 on macOS). Each pattern is a plain byte sequence, so the C
+# fallback gives the same result.
+ascii_output_locale() {
+    local candidate
+    for candidate in C.UTF-8 C.utf8 en_US.UTF-8 en_US.utf8; do
+        if locale -a 2>/dev/null | grep -qx "$candidate"; then
+            echo "$candidate"
+            return 0
+        fi
+    done
+    echo "C"
+}
+
+ascii_output_filter() {
+    local sed_locale
+    sed_locale=$(ascii_output_locale)
+    # Line-buffered so progress appears as it happens (GNU: -u, BSD: -l)
+    local buffering=""
+    if sed -u '' </dev/null >/dev/null 2>&1; then
+        buffering="-u"
+    elif sed -l '' </dev/null >/dev/null 2>&1; then
+        buffering="-l"
+    fi
+    LC_ALL="$sed_locale" sed $buffering \
+        -e 's/✅/[OK]/g; s/❌/[ERROR]/g; s/⚠/[WARN]/g; s/🚨/[ALERT]/g; s/❓/[?]/g' \
+        -e 's/🔄/[UPDATE]/g; s/♻/[RESUME]/g; s/⏳/[WAIT]/g; s/🛑/[STOP]/g; s/▶/>/g' \
+        -e 's/📦/[PKG]/g; s/📁/[DIR]/g; s/📂/[DIR]/g; s/💾/[DISK]/g; s/🔐/[SHA]/g; s/🔏/[SIGN]/g; s/📥/[DOWNLOAD]/g; s/🔽/[DOWNLOAD]/g' \
+        -e 's/🧹/[CLEAN]/g; s/🗑/[DEL]/g; s/🔍/[CHECK]/g; s/📡/[NET]/g; s/🌐/[NET]/g; s/🔗/[LINK]/g' \
+        -e 's/💡/[TIP]/g; s/📍/*/g; s/🗺/[MAP]/g; s/⭐/*/g; s/✨/*/g; s/🎉/[DONE]/g; s/🏁/[DONE]/g' \
+        -e 's/⏰/[TIME]/g; s/🕒/[TIME]/g; s/⏱/[TIME]/g; s/⏭/[SKIP]/g; s/⏸/[PAUSE]/g; s/🔁/[RETRY]/g; s/🔎/[CHECK]/g; s/🔤/[ALIAS]/g; s/📟/[GAUGE]/g; s/🧵/[THREADS]/g; s/🐢/[NICE]/g; s/📶/[NET]/g; s/🖼/[IMAGE]/g; s/📴/[OFFLINE]/g; s/🧭/[ROUTE]/g; s/🪝/[HOOK]/g; s/🔧/[FIX]/g; s/🎛/[SET]/g; s/⚡/[FAST]/g' \
+        -e 's/📊/[INFO]/g; s/📋/[INFO]/g; s/📚/[INFO]/g; s/📈/[INFO]/g; s/📭/[EMPTY]/g; s/📱/[DEVICE]/g; s/📌/[PIN]/g; s/📏/[SIZE]/g; s/🧩/[DELTA]/g; s/✂/[SPLIT]/g' \
+        -e 's/📄/-/g; s/🐛/[BUG]/g; s/🔬/[DEBUG]/g; s/🌍/[WORLD]/g; s/🐧/-/g; s/🍎/-/g; s/🪟/-/g; s/🐳/-/g' \
+        -e 's/━/=/g; s/─/-/g; s/│/|/g; s/├/+/g; s/└/`/g; s/•/*/g; s/→/->/g; s/←/<-/g; s/×/x/g; s/²/2/g; s/—/-/g' \
+        -e 's/️//g'
+}
+
+# Ask a question on the terminal: read_answer "<prompt>" <variable>. With the
+# filter on, the prompt goes through it on a line of its own, so it shows
+# after the lines printed before it and not ahead of them.
+read_answer() {
+    local prompt="$1"
+    local variable="$2"
+    if [ "${ASCII_OUTPUT_FILTERED:-false}" = "true" ]; then
+        [ -t 0 ] && echo "$prompt"
+        read -r "$variable"
+    else
+        read -r -p "$prompt" "$variable"
+    fi
+}
+
+# The script runs again as a child whose stdout goes through the filter, so
+# the shell that started it waits for the last filtered line. stderr stays
+# out of the filter so errors are never held back; prompts use read_answer,
+# as sed waits for a whole line and a prompt has none. Ctrl+C reaches the
+# script as usual; the filter ignores it so a script that handles Ctrl+C
+# keeps its output.
+if [ "${VNS_ASCII:-false}" = "true" ] || [ "${TERM:-}" = "dumb" ]; then
+    if [ -n "${ASCII_OUTPUT_CHILD:-}" ]; then
+        unset ASCII_OUTPUT_CHILD
+        ASCII_OUTPUT_FILTERED=true
+    else
+        ASCII_OUTPUT_CHILD=1 "$BASH" "$0" "$@" | { trap '' INT; ascii_output_filter; }
+        exit "${PIPESTATUS[0]}"
+    fi
+fi
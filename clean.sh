@@ -0,0 +1,201 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Cleanup
+#
+# Description:
+# Reports and removes disk space held by leftovers: working directories of
+# crashed or cancelled builds, old cached downloads, an expired region index
+# and (optionally) old output backups. Builds that are still running (their
+# region lock is held) are never touched.
+#
+# Usage:
+# ./clean.sh --dry-run                  # show what would be removed
+# ./clean.sh                            # remove after confirmation
+# ./clean.sh --older-than 7 --yes       # downloads unused for a week, no prompt
+# ./clean.sh --outputs --min-size 100   # include output backups >= 100MB
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+
+DRY_RUN=false
+ASSUME_YES=false
+INCLUDE_OUTPUTS=false
+OLDER_THAN_DAYS=30
+MIN_SIZE_MB=0
+TEMP_DIR="${VNS_TEMP_DIR:-}"
+
+CANDIDATES=()
+CATEGORIES=()
+SIZES_KB=()
+
+usage() {
+    echo "Usage: ./clean.sh [--dry-run] [--yes] [--outputs] [--older-than <days>] [--min-size <MB>]"
+    echo ""
+    echo "  --dry-run            Only report what would be removed"
+    echo "  --yes                Do not ask for confirmation"
+    echo "  --outputs            Also remove old automatic backups in ./output"
+    echo "  --older-than <days>  Age for cached downloads and backups (default: 30)"
+    echo "  --min-size <MB>      Ignore items smaller than this"
+}
+
+# Human-readable size from kilobytes
+format_size() {
+    awk -v k="$1" 'BEGIN {
+        split("K M G T", unit, " ")
+        i = 1
+        while (k >= 1024 && i < 4) { k /= 1024; i++ }
+        printf (i == 1 ? "%d%s" : "%.1f%s"), k, unit[i]
+    }'
+}
+
+# True if a build of this region currently holds its lock
+region_locked() {
+    local lock_file="./cache/locks/$1.lock"
+    if [ -d "${lock_file}.d" ]; then
+        return 0
+    fi
+    if [ -s "$lock_file" ] && command -v flock >/dev/null 2>&1; then
+        ! flock -n "$lock_file" true 2>/dev/null
+        return
+    fi
+    return 1
+}
+
+# Queue a path for removal if it passes the size filter
+add_candidate() {
+    local category="$1"
+    local path="$2"
+    [ -e "$path" ] || return 0
+    local size_kb
+    size_kb=$(du -sk "$path" 2>/dev/null | cut -f1)
+    [ "${size_kb:-0}" -ge $(( MIN_SIZE_MB * 1024 )) ] || return 0
+    CANDIDATES+=("$path")
+    CATEGORIES+=("$category")
+    SIZES_KB+=("${size_kb:-0}")
+}
+
+collect_candidates() {
+    local path
+    local region
+
+    # Working directories of builds that are not running any more
+    for path in ./cache/work/* ./output/.work/* ${TEMP_DIR:+"$TEMP_DIR"/*}; do
+        [ -d "$path" ] || continue
+        region=$(basename "$path")
+        # VNS_TEMP_DIR may be shared with other tools: only touch region folders
+        case "$path" in ./cache/*|./output/*) ;; *) [ -f "./cache/${region}.poly" ] || continue ;; esac
+        if region_locked "$region"; then
+            echo "⏳ Skipping ${path} - a build of ${region} is running"
+            continue
+        fi
+        add_candidate "temp" "$path"
+    done
+    # Staged downloads left next to the work directories
+    for path in ./cache/work/*-latest.* ./output/.work/*-latest.* ${TEMP_DIR:+"$TEMP_DIR"/*-latest.*}; do
+        [ -f "$path" ] || continue
+        region=$(basename "$path" | sed 's/-latest\..*//')
+        case "$path" in ./cache/*|./output/*) ;; *) [ -f "./cache/${region}.poly" ] || continue ;; esac
+        region_locked "$region" || add_candidate "temp" "$path"
+    done
+
+    # Cached extracts not refreshed for a while (re-downloaded when needed)
+    while IFS= read -r path; do
+        region=$(basename "$path" | sed 's/\.osm\(\.pbf\)\{0,1\}$//')
+        region_locked "$region" || add_candidate "download" "$path"
+    done < <(find ./cache -maxdepth 1 \( -name '*.osm.pbf' -o -name '*.osm' \) -mtime +"$OLDER_THAN_DAYS" 2>/dev/null | sort)
+
+    # Region index that is past its revalidation age anyway
+    if [ -f ./cache/geofabrik-index.json ] && \
+        [ -n "$(find ./cache/geofabrik-index.json.checked -mmin +$(( ${VNS_INDEX_MAX_AGE_HOURS:-24} * 60 )) 2>/dev/null)" ]; then
+        add_candidate "index" ./cache/geofabrik-index.json
+    fi
+
+    # Automatic backups created when a region was rebuilt
+    if [ "$INCLUDE_OUTPUTS" = "true" ]; then
+        while IFS= read -r path; do
+            add_candidate "backup" "$path"
+        done < <(find ./output -maxdepth 1 -name '*.backup.*' -mtime +"$OLDER_THAN_DAYS" 2>/dev/null | sort)
+    fi
+}
+
+# Remove a candidate together with the bookkeeping files that describe it
+remove_candidate() {
+    local category="$1"
+    local path="$2"
+    rm -rf "$path"
+    case "$category" in
+        download)
+            # Without its timestamp the extract is simply downloaded again
+            rm -f "${path%.osm*}.timestamp.osm"
+            ;;
+        index)
+            rm -f "${path}.etag" "${path}.checked"
+            ;;
+    esac
+}
+
+main() {
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --dry-run)    DRY_RUN=true ;;
+            --yes|-y)     ASSUME_YES=true ;;
+            --outputs)    INCLUDE_OUTPUTS=true ;;
+            --older-than) OLDER_THAN_DAYS="$2"; shift ;;
+            --min-size)   MIN_SIZE_MB="$2"; shift ;;
+            -h|--help)    usage; exit 0 ;;
+            *)
+                echo "Error: Unknown option '$1'"
+                usage
+                exit 1
+                ;;
+        esac
+        shift
+    done
+
+    if ! [[ "$OLDER_THAN_DAYS" =~ ^[0-9]+$ ]] || ! [[ "$MIN_SIZE_MB" =~ ^[0-9]+$ ]]; then
+        echo "Error: --older-than and --min-size take whole numbers"
+        exit 1
+    fi
+
+    echo "🧹 VNS Cleanup"
+    echo "=============="
+    collect_candidates
+
+    if [ ${#CANDIDATES[@]} -eq 0 ]; then
+        echo "✨ Nothing to clean up"
+        exit 0
+    fi
+
+    local i
+    local total_kb=0
+    echo ""
+    printf "  %-9s %9s  %s\n" "TYPE" "SIZE" "PATH"
+    for i in "${!CANDIDATES[@]}"; do
+        printf "  %-9s %9s  %s\n" "${CATEGORIES[$i]}" "$(format_size "${SIZES_KB[$i]}")" "${CANDIDATES[$i]}"
+        total_kb=$(( total_kb + SIZES_KB[i] ))
+    done
+    echo ""
+    echo "💾 Reclaimable: $(format_size "$total_kb") in ${#CANDIDATES[@]} item(s)"
+
+    if [ "$DRY_RUN" = "true" ]; then
+        echo "Dry run - nothing removed."
+        exit 0
+    fi
+
+    if [ "$ASSUME_YES" != "true" ]; then
+        local response
+        read_answer "Remove these items? [y/N] " response || response=""
+        case "$response" in
+            [yY]|[yY][eE][sS]) ;;
+            *) echo "Aborted - nothing removed."; exit 0 ;;
+        esac
+    fi
+
+    for i in "${!CANDIDATES[@]}"; do
+        remove_candidate "${CATEGORIES[$i]}" "${CANDIDATES[$i]}"
+    done
+    echo "✅ Freed $(format_size "$total_kb")"
+}
+
+main "$@"
@@ -0,0 +1,155 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Coverage Export
+#
+# Description:
+# Writes the boundaries of every region built on this machine as GeoJSON and
+# KML, so teams can load them into ATAK or any GIS and see at a glance which
+# areas their offline routing covers. Boundaries come from the .poly files
+# downloaded with each region; the region list from ./cache/registry.json.
+#
+# Usage:
+# ./coverage.sh                      # output/coverage.geojson + output/coverage.kml
+# ./coverage.sh --output my-kit      # output/my-kit.geojson + output/my-kit.kml
+# ./coverage.sh delaware maryland    # only these regions
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+
+REGISTRY_FILE="./cache/registry.json"
+OUTPUT_BASE="./output/coverage"
+FILTER=()
+
+# Convert an Osmosis .poly file to "O" / "H" ring markers (outer ring / hole)
+# followed by "lon lat" lines
+poly_rings() {
+    awk '
+        NR == 1 { next }
+        $1 == "END" { in_ring = 0; next }
+        !in_ring { in_ring = 1; print (substr($1, 1, 1) == "!" ? "H" : "O"); next }
+        NF >= 2 { print $1, $2 }
+    ' "$1"
+}
+
+# GeoJSON MultiPolygon coordinates for a .poly file (holes join the
+# preceding outer ring)
+poly_to_geojson_coordinates() {
+    poly_rings "$1" | awk '
+        $1 == "O" { if (n) printf "]],"; else printf "["; printf "[["; n++; first = 1; next }
+        $1 == "H" { printf "],["; first = 1; next }
+        { printf "%s[%s,%s]", (first ? "" : ","), $1, $2; first = 0 }
+        END { if (n) print "]]]"; else print "[]" }
+    '
+}
+
+# KML Polygon elements for a .poly file
+poly_to_kml_polygons() {
+    poly_rings "$1" | awk '
+        function close_ring() { if (open) printf "</coordinates></LinearRing></%s>", boundary; open = 0 }
+        function close_polygon() { close_ring(); if (polygon) print "</Polygon>"; polygon = 0 }
+        $1 == "O" { close_polygon(); printf "<Polygon>"; polygon = 1; boundary = "outerBoundaryIs"; printf "<%s><LinearRing><coordinates>", boundary; open = 1; next }
+        $1 == "H" { close_ring(); boundary = "innerBoundaryIs"; printf "<%s><LinearRing><coordinates>", boundary; open = 1; next }
+        { printf "%s,%s ", $1, $2 }
+        END { close_polygon() }
+    '
+}
+
+# Boundary file for a region: the cached download, or the copy in its output folder
+find_poly() {
+    local region="$1"
+    local candidate
+    for candidate in "./cache/${region}.poly" "./output/${region}/${region}.poly"; do
+        if [ -s "$candidate" ]; then
+            echo "$candidate"
+            return 0
+        fi
+    done
+    return 1
+}
+
+main() {
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --output)
+                OUTPUT_BASE="./output/${2%.*}"
+                shift
+                ;;
+            -h|--help)
+                echo "Usage: ./coverage.sh [--output <name>] [region ...]"
+                exit 0
+                ;;
+            -*)
+                echo "Error: Unknown option '$1'"
+                echo "Usage: ./coverage.sh [--output <name>] [region ...]"
+                exit 1
+                ;;
+            *) FILTER+=("$(basename "$1")") ;;
+        esac
+        shift
+    done
+
+    if ! command -v jq >/dev/null 2>&1; then
+        echo "❌ Error: jq is required but not installed (see ./list-regions.sh for install hints)"
+        exit 1
+    fi
+    if [ ! -s "$REGISTRY_FILE" ]; then
+        echo "📭 No builds recorded yet - build a region with ./run.sh <region>"
+        exit 1
+    fi
+
+    local regions
+    if [ ${#FILTER[@]} -gt 0 ]; then
+        regions=$(printf '%s\n' "${FILTER[@]}")
+    else
+        regions=$(jq -r 'keys[]' "$REGISTRY_FILE")
+    fi
+
+    local features=()
+    local placemarks=""
+    local region poly entry
+    for region in $regions; do
+        entry=$(jq -c --arg r "$region" '.[$r] // empty' "$REGISTRY_FILE")
+        if [ -z "$entry" ]; then
+            echo "⚠️  ${region}: not in the build registry - skipped"
+            continue
+        fi
+        if ! poly=$(find_poly "$region"); then
+            echo "⚠️  ${region}: no .poly boundary found - skipped"
+            continue
+        fi
+        features+=("$(echo "$entry" | jq -c --argjson coords "$(poly_to_geojson_coordinates "$poly")" \
+            '{type: "Feature",
+              properties: {region, region_id, built_at, source_date, output_path},
+              geometry: {type: "MultiPolygon", coordinates: $coords}}')")
+        placemarks+="<Placemark><name>${region}</name>"
+        placemarks+="<description>$(echo "$entry" | jq -r '"\(.region_id) - built \(.built_at), source data \(.source_date)"')</description>"
+        placemarks+="<styleUrl>#coverage</styleUrl><MultiGeometry>$(poly_to_kml_polygons "$poly")</MultiGeometry></Placemark>"$'\n'
+        echo "✅ ${region}"
+    done
+
+    if [ ${#features[@]} -eq 0 ]; then
+        echo "❌ No region boundaries to export"
+        exit 1
+    fi
+
+    mkdir -p "$(dirname "$OUTPUT_BASE")"
+    printf '%s\n' "${features[@]}" | jq -s '{type: "FeatureCollection", features: .}' > "${OUTPUT_BASE}.geojson.tmp"
+    mv "${OUTPUT_BASE}.geojson.tmp" "${OUTPUT_BASE}.geojson"
+    {
+        echo '<?xml version="1.0" encoding="UTF-8"?>'
+        echo '<kml xmlns="http://www.opengis.net/kml/2.2">'
+        echo '<Document><name>VNS routing coverage</name>'
+        echo '<Style id="coverage"><LineStyle><color>ff00aaff</color><width>2</width></LineStyle><PolyStyle><color>4000aaff</color></PolyStyle></Style>'
+        printf '%s' "$placemarks"
+        echo '</Document></kml>'
+    } > "${OUTPUT_BASE}.kml.tmp"
+    mv "${OUTPUT_BASE}.kml.tmp" "${OUTPUT_BASE}.kml"
+
+    echo ""
+    echo "🗺️  Coverage of ${#features[@]} region(s) written to:"
+    echo "  • ${OUTPUT_BASE}.geojson"
+    echo "  • ${OUTPUT_BASE}.kml (import into ATAK or Google Earth)"
+}
+
+main "$@"
@@ -0,0 +1,332 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Scheduled Refresh Daemon
+#
+# Description:
+# Keeps running and rebuilds a list of regions on a cron-style schedule.
+# Regions whose Geofabrik data has not changed are skipped automatically by
+# generate-data.sh, so only stale regions are reprocessed. Old backups left
+# by rebuilds are pruned after each cycle. Replaces cron + wrapper scripts.
+#
+# Usage:
+# ./daemon.sh --schedule "0 3 * * 0" --preset east-coast-kit
+# ./daemon.sh --schedule "30 2 * * *" --regions us/delaware,malta --keep 1
+#
+# Presets are plain text files in ./presets/ with one region ID per line
+# (blank lines and # comments are ignored).
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+
+SCHEDULE=""
+PRESET=""
+REGIONS_ARG=""
+KEEP_BACKUPS=${VNS_KEEP_BACKUPS:-2}
+RUN_NOW=false
+LOG_FILE="./logs/daemon.log"
+
+usage() {
+    echo "Usage: ./daemon.sh --schedule \"<cron expression>\" (--preset <name> | --regions <id,id,...>)"
+    echo "                   [--keep <backups per region>] [--run-now]"
+    echo ""
+    echo "Examples:"
+    echo "  ./daemon.sh --schedule \"0 3 * * 0\" --preset east-coast-kit   # Sundays at 03:00"
+    echo "  ./daemon.sh --schedule \"0 */6 * * *\" --regions us/delaware    # every 6 hours"
+}
+
+log() {
+    local line
+    line="[$(date '+%Y-%m-%d %H:%M:%S')] $*"
+    echo "$line"
+    echo "$line" >> "$LOG_FILE"
+}
+
+# --- Cron Expression Matching ---
+# Supports the standard 5-field syntax: numbers, '*', ranges (1-5), steps
+# (*/15, 1-30/5) and lists (1,15,30). Day-of-week accepts 0-7 (0 and 7 = Sunday).
+# Month and weekday names (JAN, MON-FRI) are turned into numbers first.
+
+# Replace names in a field with their numbers: cron_field_numbers <field>
+# <number of the first name> <names...>
+cron_field_numbers() {
+    local field="${1^^}"
+    local number="$2"
+    shift 2
+    local name
+    for name in "$@"; do
+        field="${field//${name}/${number}}"
+        number=$((number + 1))
+    done
+    echo "$field"
+}
+
+# Check that a field only holds numbers from min to max, '*', ranges, lists
+# and steps of at least 1, so a typo fails at startup and not in the loop
+cron_field_valid() {
+    local field="$1"
+    local min="$2"
+    local max="$3"
+    local parts
+    local part
+    local range
+    local step
+    local lo
+    local hi
+    IFS=',' read -ra parts <<< "$field"
+    [ ${#parts[@]} -gt 0 ] || return 1
+    for part in "${parts[@]}"; do
+        range="${part%%/*}"
+        if [[ "$part" == */* ]]; then
+            step="${part#*/}"
+            [[ "$step" =~ ^[0-9]+$ ]] && [ $((10#$step)) -ge 1 ] || return 1
+        fi
+        [ "$range" = "*" ] && continue
+        if [[ "$range" =~ ^([0-9]+)-([0-9]+)$ ]]; then
+            lo=$((10#${BASH_REMATCH[1]}))
+            hi=$((10#${BASH_REMATCH[2]}))
+        elif [[ "$range" =~ ^[0-9]+$ ]]; then
+            lo=$((10#$range))
+            hi=$lo
+        else
+            return 1
+        fi
+        [ "$lo" -ge "$min" ] && [ "$lo" -le "$hi" ] && [ "$hi" -le "$max" ] || return 1
+    done
+}
+
+# Check whether a value matches one cron field
+field_matches() {
+    local value="$1"
+    local field="$2"
+    local min="$3"
+    local max="$4"
+    local part
+    local range
+    local step
+    local lo
+    local hi
+
+    IFS=',' read -ra parts <<< "$field"
+    for part in "${parts[@]}"; do
+        range="${part%%/*}"
+        step=1
+        [[ "$part" == */* ]] && step="${part#*/}"
+        if [ "$range" = "*" ]; then
+            lo=$min
+            hi=$max
+        elif [[ "$range" == *-* ]]; then
+            lo="${range%-*}"
+            hi="${range#*-}"
+        else
+            lo="$range"
+            # A bare number with a step (5/15) means "from 5 to max"
+            if [[ "$part" == */* ]]; then hi=$max; else hi="$range"; fi
+        fi
+        if [ "$value" -ge "$lo" ] && [ "$value" -le "$hi" ] && [ $(( (value - 10#$lo) % 10#$step )) -eq 0 ]; then
+            return 0
+        fi
+    done
+    return 1
+}
+
+# Day-of-month and day-of-week are OR-ed when both are restricted (cron rules)
+day_matches() {
+    local dom="$1"
+    local month="$2"
+    local dow="$3"
+    field_matches "$month" "$CRON_MONTH" 1 12 || return 1
+    local dom_ok=1
+    local dow_ok=1
+    field_matches "$dom" "$CRON_DOM" 1 31 && dom_ok=0
+    { field_matches "$dow" "$CRON_DOW" 0 7 || { [ "$dow" -eq 0 ] && field_matches 7 "$CRON_DOW" 0 7; }; } && dow_ok=0
+    if [ "$CRON_DOM" != "*" ] && [ "$CRON_DOW" != "*" ]; then
+        [ $dom_ok -eq 0 ] || [ $dow_ok -eq 0 ]
+    else
+        [ $dom_ok -eq 0 ] && [ $dow_ok -eq 0 ]
+    fi
+}
+
+# Format an epoch in local time: GNU date takes -d @<epoch>, BSD/macOS -r <epoch>
+format_epoch() {
+    local epoch="$1"
+    local format="$2"
+    date -d "@$epoch" "$format" 2>/dev/null || date -r "$epoch" "$format"
+}
+
+# Print the epoch of the next minute matching the schedule. Walks forward one
+# day at a time (one 'date' call per day, up to four years ahead so Feb 29
+# schedules resolve) and scans hours/minutes in-process. Days are stepped from
+# local noon, which stays on the right date across DST changes without GNU
+# date's relative "+N day" parsing.
+next_run_epoch() {
+    local now
+    now=$(date +%s)
+    local clock
+    read -r -a clock <<< "$(date '+%H %M %S')"
+    local today_noon=$(( now - 10#${clock[0]} * 3600 - 10#${clock[1]} * 60 - 10#${clock[2]} + 12 * 3600 ))
+    local day_offset
+    local noon
+    local day_start
+    local fields
+    local dom
+    local month
+    local dow
+    local hour
+    local minute
+    local candidate
+    for ((day_offset = 0; day_offset <= 1461; day_offset++)); do
+        noon=$(( today_noon + day_offset * 86400 ))
+        read -r -a fields <<< "$(format_epoch "$noon" '+%d %m %w %H %M %S')"
+        dom=$(( 10#${fields[0]} ))
+        month=$(( 10#${fields[1]} ))
+        dow="${fields[2]}"
+        day_start=$(( noon - 10#${fields[3]} * 3600 - 10#${fields[4]} * 60 - 10#${fields[5]} ))
+        day_matches "$dom" "$month" "$dow" || continue
+        for ((hour = 0; hour < 24; hour++)); do
+            field_matches "$hour" "$CRON_HOUR" 0 23 || continue
+            for ((minute = 0; minute < 60; minute++)); do
+                field_matches "$minute" "$CRON_MINUTE" 0 59 || continue
+                candidate=$(( day_start + hour * 3600 + minute * 60 ))
+                if [ "$candidate" -gt "$now" ]; then
+                    echo "$candidate"
+                    return 0
+                fi
+            done
+        done
+    done
+    return 1
+}
+
+# --- Region List ---
+load_regions() {
+    if [ -n "$PRESET" ]; then
+        local preset_file="./presets/${PRESET}.txt"
+        [ -f "$PRESET" ] && preset_file="$PRESET"
+        if [ ! -f "$preset_file" ]; then
+            echo "❌ Error: Preset '${PRESET}' not found (looked for ${preset_file})"
+            exit 1
+        fi
+        sed -e 's/#.*//' -e 's/[[:space:]]//g' "$preset_file" | grep -v '^$'
+    else
+        echo "$REGIONS_ARG" | tr ',' '\n' | sed 's/[[:space:]]//g' | grep -v '^$'
+    fi
+}
+
+# --- Pruning ---
+# Keep the newest N automatic backups (folders and archives) per region
+prune_backups() {
+    local region_name="$1"
+    local stamps
+    stamps=$(find ./output -maxdepth 1 -name "${region_name}.backup.*" 2>/dev/null \
+        | sed -n "s|^\./output/${region_name}\.backup\.\([0-9]\{8\}_[0-9]\{6\}\).*|\1|p" | sort -ru)
+    local count=0
+    local stamp
+    for stamp in $stamps; do
+        count=$((count + 1))
+        if [ "$count" -gt "$KEEP_BACKUPS" ]; then
+            rm -rf "./output/${region_name}.backup.${stamp}" "./output/${region_name}.backup.${stamp}".*
+            log "🧹 Pruned old backup: ${region_name}.backup.${stamp}"
+        fi
+    done
+}
+
+# --- Refresh Cycle ---
+run_cycle() {
+    local regions
+    mapfile -t regions < <(load_regions)
+    local total=${#regions[@]}
+    local index=0
+    local failed=0
+    local region
+
+    log "🔄 Refresh cycle started: ${total} region(s)"
+    for region in "${regions[@]}"; do
+        index=$((index + 1))
+        log "▶️  [${index}/${total}] ${region}"
+        if VNS_QUEUE_DEPTH=$((total - index)) VNS_DESKTOP_NOTIFY=false ./run.sh "$region" >> "$LOG_FILE" 2>&1; then
+            log "✅ ${region} is current"
+        else
+            failed=$((failed + 1))
+            log "❌ ${region} failed - see ${LOG_FILE} for details"
+        fi
+        prune_backups "$(basename "$region")"
+    done
+    log "🏁 Refresh cycle finished: $((total - failed)) ok, ${failed} failed"
+}
+
+main() {
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --schedule) SCHEDULE="$2"; shift ;;
+            --preset)   PRESET="$2"; shift ;;
+            --regions)  REGIONS_ARG="$2"; shift ;;
+            --keep)     KEEP_BACKUPS="$2"; shift ;;
+            --run-now)  RUN_NOW=true ;;
+            -h|--help)  usage; exit 0 ;;
+            *)
+                echo "Error: Unknown option '$1'"
+                usage
+                exit 1
+                ;;
+        esac
+        shift
+    done
+
+    if [ -z "$SCHEDULE" ] || { [ -z "$PRESET" ] && [ -z "$REGIONS_ARG" ]; }; then
+        usage
+        exit 1
+    fi
+
+    read -r CRON_MINUTE CRON_HOUR CRON_DOM CRON_MONTH CRON_DOW extra <<< "$SCHEDULE"
+    if [ -z "$CRON_DOW" ] || [ -n "$extra" ]; then
+        echo "❌ Error: Schedule must have 5 fields (minute hour day month weekday), got: \"$SCHEDULE\""
+        exit 1
+    fi
+    CRON_MONTH=$(cron_field_numbers "$CRON_MONTH" 1 JAN FEB MAR APR MAY JUN JUL AUG SEP OCT NOV DEC)
+    CRON_DOW=$(cron_field_numbers "$CRON_DOW" 0 SUN MON TUE WED THU FRI SAT)
+    local field_check
+    local name
+    local field
+    local min
+    local max
+    for field_check in "minute|$CRON_MINUTE|0|59" "hour|$CRON_HOUR|0|23" "day|$CRON_DOM|1|31" \
+        "month|$CRON_MONTH|1|12" "weekday|$CRON_DOW|0|7"; do
+        IFS='|' read -r name field min max <<< "$field_check"
+        if ! cron_field_valid "$field" "$min" "$max"; then
+            echo "❌ Error: Invalid ${name} field \"${field}\" in schedule \"$SCHEDULE\""
+            echo "   Use numbers ${min}-${max}, '*', ranges (1-5), lists (1,15) and steps of 1 or more (*/15)"
+            exit 1
+        fi
+    done
+
+    if [ -z "$(load_regions)" ]; then
+        echo "❌ Error: No regions to refresh"
+        exit 1
+    fi
+
+    mkdir -p ./logs ./output ./cache
+    trap 'log "🛑 Daemon stopped"; exit 0' INT TERM
+
+    log "🕒 VNS refresh daemon started (schedule: \"${SCHEDULE}\", keeping ${KEEP_BACKUPS} backup(s) per region)"
+    if [ "$RUN_NOW" = "true" ]; then
+        run_cycle
+    fi
+
+    local next
+    while true; do
+        if ! next=$(next_run_epoch); then
+            log "❌ Schedule \"${SCHEDULE}\" never matches - exiting"
+            exit 1
+        fi
+        log "⏰ Next refresh: $(format_epoch "$next" '+%Y-%m-%d %H:%M')"
+        # Sleep in short chunks so stop signals are handled promptly
+        while [ "$(date +%s)" -lt "$next" ]; do
+            sleep 30 &
+            wait $!
+        done
+        run_cycle
+    done
+}
+
+main "$@"
@@ -0,0 +1,26 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Desktop Open
+#
+# Description:
+# Sourced by the scripts that hand a URL to the desktop browser
+# (list-regions.sh --open, route-test.sh). open_browser fails when there is no
+# browser to hand it to, e.g. over SSH or inside the container, so the caller
+# can print the URL instead.
+# ==============================================================================
+
+# Open a URL in the desktop browser: xdg-open (Linux), open (macOS) or
+# PowerShell (WSL/Git Bash)
+open_browser() {
+    local url="$1"
+    if command -v xdg-open >/dev/null 2>&1; then
+        xdg-open "$url" >/dev/null 2>&1
+    elif [ "$(uname -s)" = "Darwin" ] && command -v open >/dev/null 2>&1; then
+        open "$url" >/dev/null 2>&1
+    elif command -v powershell.exe >/dev/null 2>&1; then
+        powershell.exe -NoProfile -Command "Start-Process '$url'" >/dev/null 2>&1
+    else
+        return 1
+    fi
+}
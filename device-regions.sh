@@ -0,0 +1,183 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Device Regions
+#
+# Description:
+# Reads the VNS routing data already on a connected Android device (over
+# ADB), lists each region with the date of its map data, and checks whether
+# a newer build exists on this machine or newer data on Geofabrik. Offers to
+# rebuild exactly the regions that are out of date.
+#
+# Usage:
+# ./device-regions.sh                  # list regions on the connected device
+# ./device-regions.sh --yes            # rebuild the outdated ones without asking
+# ./device-regions.sh -s <serial>      # pick a device when several are connected
+# ./device-regions.sh --offline        # skip the Geofabrik check
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+[ -f "$(dirname "$0")/http-options.sh" ] && . "$(dirname "$0")/http-options.sh"
+
+DEVICE_GH_DIR="${VNS_DEVICE_DIR:-/storage/emulated/0/atak/tools/VNS/GH}"
+REGISTRY_FILE="./cache/registry.json"
+INDEX_CACHE_FILE="./cache/geofabrik-index.json"
+CHECK_UPDATES=true
+ASSUME_YES=false
+ADB=(adb)
+
+usage() {
+    echo "Usage: ./device-regions.sh [-s <serial>] [--offline] [--yes]"
+}
+
+# Run a shell command on the device; older adb versions end lines with \r
+device_shell() {
+    "${ADB[@]}" shell "$@" < /dev/null 2>/dev/null | tr -d '\r'
+}
+
+# Geofabrik region ID for a device folder name: the registry first, then
+# the region index (only if exactly one region has that name)
+region_id_for() {
+    local name="$1"
+    local id=""
+    if [ -s "$REGISTRY_FILE" ]; then
+        id=$(jq -r --arg name "$name" '.[$name].region_id // empty' "$REGISTRY_FILE")
+    fi
+    if [ -z "$id" ] && [ -s "$INDEX_CACHE_FILE" ]; then
+        id=$(jq -r --arg name "$name" \
+            '[.features[].properties.id | select(split("/") | last == $name)] | if length == 1 then .[0] else empty end' \
+            "$INDEX_CACHE_FILE")
+    fi
+    echo "$id"
+}
+
+# Seconds since the epoch for an ISO 8601 (graph timestamp) or HTTP date
+to_epoch() {
+    jq -rn --arg date "$1" '$date
+        | (try fromdateiso8601 catch null)
+          // (try (strptime("%a, %d %b %Y %H:%M:%S GMT") | mktime) catch null)
+          // empty'
+}
+
+# Last-Modified of a region's Geofabrik extract, empty if it cannot be reached
+remote_date() {
+    local url
+    url=$(jq -r --arg id "$1" '.features[] | select(.properties.id == $id) | .properties.urls.pbf // empty' "$INDEX_CACHE_FILE" 2>/dev/null)
+    [ -n "$url" ] || return 0
+    curl -sSI --max-time 15 "${CURL_OPTS[@]}" "$url" 2>/dev/null | grep -i '^Last-Modified:' | tail -n 1 | cut -d: -f2- | sed 's/^ *//' | tr -d '\r'
+}
+
+main() {
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            -s)
+                ADB=(adb -s "$2")
+                shift
+                ;;
+            --offline) CHECK_UPDATES=false ;;
+            --yes|-y)  ASSUME_YES=true ;;
+            -h|--help)
+                usage
+                exit 0
+                ;;
+            *)
+                echo "Error: Unknown option '$1'"
+                usage
+                exit 1
+                ;;
+        esac
+        shift
+    done
+
+    if ! command -v adb >/dev/null 2>&1; then
+        echo "❌ Error: adb is required but not installed"
+        echo "   Debian/Ubuntu: sudo apt install adb   macOS: brew install android-platform-tools"
+        echo "   Windows: install the Android SDK Platform-Tools and add them to PATH"
+        exit 1
+    fi
+    if ! command -v jq >/dev/null 2>&1; then
+        echo "❌ Error: jq is required but not installed (see ./list-regions.sh for install hints)"
+        exit 1
+    fi
+
+    local state
+    state=$("${ADB[@]}" get-state 2>&1 | tr -d '\r')
+    if [ "$state" != "device" ]; then
+        echo "❌ Error: No usable Android device (${state:-not found})"
+        echo "   Connect it over USB, enable USB debugging and accept the prompt on the device."
+        echo "   With several devices connected, pick one with -s <serial> (see: adb devices)."
+        exit 1
+    fi
+
+    echo "📱 VNS Regions on $(device_shell getprop ro.product.model)"
+    echo "=============================="
+    local folders
+    folders=$(device_shell ls -1 "$DEVICE_GH_DIR")
+    if [ -z "$folders" ] || echo "$folders" | grep -q 'No such file'; then
+        echo "📭 No VNS routing data found in ${DEVICE_GH_DIR}"
+        exit 0
+    fi
+    if [ "$CHECK_UPDATES" = "true" ] && [ ! -s "$INDEX_CACHE_FILE" ]; then
+        echo "📡 Downloading the region index..."
+        "$(dirname "$0")/list-regions.sh" >/dev/null 2>&1 || true
+    fi
+    [ "$CHECK_UPDATES" = "true" ] && echo "📡 Checking Geofabrik for newer data..."
+    echo ""
+    printf "  %-22s %-26s %-11s %6s  %s\n" "FOLDER" "REGION" "DATA" "AGE" "STATUS"
+
+    local now
+    now=$(date +%s)
+    local rebuild=()
+    local name id data_date data_epoch local_date latest label
+    while IFS= read -r name; do
+        [ -n "$name" ] || continue
+        data_date=$(device_shell cat "${DEVICE_GH_DIR}/${name}/timestamp" | head -n 1)
+        data_epoch=$(to_epoch "$data_date")
+        id=$(region_id_for "$name")
+
+        if [ -z "$data_epoch" ]; then
+            label="❓ no timestamp - not a VNS graph?"
+        elif [ -z "$id" ]; then
+            label="🗺️  custom area or unknown region"
+        else
+            local_date=$(head -n 1 "./output/${name}/timestamp" 2>/dev/null)
+            latest=""
+            [ "$CHECK_UPDATES" = "true" ] && latest=$(to_epoch "$(remote_date "$id")")
+            if [ -n "$local_date" ] && [ "$(to_epoch "$local_date")" -gt "$data_epoch" ] 2>/dev/null; then
+                label="📦 newer build on this machine (${local_date:0:10}) - copy it over"
+            elif [ -n "$latest" ] && [ "$latest" -gt $(( data_epoch + 2 * 86400 )) ]; then
+                # Geofabrik publishes an extract within hours of its data date
+                label="⚠️  update available"
+                rebuild+=("$id")
+            elif [ -n "$latest" ]; then
+                label="✅ current"
+            elif [ "$CHECK_UPDATES" = "true" ]; then
+                label="❓ could not check"
+            else
+                label="-"
+            fi
+        fi
+        printf "  %-22s %-26s %-11s %6s  %s\n" "$name" "${id:--}" "${data_epoch:+${data_date:0:10}}" \
+            "${data_epoch:+$(( (now - data_epoch) / 86400 ))d}" "$label"
+    done <<< "$folders"
+    echo ""
+
+    if [ ${#rebuild[@]} -eq 0 ]; then
+        echo "✅ Nothing to rebuild"
+        exit 0
+    fi
+    local answer=""
+    if [ "$ASSUME_YES" = "true" ]; then
+        answer="y"
+    elif [ -t 0 ]; then
+        read_answer "Rebuild these ${#rebuild[@]} regions? [Y/n] " answer || answer="n"
+        answer="${answer:-y}"
+    else
+        echo "💡 Rebuild them with: ./run.sh ${rebuild[*]}"
+    fi
+    if [[ "$answer" =~ ^[Yy] ]]; then
+        exec "$(dirname "$0")/run.sh" "${rebuild[@]}"
+    fi
+}
+
+main "$@"
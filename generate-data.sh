@@ -10,6 +10,8 @@
 # ==============================================================================
 
 set -e # Exit immediately if a command exits with a non-zero status.
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+[ -f "$(dirname "$0")/http-options.sh" ] && . "$(dirname "$0")/http-options.sh"
 
 # === COMPREHENSIVE LOGGING SYSTEM ===
 mkdir -p ./logs
@@ -38,9 +40,21 @@ log_system_info() {
     log_to_file ""
 }
 
+# ./logs lives inside the container and is gone once it exits, so each region
+# also keeps a build.log in the output volume (./output/logs/<region>/) with
+# the timestamped steps and events of its latest run. Started once the
+# region lock is held.
+BUILD_LOG=""
+
+log_timeline() {
+    [ -n "$BUILD_LOG" ] || return 0
+    echo "$(date -u +%Y-%m-%dT%H:%M:%SZ) $*" >> "$BUILD_LOG"
+}
+
 # Logging functions (file-only, no screen output)
 log_minimal() {
     log_to_file "MINIMAL_LOG: $*"
+    log_timeline "$*"
 }
 
 log_verbose() {
@@ -65,6 +79,7 @@ fi
 
 REGION_ID=$1
 DOWNLOAD_ONLY=false
+BENCHMARK_RUN=false
 
 # === SYSTEM BENCHMARK FUNCTION ===
 run_system_benchmark() {
@@ -87,6 +102,7 @@ run_system_benchmark() {
 predict_time_with_benchmark() {
     local file_size_mb=$1
     local user_benchmark_score=$2
+    local measured_factor=${3:-}         # from ./run.sh --benchmark, replaces the score
     local baseline_benchmark_score=250   # Reference system benchmark (modern system baseline)
     
     # Lookup table based on actual 5-state benchmark results (Aug 27, 2025)
@@ -116,8 +132,12 @@ predict_time_with_benchmark() {
     
     # Scale based on benchmark performance
     local scaled_time
-    scaled_time=$(awk -v base="$base_time" -v user="$user_benchmark_score" -v baseline="$baseline_benchmark_score" '
+    scaled_time=$(awk -v base="$base_time" -v user="$user_benchmark_score" -v baseline="$baseline_benchmark_score" -v measured="$measured_factor" '
         BEGIN { 
+            if (measured != "") {
+                printf "%.0f", base * measured
+                exit
+            }
             scale_factor = user / baseline
             # Cap scaling between 0.3x and 3x for safety
             if (scale_factor < 0.3) scale_factor = 0.3
@@ -128,33 +148,692 @@ predict_time_with_benchmark() {
     echo "$scaled_time"
 }
 
+# === MEASURED PERFORMANCE FACTOR ===
+# --benchmark (./run.sh --benchmark) imports a small extract and stores how
+# long this machine took compared with the reference system behind the
+# lookup table above (Delaware, 20MB in 6s: 0.3s per MB). Later estimates
+# are scaled by that factor instead of the quick gzip benchmark.
+BENCHMARK_FILE="./cache/benchmark.json"
+REFERENCE_SEC_PER_MB="0.3"
+
+stored_benchmark_factor() {
+    [ -s "$BENCHMARK_FILE" ] || return 0
+    jq -r '.factor // empty' "$BENCHMARK_FILE" 2>/dev/null || true
+}
+
+save_benchmark() {
+    local import_sec="$1"
+    local extract_mb="$2"
+    local factor
+    factor=$(awk -v sec="$import_sec" -v mb="$extract_mb" -v ref="$REFERENCE_SEC_PER_MB" '
+        BEGIN {
+            if (sec < 1) sec = 1
+            if (mb < 1) mb = 1
+            f = sec / (mb * ref)
+            # A wider cap than the gzip scaling: this is a real import
+            if (f < 0.2) f = 0.2
+            if (f > 10) f = 10
+            printf "%.2f", f
+        }')
+    mkdir -p "$(dirname "$BENCHMARK_FILE")"
+    jq -n --argjson factor "$factor" --argjson import_sec "$import_sec" --argjson extract_mb "$extract_mb" \
+        --arg region "$REGION_ID" --arg measured_at "$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+        --argjson cores "$(nproc 2>/dev/null || echo 1)" --argjson memory_mb "${ALLOCATED_MEMORY_MB:-0}" \
+        '{factor: $factor, import_sec: $import_sec, extract_mb: $extract_mb, region: $region,
+          measured_at: $measured_at, cores: $cores, memory_mb: $memory_mb}' \
+        > "${BENCHMARK_FILE}.tmp"
+    mv "${BENCHMARK_FILE}.tmp" "$BENCHMARK_FILE"
+    echo "⏱️  Imported ${extract_mb}MB in ${import_sec}s - performance factor ${factor} $(awk -v f="$factor" 'BEGIN {
+        if (f < 0.95) printf "(%.1fx faster than the reference system)", 1 / f
+        else if (f > 1.05) printf "(%.1fx slower than the reference system)", f
+        else print "(as fast as the reference system)"
+    }')"
+    echo "📊 Saved to ${BENCHMARK_FILE} - time estimates on this machine now use it"
+}
+
 # Initialize logging now that REGION_ID is defined
 log_system_info "$@"
 
-# Check for --download-only flag
-if [ "$2" = "--download-only" ]; then
-    DOWNLOAD_ONLY=true
+# --- Option Parsing ---
+# Every option can also be set through its VNS_* environment variable, which
+# run.sh forwards into the container.
+OUTPUT_FORMAT="${VNS_FORMAT:-zip}"
+COMPRESSION="${VNS_COMPRESSION:-default}"
+TEMP_DIR="${VNS_TEMP_DIR:-}"
+LOCK_WAIT="${VNS_LOCK_WAIT:-false}"
+REFRESH_INDEX="${VNS_REFRESH_INDEX:-false}"
+AREA_BBOX="${VNS_BBOX:-}"
+AREA_POLY="${VNS_POLY:-}"
+FILTER_ROUTING="${VNS_FILTER_ROUTING:-false}"
+CLIP_TO_POLY="${VNS_CLIP:-false}"
+IMPORT_TIMEOUT="${VNS_TIMEOUT:-}"
+JVM_GC="${VNS_JVM_GC:-g1}"
+JVM_MAX_RAM_PERCENTAGE="${VNS_JVM_MAX_RAM_PERCENTAGE:-}"
+JVM_EXTRA_OPTS="${VNS_JVM_OPTS:-}"
+OOM_RETRY="${VNS_OOM_RETRY:-true}"
+IMPORT_THREADS="${VNS_THREADS:-}"
+BUILD_NICE="${VNS_NICE:-}"
+OFFLINE="${VNS_OFFLINE:-false}"
+KEEP_BUILDS="${VNS_KEEP_BUILDS:-0}"
+PACKAGE_ONLY="${VNS_PACKAGE_ONLY:-false}"
+DELTA="${VNS_DELTA:-false}"
+ZSYNC="${VNS_ZSYNC:-false}"
+
+shift
+while [ $# -gt 0 ]; do
+    case "$1" in
+        --download-only)
+            DOWNLOAD_ONLY=true
+            ;;
+        --format)
+            OUTPUT_FORMAT="$2"
+            shift
+            ;;
+        --format=*)
+            OUTPUT_FORMAT="${1#*=}"
+            ;;
+        --compression)
+            COMPRESSION="$2"
+            shift
+            ;;
+        --compression=*)
+            COMPRESSION="${1#*=}"
+            ;;
+        --temp-dir)
+            TEMP_DIR="$2"
+            shift
+            ;;
+        --temp-dir=*)
+            TEMP_DIR="${1#*=}"
+            ;;
+        --wait-for-lock)
+            LOCK_WAIT=true
+            ;;
+        --refresh)
+            REFRESH_INDEX=true
+            ;;
+        --bbox)
+            AREA_BBOX="$2"
+            shift
+            ;;
+        --bbox=*)
+            AREA_BBOX="${1#*=}"
+            ;;
+        --poly)
+            AREA_POLY="$2"
+            shift
+            ;;
+        --poly=*)
+            AREA_POLY="${1#*=}"
+            ;;
+        --filter-routing)
+            FILTER_ROUTING=true
+            ;;
+        --clip)
+            CLIP_TO_POLY=true
+            ;;
+        --timeout)
+            IMPORT_TIMEOUT="$2"
+            shift
+            ;;
+        --timeout=*)
+            IMPORT_TIMEOUT="${1#*=}"
+            ;;
+        --threads)
+            IMPORT_THREADS="$2"
+            shift
+            ;;
+        --threads=*)
+            IMPORT_THREADS="${1#*=}"
+            ;;
+        --nice)
+            BUILD_NICE="$2"
+            shift
+            ;;
+        --nice=*)
+            BUILD_NICE="${1#*=}"
+            ;;
+        --offline)
+            OFFLINE=true
+            ;;
+        --keep)
+            KEEP_BUILDS="$2"
+            shift
+            ;;
+        --keep=*)
+            KEEP_BUILDS="${1#*=}"
+            ;;
+        --package-only)
+            PACKAGE_ONLY=true
+            ;;
+        --delta)
+            DELTA=true
+            ;;
+        --zsync)
+            ZSYNC=true
+            ;;
+        --benchmark)
+            BENCHMARK_RUN=true
+            ;;
+        *)
+            echo "Error: Unknown option '$1'"
+            echo "Usage: ./generate-data.sh <region-id> [--download-only] [--format zip|tar.gz|dir]"
+            echo "                                        [--compression store|fast|default|max]"
+            echo "                                        [--temp-dir <path>] [--wait-for-lock] [--refresh]"
+            echo "                                        [--bbox <minlon,minlat,maxlon,maxlat> | --poly <file.poly>]"
+            echo "                                        [--filter-routing] [--clip] [--timeout <4h|90m|...>]"
+            echo "                                        [--threads <n>] [--nice <0-19>] [--offline] [--keep <n>]"
+            echo "                                        [--package-only] [--delta] [--zsync] [--benchmark]"
+            exit 1
+            ;;
+    esac
+    shift
+done
+
+case "$OUTPUT_FORMAT" in
+    zip|tar.gz|dir) ;;
+    *)
+        echo "Error: Unsupported output format '$OUTPUT_FORMAT'"
+        echo "Supported formats: zip (default), tar.gz, dir"
+        exit 1
+        ;;
+esac
+
+if [ "$PACKAGE_ONLY" = "true" ] && [ "$OUTPUT_FORMAT" = "dir" ]; then
+    echo "Error: --package-only needs a package format (zip or tar.gz), not dir"
+    exit 1
+fi
+if [ "$DELTA" = "true" ] && [ "$OUTPUT_FORMAT" = "dir" ]; then
+    echo "Error: --delta needs a package format (zip or tar.gz), not dir"
+    exit 1
+fi
+if [ "$BENCHMARK_RUN" = "true" ] && { [ "$DOWNLOAD_ONLY" = "true" ] || [ -n "$AREA_BBOX" ] || [ -n "$AREA_POLY" ]; }; then
+    echo "Error: --benchmark needs a Geofabrik region to import, without --download-only"
+    exit 1
+fi
+# zsync can only reuse blocks that are byte-identical between two packages,
+# which deflate prevents: the zip entries are stored instead
+if [ "$ZSYNC" = "true" ]; then
+    if [ "$OUTPUT_FORMAT" != "zip" ]; then
+        echo "Error: --zsync needs the zip format, not ${OUTPUT_FORMAT}"
+        exit 1
+    fi
+    COMPRESSION=store
+fi
+
+# Map the compression setting to a deflate level (0 = store only)
+case "$COMPRESSION" in
+    store)   COMPRESSION_LEVEL=0 ;;
+    fast)    COMPRESSION_LEVEL=1 ;;
+    default) COMPRESSION_LEVEL=6 ;;
+    max)     COMPRESSION_LEVEL=9 ;;
+    *)
+        echo "Error: Unsupported compression level '$COMPRESSION'"
+        echo "Supported levels: store, fast, default, max"
+        exit 1
+        ;;
+esac
+
+# The import timeout uses timeout(1) syntax: a number with an optional
+# s/m/h/d suffix, e.g. 4h
+if [ -n "$IMPORT_TIMEOUT" ] && ! [[ "$IMPORT_TIMEOUT" =~ ^[0-9]+[smhd]?$ ]]; then
+    echo "Error: Invalid timeout '$IMPORT_TIMEOUT'"
+    echo "Use a number with an optional unit, e.g. 90m, 4h or 1d"
+    exit 1
+fi
+
+case "$JVM_GC" in
+    g1|parallel|serial|zgc) ;;
+    *)
+        echo "Error: Unsupported garbage collector VNS_JVM_GC='$JVM_GC'"
+        echo "Supported collectors: g1 (default), parallel, serial, zgc"
+        exit 1
+        ;;
+esac
+
+if [ -n "$JVM_MAX_RAM_PERCENTAGE" ] && ! { [[ "$JVM_MAX_RAM_PERCENTAGE" =~ ^[0-9]+$ ]] && [ "$JVM_MAX_RAM_PERCENTAGE" -ge 1 ] && [ "$JVM_MAX_RAM_PERCENTAGE" -le 100 ]; }; then
+    echo "Error: VNS_JVM_MAX_RAM_PERCENTAGE must be a whole number between 1 and 100"
+    exit 1
+fi
+
+if [ -n "$IMPORT_THREADS" ] && ! { [[ "$IMPORT_THREADS" =~ ^[0-9]+$ ]] && [ "$IMPORT_THREADS" -ge 1 ]; }; then
+    echo "Error: Invalid thread count '$IMPORT_THREADS'"
+    echo "Use a whole number of 1 or more, e.g. --threads 4"
+    exit 1
+fi
+
+if [ -n "$BUILD_NICE" ] && ! { [[ "$BUILD_NICE" =~ ^-?[0-9]+$ ]] && [ "$BUILD_NICE" -ge -20 ] && [ "$BUILD_NICE" -le 19 ]; }; then
+    echo "Error: Invalid niceness '$BUILD_NICE'"
+    echo "Use a number from 0 (normal priority) to 19 (lowest priority)"
+    exit 1
+fi
+
+if ! [[ "$KEEP_BUILDS" =~ ^[0-9]+$ ]]; then
+    echo "Error: Invalid number of builds to keep '$KEEP_BUILDS'"
+    echo "Use a whole number, e.g. --keep 3 (0 keeps no dated copies)"
+    exit 1
+fi
+
+# --nice lowers the priority of the whole build (download checks, import,
+# compression) so a shared workstation stays responsive
+if [ -n "$BUILD_NICE" ]; then
+    if renice -n "$BUILD_NICE" -p $$ >/dev/null 2>&1; then
+        echo "🐢 Running at niceness ${BUILD_NICE}"
+    else
+        echo "⚠️  Could not change priority to niceness ${BUILD_NICE} (raising priority needs root)"
+    fi
+fi
+
+# Worker threads for archive compression (defaults to --threads, or all cores)
+COMPRESSION_THREADS="${VNS_COMPRESSION_THREADS:-${IMPORT_THREADS:-$(nproc 2>/dev/null || echo 1)}}"
+
+if [ "$DOWNLOAD_ONLY" = "true" ]; then
     echo "🔽 DOWNLOAD-ONLY MODE: Will download files but skip GraphHopper processing"
 fi
 REGION_NAME=$(basename "$REGION_ID")
 FILENAME="${REGION_NAME}-latest"
-OSM_FILE="${FILENAME}.osm.pbf"
-POLY_FILE="${REGION_NAME}.poly"
-KML_FILE="${REGION_NAME}.kml"
 GRAPH_FOLDER="${REGION_NAME}"
+REGION_LOG_DIR="./output/logs/${REGION_NAME}"
+# A benchmark shares the cached extract and the lock of its region, but
+# builds under a name of its own and keeps no output
+if [ "$BENCHMARK_RUN" = "true" ]; then
+    echo "⏱️  BENCHMARK MODE: timing the import of '${REGION_ID}' to calibrate time estimates"
+    GRAPH_FOLDER="vns-benchmark"
+    REGION_LOG_DIR="./output/logs/${GRAPH_FOLDER}"
+fi
+IMPORT_LOG="${REGION_LOG_DIR}/import.log"
+
+# --- Region Locking ---
+# Two runs building the same region would clobber each other's working files
+# and output. An advisory lock per region, kept in the shared cache, makes a
+# second run fail with an explanation - or queue behind the first with
+# --wait-for-lock / VNS_LOCK_WAIT=true. Locks whose holder died are detected
+# as stale and taken over.
+LOCK_DIR="./cache/locks"
+LOCK_FILE="${LOCK_DIR}/${REGION_NAME}.lock"
+LOCK_STALE_HOURS="${VNS_LOCK_STALE_HOURS:-24}"
+LOCK_HELD="false"
+
+lock_owner_info() {
+    echo "host=$(hostname) pid=$$ started=$(date -u +%Y-%m-%dT%H:%M:%SZ) region=$REGION_ID"
+}
+
+report_lock_conflict() {
+    local owner="$1"
+    echo "❌ Error: Region '${REGION_ID}' is already being processed by another run"
+    echo "   Lock holder: ${owner:-unknown}"
+    echo "   Lock file:   ${LOCK_FILE}"
+    echo ""
+    echo "💡 Wait for the other run to finish, or queue behind it with:"
+    echo "   ./run.sh ${REGION_ID} --wait-for-lock"
+}
+
+# Fallback lock (no flock): a directory whose owner process is gone, or
+# which is older than VNS_LOCK_STALE_HOURS, is considered stale
+lock_is_stale() {
+    local lock_file="${1:-$LOCK_FILE}"
+    local owner_file="${lock_file}.d/owner"
+    local owner_host
+    local owner_pid
+    owner_host=$(sed -n 's/.*host=\([^ ]*\).*/\1/p' "$owner_file" 2>/dev/null)
+    owner_pid=$(sed -n 's/.*pid=\([0-9]*\).*/\1/p' "$owner_file" 2>/dev/null)
+    if [ "$owner_host" = "$(hostname)" ] && [ -n "$owner_pid" ] && ! kill -0 "$owner_pid" 2>/dev/null; then
+        return 0
+    fi
+    [ -n "$(find "${lock_file}.d" -maxdepth 0 -mmin +$((LOCK_STALE_HOURS * 60)) 2>/dev/null)" ]
+}
+
+acquire_region_lock() {
+    mkdir -p "$LOCK_DIR"
+    if command -v flock >/dev/null 2>&1; then
+        # Opened for append so waiting runs don't wipe the owner info
+        exec 9>>"$LOCK_FILE"
+        if ! flock -n 9; then
+            if [ "$LOCK_WAIT" != "true" ]; then
+                report_lock_conflict "$(cat "$LOCK_FILE" 2>/dev/null)"
+                exit 1
+            fi
+            echo "⏳ Region '${REGION_ID}' is locked by another run ($(cat "$LOCK_FILE" 2>/dev/null)) - waiting..."
+            flock 9
+        fi
+        # Owner info left in a free lock means its holder died mid-run
+        if [ -s "$LOCK_FILE" ]; then
+            echo "♻️  Taking over stale lock ($(cat "$LOCK_FILE"))"
+        fi
+        lock_owner_info > "$LOCK_FILE"
+    else
+        local announced="false"
+        # mkdir is atomic everywhere, including network filesystems
+        while ! mkdir "${LOCK_FILE}.d" 2>/dev/null; do
+            if lock_is_stale; then
+                echo "♻️  Taking over stale lock ($(cat "${LOCK_FILE}.d/owner" 2>/dev/null))"
+                rm -rf "${LOCK_FILE}.d"
+                continue
+            fi
+            if [ "$LOCK_WAIT" != "true" ]; then
+                report_lock_conflict "$(cat "${LOCK_FILE}.d/owner" 2>/dev/null)"
+                exit 1
+            fi
+            if [ "$announced" = "false" ]; then
+                echo "⏳ Region '${REGION_ID}' is locked by another run - waiting..."
+                announced="true"
+            fi
+            sleep 30
+        done
+        lock_owner_info > "${LOCK_FILE}.d/owner"
+    fi
+    LOCK_HELD="true"
+    mkdir -p "$REGION_LOG_DIR"
+    BUILD_LOG="${REGION_LOG_DIR}/build.log"
+    echo "=== ${REGION_ID}: run started $(date -u +%Y-%m-%dT%H:%M:%SZ) on $(hostname) ===" > "$BUILD_LOG"
+    rm -f "${REGION_LOG_DIR}/out-of-memory"
+    log_minimal "lock_acquired: region=$REGION_NAME"
+}
+
+release_region_lock() {
+    [ "$LOCK_HELD" = "true" ] || return 0
+    if command -v flock >/dev/null 2>&1; then
+        # Empty the owner info; the lock itself is released when fd 9 closes
+        : > "$LOCK_FILE"
+    else
+        rm -rf "${LOCK_FILE}.d"
+    fi
+    LOCK_HELD="false"
+}
+
+# Lock for one file in the shared download cache (VNS_SHARED_CACHE), held
+# while it is downloaded into or copied out of the share. Defined here so the
+# exit handler can release it however early the run ends.
+SHARED_LOCK_FILE=""
+
+acquire_shared_lock() {
+    local name="$1"
+    mkdir -p "${SHARED_CACHE_DIR}/locks"
+    SHARED_LOCK_FILE="${SHARED_CACHE_DIR}/locks/${name}.lock"
+    if command -v flock >/dev/null 2>&1; then
+        exec 8>>"$SHARED_LOCK_FILE"
+        if ! flock -n 8; then
+            echo "⏳ ${name} is being downloaded into the shared cache ($(cat "$SHARED_LOCK_FILE" 2>/dev/null)) - waiting..."
+            flock 8
+        fi
+        lock_owner_info > "$SHARED_LOCK_FILE"
+    else
+        local announced="false"
+        while ! mkdir "${SHARED_LOCK_FILE}.d" 2>/dev/null; do
+            if lock_is_stale "$SHARED_LOCK_FILE"; then
+                rm -rf "${SHARED_LOCK_FILE}.d"
+                continue
+            fi
+            if [ "$announced" = "false" ]; then
+                echo "⏳ ${name} is being downloaded into the shared cache - waiting..."
+                announced="true"
+            fi
+            sleep 10
+        done
+        lock_owner_info > "${SHARED_LOCK_FILE}.d/owner"
+    fi
+}
+
+release_shared_lock() {
+    [ -n "$SHARED_LOCK_FILE" ] || return 0
+    if command -v flock >/dev/null 2>&1; then
+        : > "$SHARED_LOCK_FILE"
+        exec 8>&-
+    else
+        rm -rf "${SHARED_LOCK_FILE}.d"
+    fi
+    SHARED_LOCK_FILE=""
+}
+
+acquire_region_lock
+
+# --- Metrics ---
+# With VNS_METRICS_FILE set, every run updates a Prometheus text-format file
+# (for node_exporter's textfile collector, or any scraper that can read it):
+# bytes downloaded, regions processed by result, failures by step, per-step
+# durations and queue depth. Counters persist across runs in a sidecar state
+# file next to the metrics file.
+METRICS_FILE="${VNS_METRICS_FILE:-}"
+RUN_RESULT="failure"
+RUN_STARTED_AT=$(date +%s)
+CURRENT_STEP="setup"
+DOWNLOADED_BYTES=0
+declare -A STEP_DURATIONS=()
+
+# Seconds as 45s, 12m30s or 2h05m
+format_duration() {
+    local seconds="$1"
+    if [ "$seconds" -ge 3600 ]; then
+        printf "%dh%02dm" $(( seconds / 3600 )) $(( seconds % 3600 / 60 ))
+    elif [ "$seconds" -ge 60 ]; then
+        printf "%dm%02ds" $(( seconds / 60 )) $(( seconds % 60 ))
+    else
+        printf "%ds" "$seconds"
+    fi
+}
+
+# Start timing a pipeline step (also recorded as the failing step on error)
+begin_step() {
+    CURRENT_STEP="$1"
+    STEP_STARTED_AT=$(date +%s)
+    log_timeline "step ${CURRENT_STEP} started"
+}
+
+end_step() {
+    STEP_DURATIONS[$CURRENT_STEP]=$(( $(date +%s) - STEP_STARTED_AT ))
+    log_timeline "step ${CURRENT_STEP} finished in ${STEP_DURATIONS[$CURRENT_STEP]}s"
+    echo "⏱️  ${CURRENT_STEP^} step took $(format_duration "${STEP_DURATIONS[$CURRENT_STEP]}")"
+}
+
+# Time spent per step, in pipeline order: "download 1m05s, import 12m30s, ..."
+step_breakdown() {
+    local step
+    local breakdown=""
+    for step in download import organize package; do
+        if [ -n "${STEP_DURATIONS[$step]:-}" ]; then
+            breakdown+="${breakdown:+, }${step} $(format_duration "${STEP_DURATIONS[$step]}")"
+        fi
+    done
+    echo "$breakdown"
+}
+
+# Add to (mode=add) or overwrite (mode=set) one sample in the state file
+metric_update() {
+    local state="$1"
+    local mode="$2"
+    local key="$3"
+    local value="$4"
+    touch "$state"
+    awk -v key="$key" -v value="$value" -v mode="$mode" '
+        { k = $0; sub(/ [^ ]*$/, "", k); v = $NF }
+        k == key { found = 1; v = (mode == "add") ? v + value : value; printf "%s %.0f\n", k, v; next }
+        { print }
+        END { if (!found) printf "%s %.0f\n", key, value }
+    ' "$state" > "${state}.tmp" && mv "${state}.tmp" "$state"
+}
+
+render_metrics() {
+    local state="$1"
+    local name
+    local type
+    local help
+    while IFS='|' read -r name type help; do
+        grep -q "^${name}[{ ]" "$state" || continue
+        echo "# HELP ${name} ${help}"
+        echo "# TYPE ${name} ${type}"
+        grep "^${name}[{ ]" "$state"
+    done <<'METRICS'
+vns_downloaded_bytes_total|counter|Bytes downloaded from Geofabrik.
+vns_regions_processed_total|counter|Region runs by result (success, up_to_date, failure, cancelled).
+vns_failures_total|counter|Failed region runs by the step that failed.
+vns_step_duration_seconds|gauge|Duration of each step in the most recent run of a region.
+vns_last_success_timestamp_seconds|gauge|Unix time of the last successful build of a region.
+vns_queue_depth|gauge|Regions still waiting in the current batch.
+METRICS
+}
+
+record_run_metrics() {
+    [ -n "$METRICS_FILE" ] || return 0
+    local state="${METRICS_FILE}.state"
+    local step
+    mkdir -p "$(dirname "$METRICS_FILE")"
+    (
+        # Serialize updates from concurrent region runs
+        command -v flock >/dev/null 2>&1 && flock 8
+        metric_update "$state" add "vns_downloaded_bytes_total" "$DOWNLOADED_BYTES"
+        metric_update "$state" add "vns_regions_processed_total{result=\"${RUN_RESULT}\"}" 1
+        if [ "$RUN_RESULT" = "failure" ]; then
+            metric_update "$state" add "vns_failures_total{step=\"${CURRENT_STEP}\"}" 1
+        fi
+        for step in "${!STEP_DURATIONS[@]}"; do
+            metric_update "$state" set "vns_step_duration_seconds{region=\"${REGION_NAME}\",step=\"${step}\"}" "${STEP_DURATIONS[$step]}"
+        done
+        if [ "$RUN_RESULT" = "success" ]; then
+            metric_update "$state" set "vns_last_success_timestamp_seconds{region=\"${REGION_NAME}\"}" "$(date +%s)"
+        fi
+        if [ -n "$VNS_QUEUE_DEPTH" ]; then
+            metric_update "$state" set "vns_queue_depth" "$VNS_QUEUE_DEPTH"
+        fi
+        render_metrics "$state" > "${METRICS_FILE}.tmp" && mv "${METRICS_FILE}.tmp" "$METRICS_FILE"
+    ) 8>>"${METRICS_FILE}.lock"
+}
 
 # --- Fetch URLs from Geofabrik API ---
-echo "Fetching region URLs from Geofabrik API..."
 
-GEOFABRIK_INDEX_URL="https://download.geofabrik.de/index-v1-nogeom.json"
+GEOFABRIK_BASE_URL="https://download.geofabrik.de/"
+GEOFABRIK_INDEX_URL="${GEOFABRIK_BASE_URL}index-v1-nogeom.json"
 retry_count=0
 max_retries=10
+
+# The index is cached in ./cache and revalidated once it is older than 24h
+# (or with --refresh) using the stored ETag, so an unchanged index is not
+# downloaded again. The cached file only changes when the content does;
+# the .checked marker records the last successful revalidation.
+INDEX_CACHE_FILE="./cache/geofabrik-index.json"
+INDEX_ETAG_FILE="${INDEX_CACHE_FILE}.etag"
+INDEX_CHECKED_FILE="${INDEX_CACHE_FILE}.checked"
+INDEX_MAX_AGE_MINUTES=$(( ${VNS_INDEX_MAX_AGE_HOURS:-24} * 60 ))
+# With VNS_SHARED_CACHE the index is shared with the team as well
+SHARED_INDEX_FILE="${VNS_SHARED_CACHE:+${VNS_SHARED_CACHE}/${INDEX_CACHE_FILE##*/}}"
+mkdir -p ./cache
 if ! WGET_ERR=$(mktemp 2>/dev/null) || [ -z "$WGET_ERR" ]; then
     WGET_ERR="/tmp/vns-wget-err.$$"
     : > "$WGET_ERR" 2>/dev/null || WGET_ERR="/dev/null"
 fi
-# Remove the temp file on any exit (including Ctrl-C), not just the happy path.
-[ "$WGET_ERR" != "/dev/null" ] && trap 'rm -f "$WGET_ERR"' EXIT
+# --- Webhook Notifications ---
+# With VNS_WEBHOOK_URL set, a JSON summary of every run is POSTed to that URL
+# (Slack/Discord relays, ntfy, CI triggers, ...). Delivery problems are
+# reported but never fail the build.
+WEBHOOK_URL="${VNS_WEBHOOK_URL:-}"
+
+send_webhook() {
+    local exit_code="$1"
+    [ -n "$WEBHOOK_URL" ] || return 0
+    [ "$OFFLINE" != "true" ] || return 0
+
+    local event="region.completed"
+    local error=""
+    local package="${PACKAGE_FILE:+./output/${PACKAGE_FILE}}"
+    case "$RUN_RESULT" in
+        failure)
+            event="region.failed"
+            error="failed during ${CURRENT_STEP} step (exit code ${exit_code})"
+            package=""
+            ;;
+        cancelled)
+            event="region.failed"
+            error="cancelled during ${CURRENT_STEP} step"
+            package=""
+            ;;
+    esac
+
+    local payload
+    payload=$(jq -nc \
+        --arg event "$event" \
+        --arg region "$REGION_NAME" \
+        --arg region_id "$REGION_ID" \
+        --arg result "$RUN_RESULT" \
+        --argjson duration "$(( $(date +%s) - RUN_STARTED_AT ))" \
+        --arg output_path "./output/${GRAPH_FOLDER}" \
+        --arg package "$package" \
+        --arg error "$error" \
+        --arg host "$(hostname)" \
+        '{event: $event, region: $region, region_id: $region_id, result: $result,
+          duration_seconds: $duration, output_path: $output_path,
+          package: (if $package == "" then null else $package end),
+          error: (if $error == "" then null else $error end), host: $host}')
+
+    if ! wget -q -O /dev/null --tries=1 --timeout=10 "${WGET_OPTS[@]}" \
+        --header "Content-Type: application/json" \
+        --post-data "$payload" "$WEBHOOK_URL" 2>/dev/null; then
+        echo "⚠️  Webhook notification to ${WEBHOOK_URL} failed"
+    fi
+}
+
+# --- Hook Scripts ---
+# Executables in ./hooks (or VNS_HOOKS_DIR) named pre-download, post-import,
+# post-zip or on-failure (optionally with a .sh suffix) are run at those
+# points with VNS_* variables describing the region and its paths. A failing
+# hook fails the build, except on-failure whose errors are only reported.
+HOOKS_DIR="${VNS_HOOKS_DIR:-./hooks}"
+
+run_hook() {
+    local name="$1"
+    local hook=""
+    local candidate
+    for candidate in "${HOOKS_DIR}/${name}" "${HOOKS_DIR}/${name}.sh"; do
+        if [ -f "$candidate" ]; then
+            hook="$candidate"
+            break
+        fi
+    done
+    [ -n "$hook" ] || return 0
+
+    local runner=()
+    [ -x "$hook" ] || runner=(bash)
+
+    echo "🪝 Running ${name} hook: ${hook}"
+    local status=0
+    VNS_HOOK="$name" \
+    VNS_REGION_ID="$REGION_ID" \
+    VNS_REGION_NAME="$REGION_NAME" \
+    VNS_OSM_FILE="$OSM_FILE" \
+    VNS_WORK_DIR="$WORK_GRAPH_DIR" \
+    VNS_OUTPUT_DIR="$(pwd)/output/${GRAPH_FOLDER}" \
+    VNS_PACKAGE_FILE="${PACKAGE_FILE:+$(pwd)/output/${PACKAGE_FILE}}" \
+    VNS_RESULT="$RUN_RESULT" \
+    VNS_FAILED_STEP="$CURRENT_STEP" \
+    VNS_EXIT_CODE="${HOOK_EXIT_CODE:-0}" \
+        "${runner[@]}" "$hook" || status=$?
+
+    if [ "$status" -ne 0 ]; then
+        if [ "$name" = "on-failure" ]; then
+            echo "⚠️  on-failure hook exited with status ${status}"
+        else
+            echo "❌ Error: ${name} hook failed with status ${status}"
+            exit 1
+        fi
+    fi
+}
+
+# Remove the temp file and release the region lock on any exit (including
+# Ctrl-C), not just the happy path.
+on_exit() {
+    local exit_code=$?
+    [ "$WGET_ERR" != "/dev/null" ] && rm -f "$WGET_ERR"
+    if [ "$RUN_RESULT" = "failure" ] || [ "$RUN_RESULT" = "cancelled" ]; then
+        HOOK_EXIT_CODE="$exit_code" run_hook on-failure
+    fi
+    log_timeline "run finished: result=${RUN_RESULT}, step=${CURRENT_STEP}, exit_code=${exit_code}"
+    # A benchmark builds nothing to report
+    if [ "$BENCHMARK_RUN" != "true" ]; then
+        record_run_metrics
+        send_webhook "$exit_code"
+    fi
+    release_shared_lock
+    release_region_lock
+}
+trap on_exit EXIT
 
 # Portable DNS resolution check; getent may be absent from minimal images.
 dns_check_host() {
@@ -169,81 +848,299 @@ dns_check_host() {
     echo "could not check (no getent/nslookup available)"
 }
 
-while [ $retry_count -lt $max_retries ]; do
-    # Try a normal (dual-stack) request first; on failure, retry forcing IPv4
-    # (-4) for hosts/containers where IPv6 is present but broken. Real errors
-    # are captured to $WGET_ERR so we can surface them if all attempts fail.
-    # NOTE: use -nv (not -q): -q silences the very stderr we need to capture.
-    # --tries=1 --timeout=30 makes each attempt fail fast instead of letting
-    # wget burn its own internal retries (which made the loop appear to hang).
-    if API_RESPONSE=$(wget -nv --tries=1 --timeout=30 -O- "$GEOFABRIK_INDEX_URL" 2>>"$WGET_ERR") && [ -n "$API_RESPONSE" ]; then
-        break
+index_cache_fresh() {
+    [ "$REFRESH_INDEX" != "true" ] && [ -s "$INDEX_CACHE_FILE" ] && [ -f "$INDEX_CHECKED_FILE" ] \
+        && [ -z "$(find "$INDEX_CHECKED_FILE" -mmin +"$INDEX_MAX_AGE_MINUTES" 2>/dev/null)" ]
+}
+
+# Take over the shared index when another host checked it more recently than
+# we did and within the maximum age. Its ETag is not copied: our next check
+# then downloads the index again instead of trusting an ETag that may belong
+# to a newer version than the file.
+adopt_shared_index() {
+    if [ -z "$SHARED_INDEX_FILE" ] || [ "$REFRESH_INDEX" = "true" ] \
+        || [ ! -s "$SHARED_INDEX_FILE" ] || [ ! -f "${SHARED_INDEX_FILE}.checked" ]; then
+        return 0
     fi
-    if API_RESPONSE=$(wget -4 -nv --tries=1 --timeout=30 -O- "$GEOFABRIK_INDEX_URL" 2>>"$WGET_ERR") && [ -n "$API_RESPONSE" ]; then
-        break
+    if [ -f "$INDEX_CHECKED_FILE" ] && [ ! "${SHARED_INDEX_FILE}.checked" -nt "$INDEX_CHECKED_FILE" ]; then
+        return 0
     fi
-    retry_count=$((retry_count + 1))
-    echo "⚠️  Retry $retry_count/$max_retries - Failed to fetch region data from Geofabrik API"
-    sleep 2
-done
+    if [ -n "$(find "${SHARED_INDEX_FILE}.checked" -mmin +"$INDEX_MAX_AGE_MINUTES" 2>/dev/null)" ]; then
+        return 0
+    fi
+    local index_tmp
+    index_tmp=$(mktemp "${INDEX_CACHE_FILE}.XXXXXX") || return 0
+    if cp "$SHARED_INDEX_FILE" "$index_tmp" && jq -e '.features' "$index_tmp" >/dev/null 2>&1; then
+        chmod 644 "$index_tmp"
+        mv "$index_tmp" "$INDEX_CACHE_FILE"
+        rm -f "$INDEX_ETAG_FILE"
+        touch -r "${SHARED_INDEX_FILE}.checked" "$INDEX_CHECKED_FILE"
+        echo "📂 Using the region index from the shared cache"
+    else
+        rm -f "$index_tmp"
+    fi
+}
 
-if [ $retry_count -eq $max_retries ]; then
-    echo "❌ Error: Failed to fetch region data from Geofabrik API after $max_retries attempts"
-    echo ""
-    echo "----- Actual error reported by wget -----"
-    if [ -s "$WGET_ERR" ]; then
-        tail -n 5 "$WGET_ERR"
+# Publish a fetched or revalidated index; the .checked marker tells the other
+# hosts how fresh it is. Failing to only costs them a request.
+publish_shared_index() {
+    [ -n "$SHARED_INDEX_FILE" ] || return 0
+    local shared_tmp
+    shared_tmp="${SHARED_INDEX_FILE}.$(hostname).tmp"
+    if cp "$INDEX_CACHE_FILE" "$shared_tmp" && mv "$shared_tmp" "$SHARED_INDEX_FILE" \
+        && touch "${SHARED_INDEX_FILE}.checked"; then
+        return 0
+    fi
+    rm -f "$shared_tmp"
+    echo "⚠️  Could not copy the region index into the shared cache ${VNS_SHARED_CACHE}"
+}
+
+# One conditional fetch attempt; extra arguments (e.g. -4) are passed to wget.
+# Sets API_RESPONSE on success, including "304 Not Modified".
+fetch_index() {
+    # Unique names: concurrent runs for other regions refresh the index too
+    local index_tmp
+    index_tmp=$(mktemp "${INDEX_CACHE_FILE}.XXXXXX") || return 1
+    local headers_file
+    headers_file=$(mktemp "${INDEX_CACHE_FILE}.headers.XXXXXX") || { rm -f "$index_tmp"; return 1; }
+    local conditional=()
+    if [ -s "$INDEX_CACHE_FILE" ] && [ -s "$INDEX_ETAG_FILE" ]; then
+        conditional=(--header "If-None-Match: $(cat "$INDEX_ETAG_FILE")")
+    fi
+
+    local status=0
+    wget "$@" -nv -S --tries=1 --timeout=30 "${WGET_OPTS[@]}" "${conditional[@]}" \
+        -O "$index_tmp" "$GEOFABRIK_INDEX_URL" 2>"$headers_file" || status=$?
+    cat "$headers_file" >> "$WGET_ERR"
+
+    if grep -q "HTTP/[0-9.]* 304" "$headers_file"; then
+        rm -f "$index_tmp" "$headers_file"
+        touch "$INDEX_CHECKED_FILE"
+        publish_shared_index
+        API_RESPONSE=$(cat "$INDEX_CACHE_FILE")
+        echo "✅ Region index unchanged since last download"
+        return 0
+    fi
+    if [ "$status" -eq 0 ] && [ -s "$index_tmp" ]; then
+        grep -i '^ *ETag:' "$headers_file" | tail -n 1 | sed 's/^ *[Ee][Tt][Aa][Gg]: *//' | tr -d '\r' > "$INDEX_ETAG_FILE" || true
+        chmod 644 "$index_tmp"
+        mv "$index_tmp" "$INDEX_CACHE_FILE"
+        rm -f "$headers_file"
+        touch "$INDEX_CHECKED_FILE"
+        publish_shared_index
+        API_RESPONSE=$(cat "$INDEX_CACHE_FILE")
+        return 0
+    fi
+    rm -f "$index_tmp" "$headers_file"
+    return 1
+}
+
+# --- Custom Areas (Overpass API) ---
+# For small areas of operation (a training area, a single town) --bbox or
+# --poly pulls just the routable ways through the Overpass API instead of
+# downloading a whole Geofabrik extract. The region ID is then only used as
+# the output name, and the boundary files VNS needs are generated locally.
+OVERPASS_URL=""
+OVERPASS_MAX_AGE_MINUTES=$(( ${VNS_OVERPASS_MAX_AGE_HOURS:-24} * 60 ))
+OSM_EXT="osm.pbf"
+
+# Print the first ring of a .poly file as "lon lat" lines
+poly_ring_coordinates() {
+    awk 'NR > 2 && $1 == "END" { exit } NR > 2 && NF == 2 { print $1, $2 }' "$1"
+}
+
+build_overpass_query() {
+    local filter
+    if [ -n "$AREA_POLY" ]; then
+        if [ ! -f "$AREA_POLY" ]; then
+            echo "❌ Error: Polygon file not found: ${AREA_POLY}"
+            echo "Place it in ./output or ./cache so it is visible inside the container"
+            exit 1
+        fi
+        # Overpass expects "lat lon" pairs
+        filter="poly:\"$(poly_ring_coordinates "$AREA_POLY" | awk '{ printf "%s%s %s", (NR > 1 ? " " : ""), $2, $1 }')\""
     else
-        echo "(no error output captured)"
+        local min_lon min_lat max_lon max_lat
+        IFS=',' read -r min_lon min_lat max_lon max_lat <<< "$AREA_BBOX"
+        if ! awk -v a="$min_lon" -v b="$min_lat" -v c="$max_lon" -v d="$max_lat" 'BEGIN {
+                ok = (a ~ /^-?[0-9.]+$/ && b ~ /^-?[0-9.]+$/ && c ~ /^-?[0-9.]+$/ && d ~ /^-?[0-9.]+$/)
+                exit !(ok && a < c && b < d && a >= -180 && c <= 180 && b >= -90 && d <= 90) }'; then
+            echo "❌ Error: Invalid --bbox '${AREA_BBOX}'"
+            echo "Expected: minlon,minlat,maxlon,maxlat (e.g. -79.1,35.0,-78.9,35.2)"
+            exit 1
+        fi
+        filter="${min_lat},${min_lon},${max_lat},${max_lon}"
     fi
-    echo "-----------------------------------------"
-    echo ""
-    echo "🔍 Diagnostics:"
-    printf "   • DNS for download.geofabrik.de (from inside this container): "
-    dns_check_host download.geofabrik.de
-    echo "   • To test from your HOST (outside Docker), run:"
-    echo "       curl -v https://download.geofabrik.de/index-v1-nogeom.json"
-    echo ""
-    echo "   Common causes when a browser works but this does not:"
-    echo "     - a TLS-intercepting proxy/AV whose CA wget does not trust"
-    echo "     - Docker's DNS cannot reach Geofabrik (check the host resolver)"
-    echo "     - broken IPv6, or an IP/geo block on Geofabrik's side"
-    exit 1
-fi
 
-# Extract URLs for the specified region using jq
-REGION_DATA=$(echo "$API_RESPONSE" | jq -r --arg region_id "$REGION_ID" '
-(.features[] | select(.properties.id == $region_id) | 
- .properties.urls.pbf as $pbf |
- "PBF=" + $pbf,
- "POLY=" + ($pbf | gsub("-latest.osm.pbf"; ".poly")),
- "KML=" + ($pbf | gsub("-latest.osm.pbf"; ".kml"))) // 
-"ERROR=Region not found: " + $region_id
-')
+    OVERPASS_QUERY="[out:xml][timeout:${VNS_OVERPASS_TIMEOUT:-600}];(way[\"highway\"](${filter});way[\"route\"=\"ferry\"](${filter}););(._;>;);out body;"
+    OVERPASS_SIGNATURE="overpass:$(echo "$OVERPASS_QUERY" | md5sum | cut -c1-16)"
+    OVERPASS_URL="${VNS_OVERPASS_URL:-https://overpass-api.de/api/interpreter}"
+    # GraphHopper recognizes OSM XML by its file extension
+    OSM_EXT="osm"
+    OSM_URL="$OVERPASS_URL"
+}
 
-if echo "$REGION_DATA" | grep -q "ERROR="; then
-    echo "$REGION_DATA" | grep "ERROR=" | cut -d'=' -f2-
-    echo "Run './list-regions.sh' to see all available regions"
-    exit 1
-fi
+# Write the .poly and .kml boundary files VNS expects for a custom area
+write_area_boundaries() {
+    local ring
+    if [ -n "$AREA_POLY" ]; then
+        ring=$(poly_ring_coordinates "$AREA_POLY")
+    else
+        local min_lon min_lat max_lon max_lat
+        IFS=',' read -r min_lon min_lat max_lon max_lat <<< "$AREA_BBOX"
+        ring=$(printf '%s %s\n' "$min_lon" "$min_lat" "$max_lon" "$min_lat" "$max_lon" "$max_lat" "$min_lon" "$max_lat" "$min_lon" "$min_lat")
+    fi
 
-# Parse the URLs
-OSM_URL=$(echo "$REGION_DATA" | grep "PBF=" | cut -d'=' -f2-)
-POLY_URL=$(echo "$REGION_DATA" | grep "POLY=" | cut -d'=' -f2-)
-KML_URL=$(echo "$REGION_DATA" | grep "KML=" | cut -d'=' -f2-)
+    {
+        echo "$REGION_NAME"
+        echo "1"
+        echo "$ring" | awk '{ printf "   %s   %s\n", $1, $2 }'
+        echo "END"
+        echo "END"
+    } > "${CACHED_POLY_FILE}.tmp"
+    mv "${CACHED_POLY_FILE}.tmp" "$CACHED_POLY_FILE"
 
-# Validate URLs were extracted
-if [ -z "$OSM_URL" ] || [ -z "$POLY_URL" ] || [ -z "$KML_URL" ]; then
-    echo "Error: Failed to extract valid URLs for region '$REGION_ID'"
-    echo "This might indicate an API format change or network issue"
-    exit 1
+    {
+        echo '<?xml version="1.0" encoding="UTF-8"?>'
+        echo '<kml xmlns="http://www.opengis.net/kml/2.2">'
+        echo "<Document><Placemark><name>${REGION_NAME}</name>"
+        echo "<Polygon><outerBoundaryIs><LinearRing><coordinates>"
+        echo "$ring" | awk '{ printf "%s,%s ", $1, $2 } END { print "" }'
+        echo "</coordinates></LinearRing></outerBoundaryIs></Polygon>"
+        echo "</Placemark></Document></kml>"
+    } > "${CACHED_KML_FILE}.tmp"
+    mv "${CACHED_KML_FILE}.tmp" "$CACHED_KML_FILE"
+}
+
+# The extract is reused while the area is unchanged and younger than
+# VNS_OVERPASS_MAX_AGE_HOURS (--refresh forces a new one)
+overpass_extract_current() {
+    local stamp
+    stamp=$(cat "${CACHE_TIMESTAMP_FILE}.osm" 2>/dev/null || true)
+    if [ "$REFRESH_INDEX" != "true" ] && [ -f "$CACHED_OSM_FILE" ] \
+        && [ "${stamp%% fetched=*}" = "$OVERPASS_SIGNATURE" ] \
+        && [ -z "$(find "$CACHED_OSM_FILE" -mmin +"$OVERPASS_MAX_AGE_MINUTES" 2>/dev/null)" ]; then
+        echo "true"
+    else
+        echo "false"
+    fi
+}
+
+if [ -n "$AREA_BBOX" ] || [ -n "$AREA_POLY" ]; then
+    echo "🗺️  Custom area '${REGION_NAME}': extracting routable ways from the Overpass API"
+    build_overpass_query
+else
+    echo "Fetching region URLs from Geofabrik API..."
+    INDEX_FROM_CACHE="false"
+    adopt_shared_index
+    if index_cache_fresh; then
+        INDEX_FROM_CACHE="true"
+        API_RESPONSE=$(cat "$INDEX_CACHE_FILE")
+        echo "✅ Using cached region index (checked within ${VNS_INDEX_MAX_AGE_HOURS:-24}h, use --refresh to force)"
+    fi
+
+    # Offline, any cached index will do - region URLs rarely change
+    if [ "$INDEX_FROM_CACHE" = "false" ] && [ "$OFFLINE" = "true" ]; then
+        if [ ! -s "$INDEX_CACHE_FILE" ]; then
+            echo "❌ Offline mode: the region index is not cached (${INDEX_CACHE_FILE})"
+            echo "   Run any region once with internet access, or copy ./cache from a connected machine"
+            exit 1
+        fi
+        INDEX_FROM_CACHE="true"
+        API_RESPONSE=$(cat "$INDEX_CACHE_FILE")
+        echo "📴 Offline mode: using the cached region index from $(date -r "$INDEX_CACHE_FILE" +%Y-%m-%d 2>/dev/null || echo "an earlier run")"
+    fi
+
+    while [ "$INDEX_FROM_CACHE" = "false" ] && [ $retry_count -lt $max_retries ]; do
+        # Try a normal (dual-stack) request first; on failure, retry forcing IPv4
+        # (-4) for hosts/containers where IPv6 is present but broken. Real errors
+        # are captured to $WGET_ERR so we can surface them if all attempts fail.
+        # NOTE: use -nv (not -q): -q silences the very stderr we need to capture.
+        # --tries=1 --timeout=30 makes each attempt fail fast instead of letting
+        # wget burn its own internal retries (which made the loop appear to hang).
+        if fetch_index && [ -n "$API_RESPONSE" ]; then
+            break
+        fi
+        if fetch_index -4 && [ -n "$API_RESPONSE" ]; then
+            break
+        fi
+        retry_count=$((retry_count + 1))
+        echo "⚠️  Retry $retry_count/$max_retries - Failed to fetch region data from Geofabrik API"
+        sleep 2
+    done
+
+    if [ $retry_count -eq $max_retries ]; then
+        echo "❌ Error: Failed to fetch region data from Geofabrik API after $max_retries attempts"
+        echo ""
+        echo "----- Actual error reported by wget -----"
+        if [ -s "$WGET_ERR" ]; then
+            tail -n 5 "$WGET_ERR"
+        else
+            echo "(no error output captured)"
+        fi
+        echo "-----------------------------------------"
+        echo ""
+        echo "🔍 Diagnostics:"
+        printf "   • DNS for download.geofabrik.de (from inside this container): "
+        dns_check_host download.geofabrik.de
+        echo "   • To test from your HOST (outside Docker), run:"
+        echo "       curl -v https://download.geofabrik.de/index-v1-nogeom.json"
+        echo ""
+        echo "   Common causes when a browser works but this does not:"
+        echo "     - a TLS-intercepting proxy/AV whose CA wget does not trust"
+        echo "     - Docker's DNS cannot reach Geofabrik (check the host resolver)"
+        echo "     - broken IPv6, or an IP/geo block on Geofabrik's side"
+        exit 1
+    fi
+
+    # Extract URLs for the specified region using jq
+    REGION_DATA=$(echo "$API_RESPONSE" | jq -r --arg region_id "$REGION_ID" '
+    (.features[] | select(.properties.id == $region_id) | 
+     .properties.urls.pbf as $pbf |
+     "PBF=" + $pbf,
+     "POLY=" + ($pbf | gsub("-latest.osm.pbf"; ".poly")),
+     "KML=" + ($pbf | gsub("-latest.osm.pbf"; ".kml"))) // 
+    "ERROR=Region not found: " + $region_id
+    ')
+
+    if echo "$REGION_DATA" | grep -q "ERROR="; then
+        echo "$REGION_DATA" | grep "ERROR=" | cut -d'=' -f2-
+        echo "Run './list-regions.sh' to see all available regions"
+        exit 1
+    fi
+
+    # Parse the URLs
+    OSM_URL=$(echo "$REGION_DATA" | grep "PBF=" | cut -d'=' -f2-)
+    POLY_URL=$(echo "$REGION_DATA" | grep "POLY=" | cut -d'=' -f2-)
+    KML_URL=$(echo "$REGION_DATA" | grep "KML=" | cut -d'=' -f2-)
+
+    # Validate URLs were extracted
+    if [ -z "$OSM_URL" ] || [ -z "$POLY_URL" ] || [ -z "$KML_URL" ]; then
+        echo "Error: Failed to extract valid URLs for region '$REGION_ID'"
+        echo "This might indicate an API format change or network issue"
+        exit 1
+    fi
+
+    # A mirror (VNS_MIRROR, picked by run.sh's download server check) serves
+    # the same paths as Geofabrik; the region index still comes from Geofabrik
+    MIRROR_URL="${VNS_MIRROR:-}"
+    if [ -n "$MIRROR_URL" ] && [ "${MIRROR_URL%/}/" != "$GEOFABRIK_BASE_URL" ] \
+        && [[ "$OSM_URL" == "$GEOFABRIK_BASE_URL"* ]]; then
+        MIRROR_URL="${MIRROR_URL%/}/"
+        OSM_URL="${MIRROR_URL}${OSM_URL#"$GEOFABRIK_BASE_URL"}"
+        POLY_URL="${MIRROR_URL}${POLY_URL#"$GEOFABRIK_BASE_URL"}"
+        KML_URL="${MIRROR_URL}${KML_URL#"$GEOFABRIK_BASE_URL"}"
+        echo "🌐 Downloading from mirror ${MIRROR_URL}"
+    fi
 fi
 
 # --- Smart Caching System Setup ---
 CACHE_DIR="./cache"
+# Team-wide download cache, e.g. on NFS or SMB (a host path mounted by run.sh)
+SHARED_CACHE_DIR="${VNS_SHARED_CACHE:-}"
 OUTPUT_DIR="./output"
 CACHE_FILE_PREFIX="${CACHE_DIR}/${REGION_NAME}"
-CACHED_OSM_FILE="${CACHE_FILE_PREFIX}.osm.pbf"
+CACHED_OSM_FILE="${CACHE_FILE_PREFIX}.${OSM_EXT}"
 CACHED_POLY_FILE="${CACHE_FILE_PREFIX}.poly"
 CACHED_KML_FILE="${CACHE_FILE_PREFIX}.kml"
 CACHE_TIMESTAMP_FILE="${CACHE_FILE_PREFIX}.timestamp"
@@ -251,97 +1148,999 @@ CACHE_TIMESTAMP_FILE="${CACHE_FILE_PREFIX}.timestamp"
 # Ensure directories exist (handles first-time users)
 mkdir -p "${CACHE_DIR}" "${OUTPUT_DIR}"
 
+if [ -n "$OVERPASS_URL" ]; then
+    write_area_boundaries
+fi
+
+# --- Offline Mode ---
+# With --offline nothing is downloaded or checked for updates: the build uses
+# whatever is cached, and stops here with a list of what is missing.
+if [ "$OFFLINE" = "true" ]; then
+    OFFLINE_MISSING=()
+    if [ ! -s "$CACHED_OSM_FILE" ]; then
+        OFFLINE_MISSING+=("$CACHED_OSM_FILE")
+    elif [ -n "$OVERPASS_URL" ]; then
+        OFFLINE_STAMP=$(cat "${CACHE_TIMESTAMP_FILE}.osm" 2>/dev/null || true)
+        if [ "${OFFLINE_STAMP%% fetched=*}" != "$OVERPASS_SIGNATURE" ]; then
+            OFFLINE_MISSING+=("$CACHED_OSM_FILE (cached for a different area)")
+        fi
+    fi
+    for cached in "$CACHED_POLY_FILE" "$CACHED_KML_FILE"; do
+        if [ ! -s "$cached" ]; then
+            OFFLINE_MISSING+=("$cached")
+        fi
+    done
+
+    if [ ${#OFFLINE_MISSING[@]} -gt 0 ]; then
+        echo "❌ Offline mode: no cached data to build '${REGION_ID}' from. Missing:"
+        printf '   • %s\n' "${OFFLINE_MISSING[@]}"
+        echo "   On a machine with internet access run: ./run.sh ${REGION_ID} --download-only"
+        echo "   then copy its ./cache folder into this machine's ./cache"
+        exit 1
+    fi
+    echo "📴 Offline mode: building from cached data ($(du -h "$CACHED_OSM_FILE" | cut -f1) extract, downloaded $(date -r "$CACHED_OSM_FILE" +%Y-%m-%d))"
+fi
+
+# --- Working Directory Selection ---
+# Downloads are staged and the graph is built in a working directory. By
+# default it lives inside the (mounted) cache so a finished import survives
+# the container exiting; --temp-dir / VNS_TEMP_DIR moves it elsewhere. If the
+# chosen location is too small for the PBF plus the intermediate graph, the
+# output volume is used instead.
+
+# Free space in MB on the filesystem holding a directory
+free_space_mb() {
+    df -Pm "$1" 2>/dev/null | awk 'NR==2 {print $4}'
+}
+
+# Size of the OSM extract in MB (cached copy, or Content-Length from the server)
+estimate_pbf_mb() {
+    if [ -f "$CACHED_OSM_FILE" ]; then
+        du -m "$CACHED_OSM_FILE" | cut -f1
+        return
+    fi
+    # Overpass responses have no size until the query has run
+    if [ -n "$OVERPASS_URL" ]; then
+        echo 0
+        return
+    fi
+    local bytes
+    bytes=$(wget --spider --server-response "${WGET_OPTS[@]}" "$OSM_URL" 2>&1 | grep -i "Content-Length:" | tail -1 | awk '{print $2}' | tr -d '\r')
+    echo $(( ${bytes:-0} / 1024 / 1024 ))
+}
+
+PBF_ESTIMATE_MB=$(estimate_pbf_mb)
+# Staged PBF copy + graph (output folders run up to ~1.2x the PBF) + margin
+REQUIRED_WORK_MB=$(( PBF_ESTIMATE_MB * 23 / 10 + 100 ))
+
+select_work_dir() {
+    local requested="${TEMP_DIR:-${CACHE_DIR}/work}"
+    local fallback="${OUTPUT_DIR}/.work"
+    local candidate
+
+    # An interrupted build is resumed wherever its graph was left
+    for candidate in "$requested" "$fallback"; do
+        if [ -f "${candidate}/${GRAPH_FOLDER}/properties" ]; then
+            WORK_DIR="$candidate"
+            return
+        fi
+    done
+
+    mkdir -p "$requested"
+    if [ "$(free_space_mb "$requested")" -ge "$REQUIRED_WORK_MB" ]; then
+        WORK_DIR="$requested"
+        return
+    fi
+
+    mkdir -p "$fallback"
+    if [ "$(free_space_mb "$fallback")" -ge "$REQUIRED_WORK_MB" ]; then
+        echo "⚠️  Not enough space in ${requested} ($(free_space_mb "$requested")MB free, ~${REQUIRED_WORK_MB}MB needed)"
+        echo "📂 Using the output volume for temporary files instead: ${fallback}"
+        WORK_DIR="$fallback"
+        return
+    fi
+
+    WORK_DIR="$requested"
+    echo "Warning: Low disk space detected. This region may fail."
+    echo "Available: $(free_space_mb "$requested")MB in ${requested}, Needed: ~${REQUIRED_WORK_MB}MB (PBF + graph)"
+    echo "Use --temp-dir to point temporary files at a larger disk."
+    echo "Continue? (y/N)"
+    read -r response || response=""
+    if [ "$response" != "y" ] && [ "$response" != "Y" ]; then
+        echo "Aborted by user"
+        exit 1
+    fi
+}
+
+select_work_dir
+WORK_DIR="$(cd "${WORK_DIR}" && pwd)"
+WORK_GRAPH_DIR="${WORK_DIR}/${GRAPH_FOLDER}"
+log_verbose "work_dir=$WORK_DIR, free_mb=$(free_space_mb "$WORK_DIR"), required_mb=$REQUIRED_WORK_MB"
+
+# Working copies of the downloaded files are staged next to the graph
+OSM_FILE="${WORK_DIR}/${FILENAME}.${OSM_EXT}"
+POLY_FILE="${WORK_DIR}/${REGION_NAME}.poly"
+KML_FILE="${WORK_DIR}/${REGION_NAME}.kml"
+
+# --- Step Checkpointing ---
+# Completed steps are recorded in a per-region state file together with the
+# source data date they were produced from, so a rerun resumes after the
+# import - but only for the same source data. The steps after it need no
+# record: an interrupted move starts again from the graph in the work
+# folder, and once the graph is in ./output the up-to-date check repackages it.
+STATE_FILE="${CACHE_FILE_PREFIX}.state"
+
+# Identifier of the source data a step was run against
+current_source_stamp() {
+    cat "${CACHE_TIMESTAMP_FILE}.osm" 2>/dev/null || echo "unknown"
+}
+
+# Record a completed step for the current source data
+mark_step_done() {
+    local step="$1"
+    local stamp
+    stamp=$(current_source_stamp)
+    # Replace any previous record of this step
+    if [ -f "$STATE_FILE" ]; then
+        grep -v "^${step}=" "$STATE_FILE" > "${STATE_FILE}.tmp" || true
+        mv "${STATE_FILE}.tmp" "$STATE_FILE"
+    fi
+    echo "${step}=${stamp}" >> "$STATE_FILE"
+    log_minimal "checkpoint: step=$step, source=$stamp"
+}
+
+# Check whether a step already completed for the current source data
+step_done() {
+    local step="$1"
+    local recorded
+    recorded=$(grep "^${step}=" "$STATE_FILE" 2>/dev/null | cut -d'=' -f2-)
+    [ -n "$recorded" ] && [ "$recorded" = "$(current_source_stamp)" ]
+}
+
+# --- Graceful Cancellation ---
+# Ctrl+C (or 'docker stop') must not leave half-written graph folders or
+# truncated packages behind. Each long step registers what it is writing;
+# on cancellation those paths are deleted. Completed downloads stay in the
+# cache for the next run unless VNS_KEEP_DOWNLOADS=false.
+PARTIAL_PATHS=()
+CHILD_PID=""
+KEEP_DOWNLOADS="${VNS_KEEP_DOWNLOADS:-true}"
+
+# Register a path that is incomplete until the current step finishes
+track_partial() {
+    PARTIAL_PATHS+=("$@")
+}
+
+# The current step finished - its outputs are complete
+clear_partials() {
+    PARTIAL_PATHS=()
+}
+
+cleanup_on_cancel() {
+    trap - INT TERM
+    echo ""
+    echo "🛑 Cancellation requested - cleaning up partial state..."
+
+    # Stop the running java/zip process first so it can't recreate anything
+    if [ -n "$CHILD_PID" ] && kill -0 "$CHILD_PID" 2>/dev/null; then
+        kill "$CHILD_PID" 2>/dev/null || true
+        wait "$CHILD_PID" 2>/dev/null || true
+    fi
+
+    local path
+    for path in "${PARTIAL_PATHS[@]}"; do
+        if [ -e "$path" ]; then
+            rm -rf "$path"
+            echo "  🗑️  Removed partial: $path"
+        fi
+    done
+    rm -f "${OSM_FILE}" "${POLY_FILE}" "${KML_FILE}"
+
+    if [ "$KEEP_DOWNLOADS" = "true" ]; then
+        echo "  💾 Downloaded data kept in ./cache - rerun to resume"
+    else
+        rm -f "${CACHED_OSM_FILE}" "${CACHED_POLY_FILE}" "${CACHED_KML_FILE}" "${CACHE_TIMESTAMP_FILE}".* "${STATE_FILE}"
+        echo "  🗑️  Removed cached downloads for ${REGION_NAME}"
+    fi
+
+    log_minimal "cancelled: region=$REGION_NAME"
+    RUN_RESULT="cancelled"
+    exit 130
+}
+trap cleanup_on_cancel INT TERM
+
 # Function to get remote file modification date
 get_remote_date() {
     local url="$1"
     local remote_date
-    remote_date=$(wget --spider --server-response "$url" 2>&1 | grep -i "Last-Modified:" | tail -1 | cut -d: -f2- | xargs)
+    remote_date=$(wget --spider --server-response "${WGET_OPTS[@]}" "$url" 2>&1 | grep -i "Last-Modified:" | tail -1 | cut -d: -f2- | xargs)
     if [ -z "$remote_date" ]; then
         # Fallback if no Last-Modified header - use current time
         date -u +"%a, %d %b %Y %H:%M:%S GMT"
     else
-        echo "$remote_date"
+        echo "$remote_date"
+    fi
+}
+
+# Function to check if cached file is up to date
+is_file_current() {
+    local url="$1"
+    local cached_file="$2"
+    local cache_timestamp_file="$3"
+    
+    if [ ! -f "$cached_file" ] || [ ! -f "$cache_timestamp_file" ]; then
+        echo "false"
+        return
+    fi
+    
+    local remote_date
+    local cached_date
+    remote_date=$(get_remote_date "$url")
+    cached_date=$(cat "$cache_timestamp_file" 2>/dev/null)
+    
+    if [ "$remote_date" = "$cached_date" ]; then
+        echo "true"
+    else
+        echo "false"
+    fi
+}
+
+# Check if we need to download files (silent check for first-time users)
+if [ "$OFFLINE" = "true" ]; then
+    # What is cached is the newest data there is
+    OSM_CURRENT="true"
+    POLY_CURRENT="true"
+    KML_CURRENT="true"
+elif [ -n "$OVERPASS_URL" ]; then
+    # Boundary files were generated locally from the area definition
+    OSM_CURRENT=$(overpass_extract_current)
+    POLY_CURRENT="true"
+    KML_CURRENT="true"
+else
+    OSM_CURRENT=$(is_file_current "$OSM_URL" "$CACHED_OSM_FILE" "${CACHE_TIMESTAMP_FILE}.osm")
+    POLY_CURRENT=$(is_file_current "$POLY_URL" "$CACHED_POLY_FILE" "${CACHE_TIMESTAMP_FILE}.poly")
+    KML_CURRENT=$(is_file_current "$KML_URL" "$CACHED_KML_FILE" "${CACHE_TIMESTAMP_FILE}.kml")
+fi
+
+# Only show cache status if we have existing cache or output
+if [ -d "./cache" ] && [ "$(ls -A ./cache 2>/dev/null)" ] || [ -d "./output" ] && [ "$(ls -A ./output 2>/dev/null)" ]; then
+    echo "🔍 Checking for cached data and updates..."
+fi
+
+# Output package for the selected format (empty for the plain directory format)
+case "$OUTPUT_FORMAT" in
+    zip)    PACKAGE_FILE="${GRAPH_FOLDER}.zip" ;;
+    tar.gz) PACKAGE_FILE="${GRAPH_FOLDER}.tar.gz" ;;
+    dir)    PACKAGE_FILE="" ;;
+esac
+
+# With --package-only the graph is packaged straight from the work directory
+# and never copied to ./output, so the output volume only has to hold the
+# package instead of the graph folder plus the package. The folder is not
+# kept, which also means the next run cannot reuse it and rebuilds the graph
+# whenever the source data changed.
+GRAPH_DIR="./output/${GRAPH_FOLDER}"
+if [ "$PACKAGE_ONLY" = "true" ]; then
+    GRAPH_DIR="${WORK_GRAPH_DIR}"
+fi
+
+# Function to package ${GRAPH_DIR} in the selected output format. The archive
+# is written under a .tmp name and renamed once complete, so the package
+# name never points at a half-written file. The compressor runs as
+# CHILD_PID so a cancellation stops it at once instead of after the archive
+# is finished; tar's own exit status goes to a file next to the archive, as
+# waiting on the compressor alone would miss a read error.
+create_package() {
+    local source_dir
+    source_dir=$(dirname "$GRAPH_DIR")
+    local package
+    package="$(pwd)/output/${PACKAGE_FILE}"
+    local tar_status="${package}.tar-status"
+    case "$OUTPUT_FORMAT" in
+        zip)
+            rm -f "${package}.tmp"
+            track_partial "${package}.tmp"
+            # 7-Zip deflates several files at once; Info-ZIP is single-threaded
+            # and is only used when 7z is not installed.
+            if command -v 7z >/dev/null 2>&1; then
+                (cd "$source_dir" && exec 7z a -tzip -bd -mx="${COMPRESSION_LEVEL}" -mmt="${COMPRESSION_THREADS}" "${package}.tmp" "${GRAPH_FOLDER}/" >/dev/null) &
+            else
+                (cd "$source_dir" && exec zip -r -"${COMPRESSION_LEVEL}" "${package}.tmp" "${GRAPH_FOLDER}/") &
+            fi
+            ;;
+        tar.gz)
+            rm -f "${package}.tmp" "$tar_status"
+            track_partial "${package}.tmp"
+            track_partial "$tar_status"
+            # gzip has no store level, so level 0 falls back to its fastest setting
+            if command -v pigz >/dev/null 2>&1; then
+                { tar -cf - -C "$source_dir" "${GRAPH_FOLDER}/"; echo $? > "$tar_status"; } \
+                    | pigz -"${COMPRESSION_LEVEL}" -p "${COMPRESSION_THREADS}" > "${package}.tmp" &
+            else
+                { tar -cf - -C "$source_dir" "${GRAPH_FOLDER}/"; echo $? > "$tar_status"; } \
+                    | gzip -"$((COMPRESSION_LEVEL > 0 ? COMPRESSION_LEVEL : 1))" > "${package}.tmp" &
+            fi
+            ;;
+        dir)
+            # GraphHopper graph files barely compress, and rsync-style deployments
+            # copy the directory tree as-is, so there is nothing to package.
+            echo "Directory output selected - no archive created"
+            write_checksums
+            return 0
+            ;;
+    esac
+
+    # For tar.gz this is the compressor; tar stops with it on a broken pipe
+    CHILD_PID=$!
+    if ! wait "$CHILD_PID"; then
+        echo "❌ Error: Failed to create ${PACKAGE_FILE}"
+        exit 1
+    fi
+    CHILD_PID=""
+    if [ "$OUTPUT_FORMAT" = "tar.gz" ]; then
+        # The status is written before tar's end of the pipe closes, so it
+        # is there once the compressor has finished
+        if [ "$(cat "$tar_status" 2>/dev/null)" != "0" ]; then
+            echo "❌ Error: Failed to create ${PACKAGE_FILE} (tar could not read the graph files)"
+            rm -f "${package}.tmp" "$tar_status"
+            exit 1
+        fi
+        rm -f "$tar_status"
+    fi
+    mv "${package}.tmp" "$package"
+    # Patches from earlier builds lead to an older package, not this one;
+    # create_delta writes a new one when asked to
+    rm -f "./output/${PACKAGE_FILE}".from-*.xdelta
+    if [ "$OUTPUT_FORMAT" = "zip" ]; then
+        echo "ZIP file created: ${PACKAGE_FILE} ($(du -sh "./output/${PACKAGE_FILE}" | cut -f1), compression: ${COMPRESSION})"
+    else
+        echo "Archive created: ${PACKAGE_FILE} ($(du -sh "./output/${PACKAGE_FILE}" | cut -f1), compression: ${COMPRESSION})"
+    fi
+
+    write_checksums
+    write_zsync
+}
+
+# Function to write the SHA-256 sidecar for the package (or, for directory
+# output, a manifest covering every file in the folder). Paths inside the
+# sidecar are relative to ./output so './verify.sh' works on any machine.
+write_checksums() {
+    # A signature of the previous sidecar no longer matches; run.sh signs
+    # the new one after the build
+    rm -f "./output/${PACKAGE_FILE:-$GRAPH_FOLDER}.sha256.minisig" "./output/${PACKAGE_FILE:-$GRAPH_FOLDER}.sha256.asc"
+    if [ -n "$PACKAGE_FILE" ]; then
+        (cd ./output/ && sha256sum "${PACKAGE_FILE}" > "${PACKAGE_FILE}.sha256.tmp" && mv "${PACKAGE_FILE}.sha256.tmp" "${PACKAGE_FILE}.sha256")
+        echo "🔐 Checksum written: ${PACKAGE_FILE}.sha256"
+    else
+        (cd ./output/ && find "${GRAPH_FOLDER}" -type f -print0 | sort -z | xargs -0 sha256sum > "${GRAPH_FOLDER}.sha256.tmp" \
+            && mv "${GRAPH_FOLDER}.sha256.tmp" "${GRAPH_FOLDER}.sha256")
+        echo "🔐 Checksum manifest written: ${GRAPH_FOLDER}.sha256"
+    fi
+}
+
+# With --zsync, write <package>.zsync for clients that update over HTTP with
+# zsync: they download only the blocks of the new package they do not already
+# have. The metadata refers to the package by its file name, so both are
+# published side by side. Stale metadata of an earlier package is removed.
+write_zsync() {
+    rm -f "./output/${PACKAGE_FILE}.zsync"
+    [ "$ZSYNC" = "true" ] || return 0
+    if ! command -v zsyncmake >/dev/null 2>&1; then
+        echo "⚠️  zsyncmake not found - skipping the .zsync file (rebuild the Docker image to enable it)"
+        return 0
+    fi
+    if (cd ./output/ && zsyncmake -u "${PACKAGE_FILE}" -o "${PACKAGE_FILE}.zsync.tmp" "${PACKAGE_FILE}" >/dev/null 2>&1 \
+        && mv "${PACKAGE_FILE}.zsync.tmp" "${PACKAGE_FILE}.zsync"); then
+        echo "🔄 zsync metadata written: ${PACKAGE_FILE}.zsync"
+    else
+        rm -f "./output/${PACKAGE_FILE}.zsync.tmp"
+        echo "⚠️  Could not write ${PACKAGE_FILE}.zsync"
+    fi
+}
+
+# --- Delta Packages ---
+# With --delta (VNS_DELTA=true), a rebuild also writes an xdelta3 patch from
+# the previous package to the new one. Sites that already have the previous
+# package only need the patch. Its name carries the first 12 characters of the
+# previous package's SHA-256, so a site can tell whether the patch applies to
+# what it has. Set when a rebuild moves the previous package aside:
+PREVIOUS_PACKAGE=""
+DELTA_FILE=""
+
+create_delta() {
+    [ "$DELTA" = "true" ] || return 0
+    if [ -z "$PREVIOUS_PACKAGE" ] || [ ! -f "$PREVIOUS_PACKAGE" ]; then
+        echo "🧩 No previous ${OUTPUT_FORMAT} package of ${REGION_NAME} - no delta package this time"
+        return 0
+    fi
+    if ! command -v xdelta3 >/dev/null 2>&1; then
+        echo "⚠️  xdelta3 not found - skipping the delta package (rebuild the Docker image to enable it)"
+        return 0
+    fi
+
+    local previous_sha256
+    previous_sha256=$(sha256sum "$PREVIOUS_PACKAGE" | cut -d' ' -f1)
+    local delta="./output/${PACKAGE_FILE}.from-${previous_sha256:0:12}.xdelta"
+    track_partial "${delta}.tmp"
+
+    # The source window must hold the whole previous package to find moved
+    # blocks; xdelta3 caps it at 2GB
+    local window
+    window=$(wc -c < "$PREVIOUS_PACKAGE")
+    window=$(( window < 2147483648 ? (window > 65536 ? window : 65536) : 2147483648 ))
+    echo "🧩 Creating delta package from the previous build..."
+    xdelta3 -e -f -9 -B "$window" -s "$PREVIOUS_PACKAGE" "./output/${PACKAGE_FILE}" "${delta}.tmp" &
+    CHILD_PID=$!
+    if ! wait "$CHILD_PID"; then
+        CHILD_PID=""
+        rm -f "${delta}.tmp"
+        echo "⚠️  Could not create the delta package - the full package is still complete"
+        return 0
+    fi
+    CHILD_PID=""
+    mv "${delta}.tmp" "$delta"
+    clear_partials
+    DELTA_FILE="$delta"
+
+    local delta_bytes
+    delta_bytes=$(wc -c < "$delta")
+    local package_bytes
+    package_bytes=$(wc -c < "./output/${PACKAGE_FILE}")
+    echo "🧩 Delta package: ${delta##*/} ($(du -h "$delta" | cut -f1), $(( delta_bytes * 100 / (package_bytes > 0 ? package_bytes : 1) ))% of the full package)"
+    log_minimal "delta_created: file=${delta##*/}, bytes=$delta_bytes, package_bytes=$package_bytes"
+}
+
+# Settings that change the imported graph; a change forces a rebuild even
+# when the source data is unchanged
+IMPORT_SETTINGS_FILE="${CACHE_FILE_PREFIX}.import"
+# Nor may a benchmark import pass for a build of its region; it always starts
+# from scratch
+if [ "$BENCHMARK_RUN" = "true" ]; then
+    IMPORT_SETTINGS_FILE="${CACHE_DIR}/${GRAPH_FOLDER}.import"
+    STATE_FILE="${CACHE_DIR}/${GRAPH_FOLDER}.state"
+    rm -rf "$IMPORT_SETTINGS_FILE" "$STATE_FILE" "$WORK_GRAPH_DIR"
+fi
+IMPORT_SETTINGS="clip=${CLIP_TO_POLY},filter=${FILTER_ROUTING}"
+
+# Settings of the last import. Files written before filter= was recorded
+# come from imports without --filter-routing.
+previous_import_settings() {
+    local previous
+    previous=$(cat "$IMPORT_SETTINGS_FILE" 2>/dev/null || echo "clip=false")
+    case "$previous" in
+        *filter=*) echo "$previous" ;;
+        *) echo "${previous},filter=false" ;;
+    esac
+}
+
+import_settings_changed() {
+    [ "$(previous_import_settings)" != "$IMPORT_SETTINGS" ]
+}
+
+# --- Java Selection ---
+# The Docker image ships a suitable JDK, but generate-data.sh can also run
+# directly on a host with several JVMs installed. VNS_JAVA (a java binary or
+# a JDK home) picks one explicitly; otherwise JAVA_HOME, PATH and the usual
+# install locations are tried and the first one new enough is used.
+JAVA_MIN_VERSION=8
+
+# Major version of a java binary (8 for "1.8.0_292", 11 for "11.0.16"),
+# empty if it does not run
+java_major_version() {
+    local version
+    version=$("$1" -version 2>&1 | awk -F'"' '/version/ { print $2; exit }')
+    case "$version" in
+        1.*) echo "$version" | cut -d. -f2 ;;
+        "") ;;
+        *) echo "$version" | cut -d. -f1 | cut -d- -f1 ;;
+    esac
+}
+
+java_candidates() {
+    local dir
+    [ -n "$JAVA_HOME" ] && echo "${JAVA_HOME}/bin/java"
+    command -v java 2>/dev/null
+    for dir in /usr/lib/jvm/*/bin /usr/java/*/bin /opt/java/*/bin \
+               /Library/Java/JavaVirtualMachines/*/Contents/Home/bin; do
+        [ -x "${dir}/java" ] && echo "${dir}/java"
+    done
+    return 0
+}
+
+java_install_help() {
+    echo "💡 Install Java ${JAVA_MIN_VERSION} or newer:"
+    case "$(uname -s 2>/dev/null)" in
+        Darwin)
+            echo "   brew install openjdk@11"
+            ;;
+        Linux)
+            if command -v apt-get >/dev/null 2>&1; then
+                echo "   sudo apt-get install openjdk-11-jre-headless"
+            elif command -v dnf >/dev/null 2>&1; then
+                echo "   sudo dnf install java-11-openjdk-headless"
+            elif command -v apk >/dev/null 2>&1; then
+                echo "   sudo apk add openjdk11-jre-headless"
+            else
+                echo "   Use your distribution's OpenJDK 11 package"
+            fi
+            ;;
+        MINGW*|MSYS*|CYGWIN*)
+            echo "   winget install EclipseAdoptium.Temurin.11.JRE"
+            ;;
+        *)
+            echo "   https://adoptium.net/"
+            ;;
+    esac
+    echo "   Or use ./run.sh, which runs everything in Docker with Java included."
+}
+
+# Sets JAVA_BIN to a usable java binary, or exits with instructions
+select_java() {
+    local candidate
+    local major
+    if [ -n "$VNS_JAVA" ]; then
+        candidate="$VNS_JAVA"
+        [ -d "$candidate" ] && candidate="${candidate}/bin/java"
+        major=$(java_major_version "$candidate")
+        if [ -z "$major" ]; then
+            echo "❌ Error: VNS_JAVA='${VNS_JAVA}' is not a working java installation"
+            exit 1
+        fi
+        if [ "$major" -lt "$JAVA_MIN_VERSION" ]; then
+            echo "❌ Error: VNS_JAVA is Java ${major}, GraphHopper ${GRAPHHOPPER_VERSION} needs Java ${JAVA_MIN_VERSION} or newer"
+            java_install_help
+            exit 1
+        fi
+        JAVA_BIN="$candidate"
+    else
+        JAVA_BIN=""
+        while read -r candidate; do
+            major=$(java_major_version "$candidate")
+            if [ -n "$major" ] && [ "$major" -ge "$JAVA_MIN_VERSION" ]; then
+                JAVA_BIN="$candidate"
+                break
+            fi
+            [ -n "$major" ] && echo "⚠️  Ignoring Java ${major} at ${candidate} (too old)"
+        done < <(java_candidates | awk '!seen[$0]++')
+        if [ -z "$JAVA_BIN" ]; then
+            echo "❌ Error: No Java ${JAVA_MIN_VERSION}+ installation found (checked JAVA_HOME, PATH and /usr/lib/jvm)"
+            java_install_help
+            exit 1
+        fi
     fi
+    log_minimal "java: path=$JAVA_BIN, version=$major"
 }
 
-# Function to check if cached file is up to date
-is_file_current() {
-    local url="$1"
-    local cached_file="$2"
-    local cache_timestamp_file="$3"
-    
-    if [ ! -f "$cached_file" ] || [ ! -f "$cache_timestamp_file" ]; then
-        echo "false"
-        return
+# --- Build Registry ---
+# Every successful build is recorded in ./cache/registry.json (one entry per
+# region) so './status.sh' can list what was built from which source data
+# and flag regions whose Geofabrik extract has changed since.
+REGISTRY_FILE="./cache/registry.json"
+GRAPHHOPPER_JAR="graphhopper/graphhopper-web-1.0.jar"
+GRAPHHOPPER_VERSION=$(basename "$GRAPHHOPPER_JAR" .jar | sed 's/^graphhopper-web-//')
+
+# With "repackaged", only the package fields of an existing entry are
+# updated: the graph, its statistics and timings are still those of the
+# earlier build.
+record_build() {
+    local repackaged=false
+    [ "${1:-}" = "repackaged" ] && repackaged=true
+    local source_url="$OSM_URL"
+    [ -n "$OVERPASS_URL" ] && source_url=""
+    local package_bytes=0
+    local package_sha256=""
+    if [ -n "$PACKAGE_FILE" ] && [ -f "./output/${PACKAGE_FILE}" ]; then
+        package_bytes=$(wc -c < "./output/${PACKAGE_FILE}")
+        package_sha256=$(cut -d' ' -f1 "./output/${PACKAGE_FILE}.sha256" 2>/dev/null || true)
     fi
-    
-    local remote_date
-    local cached_date
-    remote_date=$(get_remote_date "$url")
-    cached_date=$(cat "$cache_timestamp_file" 2>/dev/null)
-    
-    if [ "$remote_date" = "$cached_date" ]; then
-        echo "true"
-    else
-        echo "false"
+    local osm_bytes=0
+    if [ -f "$CACHED_OSM_FILE" ]; then
+        osm_bytes=$(wc -c < "$CACHED_OSM_FILE")
     fi
+    # Graph statistics come from read_graph_stats; warnings from the import log
+    local import_warnings=0
+    if [ -f "$IMPORT_LOG" ]; then
+        import_warnings=$(grep -c ' WARN ' "$IMPORT_LOG" || true)
+    fi
+
+    local entry
+    entry=$(jq -nc \
+        --arg region "$REGION_NAME" \
+        --arg region_id "$REGION_ID" \
+        --arg built_at "$(date -u +"%Y-%m-%dT%H:%M:%SZ")" \
+        --arg source_date "$(current_source_stamp)" \
+        --arg source_url "$source_url" \
+        --arg graphhopper "$GRAPHHOPPER_VERSION" \
+        --arg output_path "$([ -d "./output/${GRAPH_FOLDER}" ] && echo "output/${GRAPH_FOLDER}")" \
+        --arg package "${PACKAGE_FILE:+output/${PACKAGE_FILE}}" \
+        --arg format "$OUTPUT_FORMAT" \
+        --argjson size_bytes "$(( $(du -sk "$GRAPH_DIR" | cut -f1) * 1024 ))" \
+        --argjson package_bytes "$package_bytes" \
+        --arg package_sha256 "$package_sha256" \
+        --argjson osm_bytes "$osm_bytes" \
+        --arg import_seconds "${STEP_DURATIONS[import]:-}" \
+        --argjson step_seconds "$(for step in "${!STEP_DURATIONS[@]}"; do echo "${step} ${STEP_DURATIONS[$step]}"; done \
+            | jq -Rnc '[inputs | split(" ") | {key: .[0], value: (.[1] | tonumber)}] | from_entries')" \
+        --arg graph_nodes "$GRAPH_NODES" \
+        --arg graph_edges "$GRAPH_EDGES" \
+        --argjson graph_bytes "${GRAPH_BYTES:-0}" \
+        --arg graph_bbox "$GRAPH_BBOX" \
+        --argjson import_warnings "$import_warnings" \
+        --argjson anomalies "$(printf '%s\n' "${BUILD_ANOMALIES[@]}" | jq -R . | jq -sc 'map(select(. != ""))')" \
+        'def num: if . == "" then null else tonumber end;
+         {region: $region, region_id: $region_id, built_at: $built_at, source_date: $source_date,
+          source_url: (if $source_url == "" then null else $source_url end),
+          graphhopper_version: $graphhopper,
+          output_path: (if $output_path == "" then null else $output_path end),
+          package: (if $package == "" then null else $package end), format: $format,
+          size_bytes: $size_bytes, package_bytes: $package_bytes,
+          package_sha256: (if $package_sha256 == "" then null else $package_sha256 end),
+          osm_bytes: $osm_bytes, import_seconds: ($import_seconds | num), step_seconds: $step_seconds,
+          graph_nodes: ($graph_nodes | num), graph_edges: ($graph_edges | num),
+          graph_bytes: $graph_bytes,
+          graph_bbox: (if $graph_bbox == "" then null else $graph_bbox | split(",") | map(tonumber) end),
+          import_warnings: $import_warnings, anomalies: $anomalies}')
+
+    (
+        # Serialize updates from concurrent region runs
+        command -v flock >/dev/null 2>&1 && flock 7
+        local registry="{}"
+        [ -s "$REGISTRY_FILE" ] && registry=$(cat "$REGISTRY_FILE")
+        echo "$registry" | jq --arg region "$REGION_NAME" --argjson entry "$entry" --argjson repackaged "$repackaged" \
+            'if $repackaged and .[$region] != null
+             then .[$region] += ($entry | {package, format, package_bytes, package_sha256})
+             else .[$region] = $entry end' \
+            > "${REGISTRY_FILE}.tmp" && mv "${REGISTRY_FILE}.tmp" "$REGISTRY_FILE"
+        write_output_index || echo "⚠️  Could not update ${OUTPUT_INDEX_FILE}"
+    ) 7>>"${REGISTRY_FILE}.lock"
 }
 
-# Check if we need to download files (silent check for first-time users)
-OSM_CURRENT=$(is_file_current "$OSM_URL" "$CACHED_OSM_FILE" "${CACHE_TIMESTAMP_FILE}.osm")
-POLY_CURRENT=$(is_file_current "$POLY_URL" "$CACHED_POLY_FILE" "${CACHE_TIMESTAMP_FILE}.poly")
-KML_CURRENT=$(is_file_current "$KML_URL" "$CACHED_KML_FILE" "${CACHE_TIMESTAMP_FILE}.kml")
+# --- Output Catalog ---
+# ./output/index.json lists every package still in ./output, rebuilt from the
+# registry after each build, so sync tools and web servers that publish the
+# output folder can offer an always-current catalog. Paths are relative to
+# ./output; "version" is the date of the OSM data the package was built from.
+OUTPUT_INDEX_FILE="./output/index.json"
 
-# Only show cache status if we have existing cache or output
-if [ -d "./cache" ] && [ "$(ls -A ./cache 2>/dev/null)" ] || [ -d "./output" ] && [ "$(ls -A ./output 2>/dev/null)" ]; then
-    echo "🔍 Checking for cached data and updates..."
-fi
+write_output_index() {
+    find ./output -mindepth 1 -maxdepth 1 -printf '%f\n' \
+        | jq -Rn --slurpfile registry "$REGISTRY_FILE" \
+            --arg generated_at "$(date -u +"%Y-%m-%dT%H:%M:%SZ")" \
+            '[inputs] as $files
+            | {generated_at: $generated_at,
+               packages: [$registry[0][]
+                | (.package // .output_path | sub("^output/"; "")) as $path
+                | select(any($files[]; . == $path))
+                | . as $entry
+                | {region, region_id,
+                   version: (try (.source_date | strptime("%a, %d %b %Y %H:%M:%S GMT") | todate) catch $entry.built_at),
+                   built_at, format,
+                   path: (if .package then $path else "\($path)/" end),
+                   bytes: (if .package then .package_bytes else .size_bytes end),
+                   sha256: .package_sha256,
+                   checksum_path: "\($path).sha256",
+                   source_url}]
+                | sort_by(.region)}' \
+        > "${OUTPUT_INDEX_FILE}.tmp" && mv "${OUTPUT_INDEX_FILE}.tmp" "$OUTPUT_INDEX_FILE"
+}
+
+# --- Build Retention ---
+# With --keep N (VNS_KEEP_BUILDS), each successful build is also copied into a
+# dated folder under ./output/builds/<region>/, 'latest' points at the newest
+# one and builds beyond the newest N are pruned. When a fresh OSM import turns
+# out to be broken, a known-good package is still at hand to roll back to.
+BUILDS_DIR="./output/builds/${REGION_NAME}"
+BUILD_STAMP_GLOB="[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]_[0-9][0-9][0-9][0-9][0-9][0-9]"
+
+archive_build() {
+    local stamp
+    stamp=$(date +%Y%m%d_%H%M%S)
+    local build_dir="${BUILDS_DIR}/${stamp}"
+    rm -rf "${build_dir}.tmp"
+    mkdir -p "${build_dir}.tmp"
+    # The package (or folder) keeps its name, so its .sha256 still verifies
+    # from inside the dated folder
+    if [ -n "$PACKAGE_FILE" ]; then
+        cp -p "./output/${PACKAGE_FILE}" "./output/${PACKAGE_FILE}.sha256" "${build_dir}.tmp/" || return 1
+        if [ -f "${GRAPH_DIR}/metadata.json" ]; then
+            cp -p "${GRAPH_DIR}/metadata.json" "${build_dir}.tmp/" || return 1
+        fi
+    else
+        cp -rp "./output/${GRAPH_FOLDER}" "./output/${GRAPH_FOLDER}.sha256" "${build_dir}.tmp/" || return 1
+    fi
+    mv "${build_dir}.tmp" "$build_dir" || return 1
+
+    # A relative symlink where the file system allows it, a copy otherwise
+    # (e.g. some Windows bind mounts)
+    rm -rf "${BUILDS_DIR}/latest"
+    if ! ln -s "$stamp" "${BUILDS_DIR}/latest" 2>/dev/null; then
+        cp -rp "$build_dir" "${BUILDS_DIR}/latest" || return 1
+    fi
+
+    local old
+    local kept=0
+    for old in $(ls -1d "${BUILDS_DIR}"/${BUILD_STAMP_GLOB} 2>/dev/null | sort -r); do
+        kept=$((kept + 1))
+        if [ "$kept" -gt "$KEEP_BUILDS" ]; then
+            rm -rf "$old"
+            echo "🧹 Pruned old build: ${old#./output/}"
+        fi
+    done
+    echo "📚 Build kept: ${build_dir} ($(( kept < KEEP_BUILDS ? kept : KEEP_BUILDS )) of ${KEEP_BUILDS} kept, 'latest' updated)"
+    log_minimal "build_archived: region=$REGION_NAME, stamp=$stamp, keep=$KEEP_BUILDS"
+}
+
+# True if the build currently in ./output is the one 'latest' points at
+previous_build_archived() {
+    local sidecar
+    for sidecar in "${GRAPH_FOLDER}.zip.sha256" "${GRAPH_FOLDER}.tar.gz.sha256" "${GRAPH_FOLDER}.sha256"; do
+        if [ -f "./output/${sidecar}" ] && cmp -s "./output/${sidecar}" "${BUILDS_DIR}/latest/${sidecar}"; then
+            return 0
+        fi
+    done
+    return 1
+}
 
 # Check if output already exists and all cached files are current
-if [ -f "./output/${GRAPH_FOLDER}.zip" ] || [ -d "./output/${GRAPH_FOLDER}" ]; then
-    if [ "$OSM_CURRENT" = "true" ] && [ "$POLY_CURRENT" = "true" ] && [ "$KML_CURRENT" = "true" ]; then
+if [ -f "./output/${GRAPH_FOLDER}.zip" ] || [ -f "./output/${GRAPH_FOLDER}.tar.gz" ] || [ -d "./output/${GRAPH_FOLDER}" ]; then
+    if [ "$OSM_CURRENT" = "true" ] && [ "$POLY_CURRENT" = "true" ] && [ "$KML_CURRENT" = "true" ] && ! import_settings_changed \
+        && { [ -d "./output/${GRAPH_FOLDER}" ] || { [ "$PACKAGE_ONLY" = "true" ] && [ -f "./output/${PACKAGE_FILE}" ]; }; }; then
+        # Any repackaging below works from the folder in ./output - with
+        # --package-only the work directory of the earlier build is gone
+        if [ -d "./output/${GRAPH_FOLDER}" ]; then
+            GRAPH_DIR="./output/${GRAPH_FOLDER}"
+        fi
+        if [ -n "$PACKAGE_FILE" ] && [ ! -f "./output/${PACKAGE_FILE}" ]; then
+            echo "📦 Region '${REGION_ID}' is up to date - creating missing ${OUTPUT_FORMAT} package..."
+            create_package
+            record_build repackaged
+        elif [ "$ZSYNC" = "true" ] && [ ! -f "./output/${PACKAGE_FILE}.zsync" ] && [ -d "./output/${GRAPH_FOLDER}" ]; then
+            # The existing package is probably deflated; repackage it stored
+            echo "📦 Region '${REGION_ID}' is up to date - repackaging it for zsync..."
+            create_package
+            record_build repackaged
+        fi
         echo "✅ Region '${REGION_ID}' is already up to date!"
-        echo "📁 Using existing output: ./output/${GRAPH_FOLDER}/"
-        echo "📦 ZIP file: ./output/${GRAPH_FOLDER}.zip"
+        if [ -d "./output/${GRAPH_FOLDER}" ]; then
+            echo "📁 Using existing output: ./output/${GRAPH_FOLDER}/"
+        fi
+        if [ -n "$PACKAGE_FILE" ]; then
+            echo "📦 Package: ./output/${PACKAGE_FILE}"
+        fi
         echo ""
         echo "🔄 To force regeneration, delete the output and cache directories:"
         echo "   rm -rf ./output/${GRAPH_FOLDER}* ./cache/${REGION_NAME}*"
+        RUN_RESULT="up_to_date"
         exit 0
     else
         echo "⚠️  Region '${REGION_ID}' output exists but source data has been updated."
-        
-        # Create automatic backup with timestamp
-        backup_timestamp=$(date +%Y%m%d_%H%M%S)
-        echo "📦 Creating automatic backup: ${GRAPH_FOLDER}.backup.${backup_timestamp}"
-        
-        # Backup existing files
-        if [ -d "./output/${GRAPH_FOLDER}" ]; then
-            mv "./output/${GRAPH_FOLDER}" "./output/${GRAPH_FOLDER}.backup.${backup_timestamp}"
-        fi
-        if [ -f "./output/${GRAPH_FOLDER}.zip" ]; then
-            mv "./output/${GRAPH_FOLDER}.zip" "./output/${GRAPH_FOLDER}.backup.${backup_timestamp}.zip"
+
+        # With retention on, the previous build is already kept under
+        # ./output/builds/, so a second backup copy is not needed
+        if [ "$KEEP_BUILDS" -gt 0 ] && previous_build_archived; then
+            PREVIOUS_PACKAGE="${BUILDS_DIR}/latest/${PACKAGE_FILE}"
+            rm -rf "./output/${GRAPH_FOLDER}" "./output/${GRAPH_FOLDER}.zip" "./output/${GRAPH_FOLDER}.tar.gz" \
+                "./output/${GRAPH_FOLDER}".*.sha256 "./output/${GRAPH_FOLDER}.sha256"
+            echo "📚 Previous build is kept in ${BUILDS_DIR}/latest. Proceeding with fresh processing..."
+        else
+            # Create automatic backup with timestamp
+            backup_timestamp=$(date +%Y%m%d_%H%M%S)
+            echo "📦 Creating automatic backup: ${GRAPH_FOLDER}.backup.${backup_timestamp}"
+
+            # Backup existing files
+            if [ -d "./output/${GRAPH_FOLDER}" ]; then
+                mv "./output/${GRAPH_FOLDER}" "./output/${GRAPH_FOLDER}.backup.${backup_timestamp}"
+            fi
+            if [ -f "./output/${GRAPH_FOLDER}.zip" ]; then
+                mv "./output/${GRAPH_FOLDER}.zip" "./output/${GRAPH_FOLDER}.backup.${backup_timestamp}.zip"
+            fi
+            if [ -f "./output/${GRAPH_FOLDER}.tar.gz" ]; then
+                mv "./output/${GRAPH_FOLDER}.tar.gz" "./output/${GRAPH_FOLDER}.backup.${backup_timestamp}.tar.gz"
+            fi
+            PREVIOUS_PACKAGE="./output/${GRAPH_FOLDER}.backup.${backup_timestamp}.${OUTPUT_FORMAT}"
+
+            echo "🔄 Previous data backed up. Proceeding with fresh processing..."
         fi
-        
-        echo "🔄 Previous data backed up. Proceeding with fresh processing..."
     fi
 fi
 
-# Check disk space before starting (require at least 10GB free)
-available_space=$(df . | awk 'NR==2 {print $4}')
-if [ "$available_space" -lt 10485760 ]; then  # 10GB in KB
-    echo "Warning: Low disk space detected. Large regions may fail."
-    echo "Available: $(( available_space / 1024 / 1024 ))GB, Recommended: 10GB+"
-    echo "Continue? (y/N)"
-    read -r response
-    if [ "$response" != "y" ] && [ "$response" != "Y" ]; then
-        echo "Aborted by user"
-        exit 1
-    fi
+# The output volume receives the graph folder plus its package, or only the
+# package with --package-only
+REQUIRED_OUTPUT_MB=$(( PBF_ESTIMATE_MB * 24 / 10 ))
+if [ "$PACKAGE_ONLY" = "true" ]; then
+    REQUIRED_OUTPUT_MB=$(( PBF_ESTIMATE_MB * 12 / 10 ))
+fi
+if [ "$(free_space_mb "${OUTPUT_DIR}")" -lt "$REQUIRED_OUTPUT_MB" ]; then
+    echo "⚠️  Output volume is low on space: $(free_space_mb "${OUTPUT_DIR}")MB free, ~${REQUIRED_OUTPUT_MB}MB needed for the graph and package"
 fi
 
 # --- Smart Data Download ---
+begin_step download
+run_hook pre-download
 echo "Step 1: Downloading/updating map data for '${REGION_ID}'..."
 
+# Overpass reports query timeouts and memory limits inside an HTTP 200 reply
+overpass_response_ok() {
+    local url="$1"
+    local output_file="$2"
+    [ "$url" = "$OVERPASS_URL" ] || return 0
+    if grep -q '<remark> *runtime error' "$output_file" || ! grep -q '<way ' "$output_file"; then
+        echo "❌ Overpass returned no usable data:"
+        grep -o '<remark>.*</remark>' "$output_file" | head -n 3 || echo "   (no ways found in the requested area)"
+        echo "   Try a smaller area, or retry later if the server is busy."
+        return 1
+    fi
+}
+
+# --- Network Interruptions ---
+# A download that loses the connection waits for the network to come back,
+# checking at growing intervals for up to VNS_NETWORK_WAIT_MINUTES, then
+# continues where it stopped with an HTTP Range request (wget -c). A long
+# unattended batch on a flaky link survives short outages this way.
+NETWORK_WAIT_MINUTES="${VNS_NETWORK_WAIT_MINUTES:-60}"
+
+# Returns non-zero if the server is still unreachable after the wait
+wait_for_network() {
+    local url="$1"
+    local waited=0
+    local delay=15
+    while [ "$waited" -lt $((NETWORK_WAIT_MINUTES * 60)) ]; do
+        echo "📡 Network unavailable - checking again in ${delay}s (waited $((waited / 60)) of ${NETWORK_WAIT_MINUTES} min)"
+        sleep "$delay" &
+        CHILD_PID=$!
+        wait "$CHILD_PID"
+        CHILD_PID=""
+        waited=$((waited + delay))
+        if wget -q --spider --tries=1 --timeout=10 "${WGET_OPTS[@]}" "$url" 2>/dev/null; then
+            echo "📶 Network is back after $((waited / 60))m $((waited % 60))s"
+            return 0
+        fi
+        delay=$((delay * 2))
+        if [ "$delay" -gt 300 ]; then
+            delay=300
+        fi
+    done
+    return 1
+}
+
+download_resumable() {
+    local url="$1"
+    local output_file="$2"
+    local status
+    local pipe_status
+    local outages=0
+    local resume=false
+    rm -f "$output_file"
+    # The first attempt streams through md5sum, so the checksum is ready when
+    # the download ends instead of needing a second pass over a multi-GB
+    # file. A resumed download is left for verify_download to hash.
+    DOWNLOAD_MD5=""
+    while true; do
+        status=0
+        if [ "$resume" = "true" ]; then
+            wget -q --show-progress -c --tries=1 --timeout=60 "${WGET_OPTS[@]}" -O "$output_file" "$url" || status=$?
+        else
+            wget -q --show-progress --tries=1 --timeout=60 "${WGET_OPTS[@]}" -O - "$url" | tee "$output_file" | md5sum > "${output_file}.md5.tmp"
+            pipe_status=("${PIPESTATUS[@]}")
+            status=${pipe_status[0]}
+            # md5sum hashed the stream, not the file: it only stands for the
+            # file when tee wrote all of it (a full disk stops tee part-way)
+            if [ "$status" -eq 0 ] && [ "${pipe_status[1]}" -ne 0 ]; then
+                echo "❌ Error: Could not write ${output_file##*/} - is the disk full?"
+                status=${pipe_status[1]}
+            fi
+            if [ "$status" -eq 0 ]; then
+                DOWNLOAD_MD5=$(cut -d' ' -f1 "${output_file}.md5.tmp")
+            fi
+            rm -f "${output_file}.md5.tmp"
+            resume=true
+        fi
+        # wget exit status 4 is a network failure; anything else is not an outage
+        if [ "$status" -ne 4 ] || [ "$NETWORK_WAIT_MINUTES" -le 0 ]; then
+            return "$status"
+        fi
+        outages=$((outages + 1))
+        log_minimal "network_outage: file=${output_file##*/}, received_bytes=$(wc -c < "$output_file" 2>/dev/null || echo 0), outage=$outages"
+        echo "⚠️  Connection lost while downloading ${output_file##*/} ($(du -h "$output_file" 2>/dev/null | cut -f1) received)"
+        if ! wait_for_network "$url"; then
+            echo "❌ Network did not come back within ${NETWORK_WAIT_MINUTES} minutes"
+            return "$status"
+        fi
+        echo "🔁 Resuming download of ${output_file##*/}"
+    done
+}
+
+# Geofabrik publishes an MD5 checksum next to every extract
+# (<extract>.osm.pbf.md5). Compare the download against it, so a corrupted
+# transfer is caught before an hour-long import. Mirrors and custom sources
+# without a checksum file are not checked.
+verify_download() {
+    local url="$1"
+    local output_file="$2"
+    case "$url" in
+        *.osm.pbf) ;;
+        *) return 0 ;;
+    esac
+    local expected
+    expected=$(wget -q --tries=2 --timeout=30 "${WGET_OPTS[@]}" -O - "${url}.md5" 2>/dev/null | awk '{ print $1; exit }') || true
+    if ! [[ "$expected" =~ ^[0-9a-f]{32}$ ]]; then
+        log_minimal "checksum_skipped: file=${output_file##*/}, reason=no_md5_published"
+        return 0
+    fi
+    local actual="$DOWNLOAD_MD5"
+    if [ -z "$actual" ]; then
+        actual=$(md5sum "$output_file" | cut -d' ' -f1)
+    fi
+    if [ "$actual" != "$expected" ]; then
+        echo "❌ Error: ${output_file##*/} does not match Geofabrik's checksum - the download is corrupted"
+        echo "   Expected MD5 ${expected}, got ${actual}."
+        echo "   Run the same command again to download it afresh. If Geofabrik was publishing a new"
+        echo "   extract at that moment, waiting a few minutes helps."
+        log_minimal "checksum_mismatch: file=${output_file##*/}, expected=$expected, actual=$actual"
+        rm -f "$output_file"
+        return 1
+    fi
+    echo "🔐 MD5 matches Geofabrik's checksum"
+    log_minimal "checksum_ok: file=${output_file##*/}, md5=$actual"
+}
+
+# --- Shared Download Cache ---
+# With VNS_SHARED_CACHE, downloads are also kept in a cache shared by several
+# machines. Each file is fetched under a lock in the shared folder that holds
+# across hosts (flock, which NFS and SMB pass on to the server, or an atomic
+# mkdir), so when two operators need the same extract, one downloads it and
+# the other waits and copies it. Files are published under a temporary name
+# and renamed, so a reader never sees a partial extract.
+
+# Copy a file from the shared cache if it holds the current version
+fetch_from_shared_cache() {
+    local url="$1"
+    local output_file="$2"
+    local cached_file="$3"
+    local cache_timestamp_file="$4"
+    local shared_file="${SHARED_CACHE_DIR}/${cached_file##*/}"
+    [ -s "$shared_file" ] || return 1
+    [ "$(cat "${SHARED_CACHE_DIR}/${cache_timestamp_file##*/}" 2>/dev/null)" = "$(get_remote_date "$url")" ] || return 1
+    echo "📂 Copying ${output_file##*/} from the shared cache ($(du -h "$shared_file" | cut -f1))"
+    cp "$shared_file" "${output_file}.tmp" && mv "${output_file}.tmp" "$output_file"
+}
+
+# Publish a downloaded file and its timestamp to the shared cache. Failing
+# to (read-only share, disk full) only costs the other hosts a download.
+publish_to_shared_cache() {
+    local output_file="$1"
+    local cached_file="$2"
+    local cache_timestamp_file="$3"
+    local shared_file="${SHARED_CACHE_DIR}/${cached_file##*/}"
+    local shared_timestamp="${SHARED_CACHE_DIR}/${cache_timestamp_file##*/}"
+    # The .tmp name is per host, so a crashed host cannot clobber another's copy
+    if cp "$output_file" "${shared_file}.$(hostname).tmp" && mv "${shared_file}.$(hostname).tmp" "$shared_file" \
+        && cp "$cache_timestamp_file" "${shared_timestamp}.$(hostname).tmp" \
+        && mv "${shared_timestamp}.$(hostname).tmp" "$shared_timestamp"; then
+        echo "📂 Shared ${output_file##*/} with the team cache"
+    else
+        rm -f "${shared_file}.$(hostname).tmp" "${shared_timestamp}.$(hostname).tmp"
+        echo "⚠️  Could not copy ${output_file##*/} into the shared cache ${SHARED_CACHE_DIR}"
+    fi
+}
+
+# Hard-link a file where source and destination share a file system and
+# copy it otherwise, so a multi-GB extract is not copied between the cache
+# and the work directory. The link or copy is made under a .tmp name and
+# renamed over the destination: writing into it in place would also change
+# the linked file.
+link_or_copy() {
+    local source="$1"
+    local destination="$2"
+    # Already linked (cache and work directory share the file)
+    if [ "$source" -ef "$destination" ]; then
+        return 0
+    fi
+    rm -f "${destination}.tmp"
+    { ln "$source" "${destination}.tmp" 2>/dev/null || cp "$source" "${destination}.tmp"; } \
+        && mv -f "${destination}.tmp" "$destination"
+}
+
 # Function to download with caching
 download_with_cache() {
     local url="$1"
@@ -352,17 +2151,57 @@ download_with_cache() {
     
     if [ "$file_type" = "true" ]; then
         echo "✅ ${output_file##*/} is up to date (using cached version)"
-        cp "$cached_file" "$output_file"
+        link_or_copy "$cached_file" "$output_file"
     else
-        echo "📥 Downloading ${output_file##*/} from: ${url}"
-        if wget -q --show-progress -O "$output_file" "$url"; then
+        # May still be linked to the cached copy from an earlier run
+        rm -f "$output_file"
+        local downloaded=false
+        local shared=false
+        if [ -n "$SHARED_CACHE_DIR" ] && [ "$url" != "$OVERPASS_URL" ]; then
+            shared=true
+            acquire_shared_lock "${cached_file##*/}"
+            if fetch_from_shared_cache "$url" "$output_file" "$cached_file" "$cache_timestamp_file" \
+                && DOWNLOAD_MD5="" && verify_download "$url" "$output_file"; then
+                downloaded=true
+            fi
+        fi
+        if [ "$downloaded" = "true" ]; then
+            # Nothing for this host to download or publish
+            shared=false
+        elif [ "$url" = "$OVERPASS_URL" ]; then
+            echo "📥 Downloading ${output_file##*/} from: ${url}"
+            # An Overpass query is a POST and cannot be resumed
+            if wget -q --show-progress "${WGET_OPTS[@]}" --post-data "data=$(jq -rn --arg q "$OVERPASS_QUERY" '$q | @uri')" -O "$output_file" "$url" \
+                && overpass_response_ok "$url" "$output_file"; then
+                downloaded=true
+                DOWNLOADED_BYTES=$(( DOWNLOADED_BYTES + $(wc -c < "$output_file") ))
+            fi
+        else
+            echo "📥 Downloading ${output_file##*/} from: ${url}"
+            if download_resumable "$url" "$output_file" && verify_download "$url" "$output_file"; then
+                downloaded=true
+                DOWNLOADED_BYTES=$(( DOWNLOADED_BYTES + $(wc -c < "$output_file") ))
+            fi
+        fi
+        if [ "$downloaded" = "true" ]; then
             # Cache the downloaded file
-            cp "$output_file" "$cached_file"
+            track_partial "$cached_file"
+            link_or_copy "$output_file" "$cached_file"
+            clear_partials
             # Store the remote modification date for future comparison
             local remote_date
-            remote_date=$(get_remote_date "$url")
-            echo "$remote_date" > "$cache_timestamp_file"
+            if [ "$url" = "$OVERPASS_URL" ]; then
+                remote_date="${OVERPASS_SIGNATURE} fetched=$(date +%s)"
+            else
+                remote_date=$(get_remote_date "$url")
+            fi
+            echo "$remote_date" > "${cache_timestamp_file}.tmp"
+            mv "${cache_timestamp_file}.tmp" "$cache_timestamp_file"
             echo "💾 Cached ${output_file##*/} for future use"
+            if [ "$shared" = "true" ]; then
+                publish_to_shared_cache "$output_file" "$cached_file" "$cache_timestamp_file"
+            fi
+            release_shared_lock
         else
             echo "Error: Failed to download ${output_file##*/}"
             exit 1
@@ -376,6 +2215,8 @@ download_with_cache "$POLY_URL" "$POLY_FILE" "$CACHED_POLY_FILE" "${CACHE_TIMEST
 download_with_cache "$KML_URL" "$KML_FILE" "$CACHED_KML_FILE" "${CACHE_TIMESTAMP_FILE}.kml" "$KML_CURRENT"
 
 echo "Downloads complete."
+mark_step_done download
+end_step
 
 # Exit early if download-only mode
 if [ "$DOWNLOAD_ONLY" = "true" ]; then
@@ -390,27 +2231,119 @@ if [ "$DOWNLOAD_ONLY" = "true" ]; then
     echo "📊 File size information:"
     if [ -f "$OSM_FILE" ]; then
         OSM_SIZE=$(du -m "$OSM_FILE" | cut -f1)
-        OSM_SIZE_GB=$(echo "scale=1; $OSM_SIZE/1000" | bc)
+        OSM_SIZE_GB=$(awk -v size="$OSM_SIZE" 'BEGIN { printf "%.1f", size / 1000 }')
         echo "  • OSM File: ${OSM_SIZE}MB (${OSM_SIZE_GB}G)"
     fi
     echo ""
     echo "✅ Ready for processing with: ./run.sh ${REGION_ID}"
+    RUN_RESULT="success"
     exit 0
 fi
 
 # --- Check if GraphHopper processing is needed ---
 NEED_PROCESSING="false"
-if [ "$OSM_CURRENT" != "true" ]; then
+RESUME_IMPORT="false"
+if step_done import && [ -f "${WORK_GRAPH_DIR}/properties" ]; then
+    NEED_PROCESSING="true"
+    RESUME_IMPORT="true"
+    echo "♻️  Resuming interrupted build - GraphHopper import already completed for this data"
+elif [ "$OSM_CURRENT" != "true" ]; then
     NEED_PROCESSING="true"
     echo "🔄 OSM data has changed - GraphHopper processing required"
-elif [ ! -d "./${GRAPH_FOLDER}" ] && [ ! -d "./output/${GRAPH_FOLDER}" ]; then
+elif import_settings_changed; then
+    NEED_PROCESSING="true"
+    echo "🔄 Import settings changed ($(previous_import_settings) → ${IMPORT_SETTINGS}) - GraphHopper processing required"
+elif [ ! -d "${WORK_GRAPH_DIR}" ] && [ ! -d "./output/${GRAPH_FOLDER}" ]; then
     NEED_PROCESSING="true"
     echo "🔄 No existing graph data - GraphHopper processing required"
 else
     echo "✅ OSM data unchanged and graph exists - Skipping GraphHopper processing"
 fi
 
-if [ "$NEED_PROCESSING" = "true" ]; then
+if [ "$RESUME_IMPORT" = "true" ]; then
+    echo "Step 2-3: ♻️  Skipping GraphHopper processing (import finished in a previous run)"
+    echo "Step 4: Organizing files for VNS compatibility..."
+
+    mv "${POLY_FILE}" "${WORK_GRAPH_DIR}/"
+    mv "${KML_FILE}" "${WORK_GRAPH_DIR}/"
+elif [ "$NEED_PROCESSING" = "true" ]; then
+    begin_step import
+    select_java
+
+    # --- OSM Pre-processing ---
+    # Run one osmium pass over the staged extract and import its result
+    # instead. Runs in the background so cancellation stays responsive.
+    preprocess_osm() {
+        local description="$1"
+        local output_file="$2"
+        shift 2
+        if ! command -v osmium >/dev/null 2>&1; then
+            echo "⚠️  osmium not found - skipping ${description} (rebuild the Docker image to enable it)"
+            return 0
+        fi
+        echo "🧹 ${description} ($(du -h "$OSM_FILE" | cut -f1) extract)..."
+        track_partial "$output_file"
+        osmium "$@" --overwrite --no-progress -o "$output_file" &
+        CHILD_PID=$!
+        if ! wait "$CHILD_PID"; then
+            echo "❌ Error: osmium failed during: ${description}"
+            exit 1
+        fi
+        CHILD_PID=""
+        clear_partials
+        rm -f "$OSM_FILE"
+        OSM_FILE="$output_file"
+        echo "✅ Extract reduced to $(du -h "$OSM_FILE" | cut -f1)"
+    }
+
+    # GraphHopper only needs roads, ferries, turn restrictions and barriers.
+    # Stripping buildings, landuse and POIs first shrinks the import's heap
+    # requirement considerably for large regions.
+    if [ "$FILTER_ROUTING" = "true" ]; then
+        preprocess_osm "Filtering non-routing data" "${WORK_DIR}/${FILENAME}.routing.osm.pbf" \
+            tags-filter "$OSM_FILE" w/highway w/route=ferry r/type=restriction n/barrier
+    fi
+
+    # Geofabrik extracts include a margin beyond the region's .poly; clipping
+    # to the polygon keeps the import to relevant data. Ways crossing the
+    # border are kept whole so border roads still route. Overpass areas are
+    # already exact.
+    if [ "$CLIP_TO_POLY" = "true" ] && [ -z "$OVERPASS_URL" ]; then
+        preprocess_osm "Clipping to the ${REGION_NAME}.poly boundary" "${WORK_DIR}/${FILENAME}.clipped.osm.pbf" \
+            extract --polygon "$POLY_FILE" --strategy complete_ways "$OSM_FILE"
+    fi
+
+    # --- Extract Statistics ---
+    # One full read of the extract with osmium before the import: a truncated
+    # or corrupt download fails here in minutes instead of hours into the
+    # import, and the object counts refine the memory estimate below.
+    OSM_NODE_COUNT=""
+    if command -v osmium >/dev/null 2>&1; then
+        echo "🔎 Checking extract integrity and contents..."
+        OSM_STATS_FILE="${WORK_DIR}/${REGION_NAME}.fileinfo.json"
+        track_partial "$OSM_STATS_FILE" "${OSM_STATS_FILE}.err"
+        osmium fileinfo --extended --json "$OSM_FILE" > "$OSM_STATS_FILE" 2> "${OSM_STATS_FILE}.err" &
+        CHILD_PID=$!
+        if ! wait "$CHILD_PID"; then
+            echo "❌ Error: The extract ${OSM_FILE##*/} is damaged or truncated:"
+            sed 's/^/   /' "${OSM_STATS_FILE}.err"
+            # Drop the cached copy so the next run downloads it again
+            rm -f "${CACHED_OSM_FILE}" "${CACHE_TIMESTAMP_FILE}.osm"
+            echo "   The cached download was removed - run again to download a fresh copy"
+            log_minimal "error: extract_invalid, file=$OSM_FILE"
+            exit 1
+        fi
+        CHILD_PID=""
+        clear_partials
+        OSM_NODE_COUNT=$(jq -r '.data.count.nodes // empty' "$OSM_STATS_FILE")
+        OSM_WAY_COUNT=$(jq -r '.data.count.ways // empty' "$OSM_STATS_FILE")
+        OSM_RELATION_COUNT=$(jq -r '.data.count.relations // empty' "$OSM_STATS_FILE")
+        OSM_DATA_TIMESTAMP=$(jq -r '.header.option.osmosis_replication_timestamp // .data.timestamp.last // "unknown"' "$OSM_STATS_FILE")
+        rm -f "$OSM_STATS_FILE" "${OSM_STATS_FILE}.err"
+        echo "📊 Extract contents: $(printf "%'d" "$OSM_NODE_COUNT") nodes, $(printf "%'d" "$OSM_WAY_COUNT") ways, $(printf "%'d" "$OSM_RELATION_COUNT") relations (data as of ${OSM_DATA_TIMESTAMP})"
+        log_model_data "extract_stats" "nodes=$OSM_NODE_COUNT, ways=$OSM_WAY_COUNT, relations=$OSM_RELATION_COUNT, timestamp=$OSM_DATA_TIMESTAMP"
+    fi
+
     # --- Dynamic Memory Allocation ---
     echo "Step 2: Configuring GraphHopper memory allocation..."
     
@@ -438,6 +2371,22 @@ if [ "$NEED_PROCESSING" = "true" ]; then
         fi
     }
     
+    # Memory limit of the container we run in (cgroup v2 or v1) in MB, empty
+    # when unlimited. Docker --memory and Kubernetes limits are enforced here,
+    # while /proc/meminfo still reports the whole host.
+    detect_cgroup_memory_limit() {
+        local limit=""
+        if [ -r /sys/fs/cgroup/memory.max ]; then
+            limit=$(cat /sys/fs/cgroup/memory.max)
+        elif [ -r /sys/fs/cgroup/memory/memory.limit_in_bytes ]; then
+            limit=$(cat /sys/fs/cgroup/memory/memory.limit_in_bytes)
+        fi
+        # "max" (v2) or a near-2^63 value (v1) means no limit
+        if [[ "$limit" =~ ^[0-9]+$ ]] && [ "${#limit}" -lt 16 ]; then
+            echo $((limit / 1024 / 1024))
+        fi
+    }
+    
     # Function to calculate required memory based on OSM file size
     calculate_required_memory() {
         local osm_file="$1"
@@ -474,15 +2423,39 @@ if [ "$NEED_PROCESSING" = "true" ]; then
     
     # Detect system memory
     TOTAL_MEMORY_MB=$(detect_system_memory)
+    CONTAINER_LIMIT_MB=$(detect_cgroup_memory_limit)
+    if [ -n "$CONTAINER_LIMIT_MB" ] && [ "$CONTAINER_LIMIT_MB" -lt "$TOTAL_MEMORY_MB" ]; then
+        echo "🐳 Container memory limit: ${CONTAINER_LIMIT_MB}MB (host has ${TOTAL_MEMORY_MB}MB) - sizing for the container"
+        log_minimal "container_memory_limit: limit_mb=$CONTAINER_LIMIT_MB, host_mb=$TOTAL_MEMORY_MB"
+        TOTAL_MEMORY_MB="$CONTAINER_LIMIT_MB"
+    else
+        CONTAINER_LIMIT_MB=""
+    fi
     AVAILABLE_MEMORY_MB=$((TOTAL_MEMORY_MB * 80 / 100))  # Use 80% of total as safe available
     
     # Calculate required memory for this OSM file
     REQUIRED_MEMORY_MB=$(calculate_required_memory "$OSM_FILE")
+
+    # Cross-check against the node count: the size model works out to about
+    # 35 bytes per node for a typical extract (~120k nodes per MB). Extracts
+    # that compress unusually well need more than their size suggests.
+    if [ -n "$OSM_NODE_COUNT" ]; then
+        NODE_MEMORY_MB=$(awk -v n="$OSM_NODE_COUNT" 'BEGIN { printf "%.0f", (n * 35 / 1048576 + 320) * 1.2 }')
+        log_model_data "node_memory_prediction" "nodes=$OSM_NODE_COUNT, model=35B*nodes+320*1.2, predicted_mb=$NODE_MEMORY_MB"
+        if [ "$NODE_MEMORY_MB" -gt "$REQUIRED_MEMORY_MB" ]; then
+            echo "📈 Extract is denser than its size suggests - raising the memory estimate to ${NODE_MEMORY_MB}MB"
+            REQUIRED_MEMORY_MB="$NODE_MEMORY_MB"
+        fi
+    fi
     
     # Apply user override if set
     if [ -n "$VNS_MEMORY_GB" ] && [ "$VNS_MEMORY_GB" -gt 0 ]; then
         ALLOCATED_MEMORY_MB=$((VNS_MEMORY_GB * 1024))
         echo "🎛️  Using user-specified memory: ${VNS_MEMORY_GB}GB"
+        if [ -n "$CONTAINER_LIMIT_MB" ] && [ "$ALLOCATED_MEMORY_MB" -gt "$CONTAINER_LIMIT_MB" ]; then
+            echo "⚠️  VNS_MEMORY_GB=${VNS_MEMORY_GB} is more than the container limit of ${CONTAINER_LIMIT_MB}MB"
+            echo "   The import will be killed once it uses more than the limit - raise the container's memory limit"
+        fi
     else
         # Use required memory, but cap at available memory
         if [ "$REQUIRED_MEMORY_MB" -le "$AVAILABLE_MEMORY_MB" ]; then
@@ -501,16 +2474,24 @@ if [ "$NEED_PROCESSING" = "true" ]; then
     OSM_FILE_SIZE=$(du -sh "$OSM_FILE" | cut -f1)
     OSM_FILE_SIZE_MB=$(du -m "$OSM_FILE" | cut -f1)
     
-    # Run simple system benchmark for hardware-agnostic predictions
-    echo "🔧 Running quick system benchmark..."
-    BENCHMARK_SCORE=$(run_system_benchmark)
+    # Run simple system benchmark for hardware-agnostic predictions, unless
+    # ./run.sh --benchmark has measured this machine with a real import
+    BENCHMARK_FACTOR=$(stored_benchmark_factor)
+    ESTIMATE_SOURCE=""
+    if [ -n "$BENCHMARK_FACTOR" ]; then
+        BENCHMARK_SCORE=""
+        ESTIMATE_SOURCE=" (calibrated by --benchmark, factor ${BENCHMARK_FACTOR})"
+    else
+        echo "🔧 Running quick system benchmark..."
+        BENCHMARK_SCORE=$(run_system_benchmark)
+    fi
     
     # Use benchmark-based prediction with actual measurement lookup table
-    ESTIMATED_TIME_SEC=$(predict_time_with_benchmark "$OSM_FILE_SIZE_MB" "$BENCHMARK_SCORE")
+    ESTIMATED_TIME_SEC=$(predict_time_with_benchmark "$OSM_FILE_SIZE_MB" "$BENCHMARK_SCORE" "$BENCHMARK_FACTOR")
     ESTIMATED_TIME_MIN=$((ESTIMATED_TIME_SEC / 60))
     
     # Log benchmark-based prediction data
-    log_model_data "time_prediction" "file_mb=$OSM_FILE_SIZE_MB, model=benchmark_lookup, predicted_sec=$ESTIMATED_TIME_SEC, benchmark_ms=$BENCHMARK_SCORE"
+    log_model_data "time_prediction" "file_mb=$OSM_FILE_SIZE_MB, model=benchmark_lookup, predicted_sec=$ESTIMATED_TIME_SEC, benchmark_ms=$BENCHMARK_SCORE, benchmark_factor=$BENCHMARK_FACTOR"
     
     # Log verbose system metrics for model refinement
     log_verbose "cores=$(nproc), arch=$(uname -m), total_ram_mb=$TOTAL_MEMORY_MB, allocated_mb=$REQUIRED_MEMORY_MB"
@@ -519,7 +2500,7 @@ if [ "$NEED_PROCESSING" = "true" ]; then
     echo "📊 Processing Analysis:"
     echo "   • OSM File: ${OSM_FILE_SIZE} (${OSM_FILE_SIZE_MB}MB)"
     echo "   • Predicted Memory: ${REQUIRED_MEMORY_GB}GB Docker container"
-    echo "   • Estimated Time: ${ESTIMATED_TIME_MIN} minutes"
+    echo "   • Estimated Time: ${ESTIMATED_TIME_MIN} minutes${ESTIMATE_SOURCE}"
     echo "   • System Memory: ${TOTAL_MEMORY_GB}GB total (${AVAILABLE_MEMORY_MB}MB available)"
     echo "   • Allocated Memory: ${ALLOCATED_MEMORY_GB}GB"
     
@@ -528,10 +2509,18 @@ if [ "$NEED_PROCESSING" = "true" ]; then
         echo ""
         echo "❌ INSUFFICIENT MEMORY WARNING!"
         echo "   • Need: ${REQUIRED_MEMORY_GB}GB"
-        echo "   • Have: ${TOTAL_MEMORY_GB}GB total"
+        if [ -n "$CONTAINER_LIMIT_MB" ]; then
+            echo "   • Have: ${TOTAL_MEMORY_GB}GB container limit"
+        else
+            echo "   • Have: ${TOTAL_MEMORY_GB}GB total"
+        fi
         echo "   • This WILL fail with out-of-memory errors"
         echo ""
         echo "🔧 Solutions:"
+        if [ -n "$CONTAINER_LIMIT_MB" ]; then
+            echo "   • Raise the container memory limit (docker run --memory, Docker Desktop"
+            echo "     resources, or resources.limits.memory in Kubernetes)"
+        fi
         echo "   • Process smaller regions (individual states)"
         echo "   • Add more RAM to your system"
         echo "   • Use a cloud instance with more memory"
@@ -569,24 +2558,277 @@ if [ "$NEED_PROCESSING" = "true" ]; then
     PROCESS_START_TIME=$(date +%s)
     log_minimal "graphhopper_start: timestamp=$PROCESS_START_TIME, allocated_memory=${ALLOCATED_MEMORY_GB}GB"
 
-    # Run GraphHopper using pre-built JAR file with dynamic memory
-    if ! (cd graphhopper && java -Xmx${ALLOCATED_MEMORY_MB}m -Xms${ALLOCATED_MEMORY_MB}m -Ddw.graphhopper.datareader.file="../${OSM_FILE}" -Ddw.graphhopper.graph.location="../${GRAPH_FOLDER}" -jar graphhopper-web-1.0.jar import config-example.yml); then
+    # --- JVM Options ---
+    # G1 handles GraphHopper's large, long-lived heap much better than the
+    # parallel collector, which tends to die with "GC overhead limit
+    # exceeded". VNS_JVM_MAX_RAM_PERCENTAGE sizes the heap from the memory
+    # the container sees instead of the prediction; VNS_JVM_OPTS adds flags.
+    build_jvm_opts() {
+        JVM_OPTS=()
+        if [ -n "$JVM_MAX_RAM_PERCENTAGE" ]; then
+            JVM_OPTS+=("-XX:MaxRAMPercentage=${JVM_MAX_RAM_PERCENTAGE}" "-XX:InitialRAMPercentage=${JVM_MAX_RAM_PERCENTAGE}")
+        else
+            JVM_OPTS+=("-Xmx${ALLOCATED_MEMORY_MB}m" "-Xms${ALLOCATED_MEMORY_MB}m")
+        fi
+        case "$JVM_GC" in
+            g1)       JVM_OPTS+=(-XX:+UseG1GC -XX:+UseStringDeduplication -XX:+ParallelRefProcEnabled) ;;
+            parallel) JVM_OPTS+=(-XX:+UseParallelGC) ;;
+            serial)   JVM_OPTS+=(-XX:+UseSerialGC) ;;
+            zgc)      JVM_OPTS+=(-XX:+UnlockExperimentalVMOptions -XX:+UseZGC) ;;
+        esac
+        # --threads caps the cores the JVM uses (GC and worker pools) and sets
+        # GraphHopper's preparation threads
+        if [ -n "$IMPORT_THREADS" ]; then
+            JVM_OPTS+=("-XX:ActiveProcessorCount=${IMPORT_THREADS}"
+                "-Ddw.graphhopper.prepare.ch.threads=${IMPORT_THREADS}"
+                "-Ddw.graphhopper.prepare.lm.threads=${IMPORT_THREADS}")
+        fi
+        if [ -n "$JVM_EXTRA_OPTS" ]; then
+            read -r -a JVM_EXTRA_ARGS <<< "$JVM_EXTRA_OPTS"
+            JVM_OPTS+=("${JVM_EXTRA_ARGS[@]}")
+        fi
+        log_minimal "jvm_options: ${JVM_OPTS[*]}"
+    }
+
+    # With --timeout, timeout(1) stops a hung import so an unattended batch
+    # can move on to the next region. The limit is kept in seconds too: a
+    # kernel OOM kill exits with 137 just like timeout's kill after the grace
+    # period, and only the elapsed time tells them apart.
+    TIMEOUT_CMD=()
+    IMPORT_TIMEOUT_SECONDS=""
+    if [ -n "$IMPORT_TIMEOUT" ]; then
+        echo "⏱️  Import will be stopped if it runs longer than ${IMPORT_TIMEOUT}"
+        TIMEOUT_CMD=(timeout --kill-after=60 "$IMPORT_TIMEOUT")
+        case "$IMPORT_TIMEOUT" in
+            *m) IMPORT_TIMEOUT_SECONDS=$(( ${IMPORT_TIMEOUT%m} * 60 )) ;;
+            *h) IMPORT_TIMEOUT_SECONDS=$(( ${IMPORT_TIMEOUT%h} * 3600 )) ;;
+            *d) IMPORT_TIMEOUT_SECONDS=$(( ${IMPORT_TIMEOUT%d} * 86400 )) ;;
+            *) IMPORT_TIMEOUT_SECONDS=$(( ${IMPORT_TIMEOUT%s} )) ;;
+        esac
+    fi
+
+    if [ -n "$IMPORT_THREADS" ]; then
+        echo "🧵 Import limited to ${IMPORT_THREADS} thread(s) of $(nproc 2>/dev/null || echo "?") available"
+    fi
+
+    # Run GraphHopper using pre-built JAR file with dynamic memory. It runs in
+    # the background so a cancellation signal is handled immediately instead
+    # of after the (possibly hour-long) import finishes. Its complete output
+    # is also kept in ./output/logs/<region>/import.log, so a failure hours
+    # into an unattended run can be diagnosed afterwards. Extra arguments are
+    # passed to java before the GraphHopper settings.
+
+    # Show how far GraphHopper has read the extract. While writing the graph
+    # OSMReader logs a running count of OSM elements ("200 000, locs:...");
+    # against the node/way/relation total from the extract statistics that
+    # gives a real percentage. Lines pass through unchanged.
+    import_progress_filter() {
+        if [ -z "$OSM_NODE_COUNT" ]; then
+            cat
+            return
+        fi
+        awk -v total="$((OSM_NODE_COUNT + OSM_WAY_COUNT + OSM_RELATION_COUNT))" '
+            { print; fflush() }
+            /OSMReader - [0-9][0-9 ,.]*, locs:/ {
+                count = $0
+                sub(/.*OSMReader - /, "", count)
+                sub(/, locs:.*/, "", count)
+                gsub(/[^0-9]/, "", count)
+                pct = int(count * 100 / total)
+                if (pct > 99) pct = 99
+                if (pct >= shown + 5) {
+                    shown = pct - pct % 5
+                    printf "📈 Reading OSM data: %d%% (%.0f of %.0f elements)\n", pct, count, total
+                    fflush()
+                }
+            }
+            /creating graph\. Found nodes/ && !pass2 {
+                pass2 = 1
+                print "📈 Pre-processing done - building the graph"
+                fflush()
+            }
+            /[Pp]repar/ && !prepare && pass2 {
+                prepare = 1
+                print "📈 Reading OSM data: 100% - preparing routing data"
+                fflush()
+            }'
+    }
+
+    # Live gauges: free space on the temp and output volumes and the RSS of
+    # the java process every VNS_GAUGE_INTERVAL seconds (0 turns them off),
+    # with a warning once a volume drops below VNS_DISK_WARN_MB. Running out
+    # of disk mid-import otherwise ends in an obscure GraphHopper exception.
+    GAUGE_INTERVAL=${VNS_GAUGE_INTERVAL:-60}
+    DISK_WARN_MB=${VNS_DISK_WARN_MB:-1024}
+
+    # java itself, or its child when it runs under timeout(1)
+    import_java_pid() {
+        local pid="$1"
+        if [ "$(cat "/proc/${pid}/comm" 2>/dev/null)" = "java" ]; then
+            echo "$pid"
+            return
+        fi
+        local stat
+        for stat in /proc/[0-9]*/stat; do
+            if [ "$(awk '{ print $4 }' "$stat" 2>/dev/null)" = "$pid" ]; then
+                basename "$(dirname "$stat")"
+                return
+            fi
+        done
+    }
+
+    monitor_import_resources() {
+        local pid="$1"
+        local warned_work=false
+        local warned_output=false
+        local warned_memory=false
+        local java_pid work_free output_free rss_mb
+        local sleep_pid=""
+        # Take the sleep down too when the import ends, or it keeps the
+        # output open for up to a whole interval
+        trap 'kill "$sleep_pid" 2>/dev/null; exit 0' TERM
+        while kill -0 "$pid" 2>/dev/null; do
+            sleep "$GAUGE_INTERVAL" &
+            sleep_pid=$!
+            wait "$sleep_pid"
+            kill -0 "$pid" 2>/dev/null || break
+            java_pid=$(import_java_pid "$pid")
+            rss_mb=$(awk '/^VmRSS:/ { printf "%d", $2 / 1024 }' "/proc/${java_pid}/status" 2>/dev/null) || true
+            work_free=$(free_space_mb "$WORK_DIR")
+            output_free=$(free_space_mb "$OUTPUT_DIR")
+            echo "📟 Free: ${work_free:-?}MB temp, ${output_free:-?}MB output | java: ${rss_mb:-?}MB of ${ALLOCATED_MEMORY_MB}MB heap"
+
+            if [ -n "$work_free" ] && [ "$work_free" -lt "$DISK_WARN_MB" ] && [ "$warned_work" = "false" ]; then
+                warned_work=true
+                echo "⚠️  Temp volume almost full: ${work_free}MB left in ${WORK_DIR} - the import fails if it fills up"
+            fi
+            if [ -n "$output_free" ] && [ "$output_free" -lt "$DISK_WARN_MB" ] && [ "$warned_output" = "false" ]; then
+                warned_output=true
+                echo "⚠️  Output volume almost full: ${output_free}MB left - the graph is copied there next"
+            fi
+            # The heap is reserved up front; RSS close to the machine's memory
+            # means the kernel may kill java before it reports an error
+            if [ -n "$rss_mb" ] && [ "$rss_mb" -gt $((TOTAL_MEMORY_MB * 9 / 10)) ] && [ "$warned_memory" = "false" ]; then
+                warned_memory=true
+                echo "⚠️  java is using ${rss_mb}MB of ${TOTAL_MEMORY_MB}MB memory - close other applications"
+            fi
+        done
+    }
+
+    run_import() {
+        # A leftover graph from an interrupted import would be loaded instead
+        # of rebuilt, so always start the import from an empty location
+        rm -rf "${WORK_GRAPH_DIR}"
+        # tee runs on its own descriptor so we can wait for it to flush the
+        # log before it is inspected
+        local tee_pid
+        local started
+        started=$(date +%s)
+        exec 8> >(tee -a "$IMPORT_LOG" | import_progress_filter)
+        tee_pid=$!
+        "${TIMEOUT_CMD[@]}" "$JAVA_BIN" "${JVM_OPTS[@]}" "$@" -Ddw.graphhopper.datareader.file="${OSM_FILE}" -Ddw.graphhopper.graph.location="${WORK_GRAPH_DIR}" -jar "${GRAPHHOPPER_JAR}" import graphhopper/config-example.yml >&8 2>&1 &
+        CHILD_PID=$!
+        local monitor_pid=""
+        if [ "$GAUGE_INTERVAL" -gt 0 ] 2>/dev/null; then
+            # Not on fd 8: the import log has to close when java exits
+            monitor_import_resources "$CHILD_PID" 8>&- &
+            monitor_pid=$!
+        fi
+        IMPORT_STATUS=0
+        wait "$CHILD_PID" || IMPORT_STATUS=$?
+        # 124: timeout(1) stopped java; 137 after the deadline: it had to be
+        # killed after the grace period. 137 before it is the OOM killer.
+        IMPORT_TIMED_OUT=false
+        if [ -n "$IMPORT_TIMEOUT" ] && { [ "$IMPORT_STATUS" -eq 124 ] \
+            || { [ "$IMPORT_STATUS" -eq 137 ] && [ $(( $(date +%s) - started )) -ge "$IMPORT_TIMEOUT_SECONDS" ]; }; }; then
+            IMPORT_TIMED_OUT=true
+        fi
+        if [ -n "$monitor_pid" ]; then
+            kill "$monitor_pid" 2>/dev/null || true
+            wait "$monitor_pid" 2>/dev/null || true
+        fi
+        exec 8>&-
+        wait "$tee_pid" 2>/dev/null || true
+    }
+
+    # Out of heap (reported by java) or killed by the kernel OOM killer
+    import_ran_out_of_memory() {
+        if grep -qE "OutOfMemoryError|GC overhead limit exceeded" "$IMPORT_LOG" 2>/dev/null; then
+            echo "true"
+        elif [ "$IMPORT_STATUS" -eq 137 ] && [ "$IMPORT_TIMED_OUT" != "true" ]; then
+            echo "true"
+        else
+            echo "false"
+        fi
+    }
+
+    track_partial "${WORK_GRAPH_DIR}"
+    : > "$IMPORT_LOG"
+    build_jvm_opts
+    run_import
+
+    # Running out of memory is the most common failure. Retry once: with a
+    # bigger heap when there is RAM to spare, otherwise with GraphHopper's
+    # memory-mapped storage, which keeps the graph on disk instead of the heap.
+    if [ "$IMPORT_STATUS" -ne 0 ] && [ "$OOM_RETRY" = "true" ] && [ "$(import_ran_out_of_memory)" = "true" ]; then
+        RETRY_MEMORY_MB=$((ALLOCATED_MEMORY_MB * 3 / 2))
+        if [ "$RETRY_MEMORY_MB" -gt "$AVAILABLE_MEMORY_MB" ]; then
+            RETRY_MEMORY_MB="$AVAILABLE_MEMORY_MB"
+        fi
+        echo ""
+        if [ -z "$JVM_MAX_RAM_PERCENTAGE" ] && [ "$IMPORT_STATUS" -ne 137 ] && [ "$RETRY_MEMORY_MB" -ge $((ALLOCATED_MEMORY_MB + 512)) ]; then
+            echo "🔁 GraphHopper ran out of memory with a ${ALLOCATED_MEMORY_MB}MB heap - retrying once with ${RETRY_MEMORY_MB}MB"
+            log_minimal "oom_retry: mode=heap, from_mb=$ALLOCATED_MEMORY_MB, to_mb=$RETRY_MEMORY_MB"
+            ALLOCATED_MEMORY_MB="$RETRY_MEMORY_MB"
+            ALLOCATED_MEMORY_GB=$((ALLOCATED_MEMORY_MB / 1024))
+            build_jvm_opts
+            run_import
+        else
+            echo "🔁 GraphHopper ran out of memory and no more RAM is available - retrying once"
+            echo "   with memory-mapped storage (slower, keeps the graph on disk instead of in RAM)"
+            log_minimal "oom_retry: mode=mmap, heap_mb=$ALLOCATED_MEMORY_MB"
+            run_import -Ddw.graphhopper.graph.dataaccess=MMAP
+        fi
+    fi
+
+    if [ "$IMPORT_TIMED_OUT" = "true" ]; then
+        CHILD_PID=""
+        rm -rf "${WORK_GRAPH_DIR}"
+        echo "❌ Error: GraphHopper import did not finish within ${IMPORT_TIMEOUT} and was stopped"
+        log_minimal "error: graphhopper_timeout, timeout=$IMPORT_TIMEOUT, file_mb=$OSM_FILE_SIZE_MB, allocated_mb=$ALLOCATED_MEMORY_MB"
+        echo "  • Predicted import time was about $((ESTIMATED_TIME_SEC / 60)) minutes"
+        echo "  • Allow longer with --timeout, or reduce the work with VNS_FILTER_ROUTING=true"
+        echo "  • Downloaded data is kept in ./cache for the next attempt"
+        exit 124
+    fi
+
+    if [ "$IMPORT_STATUS" -ne 0 ]; then
         # Enable verbose logging for error case
         LOG_VERBOSE_ON_ERROR=true
         
         echo "❌ Error: GraphHopper import failed"
         log_minimal "error: graphhopper_failed, file_mb=$OSM_FILE_SIZE_MB, allocated_mb=$ALLOCATED_MEMORY_MB"
-        log_verbose "error_details: exit_code=$?, allocated_memory=${ALLOCATED_MEMORY_MB}MB"
+        log_verbose "error_details: exit_code=$IMPORT_STATUS, allocated_memory=${ALLOCATED_MEMORY_MB}MB"
         echo ""
         echo "🔧 Troubleshooting - GraphHopper Import Failed:"
+        if grep -q "No space left on device" "$IMPORT_LOG" 2>/dev/null || [ "$(free_space_mb "$WORK_DIR")" -lt 100 ]; then
+            log_minimal "error: disk_full, work_dir=$WORK_DIR, free_mb=$(free_space_mb "$WORK_DIR")"
+            echo "  • ⚠️  The temp volume ran out of disk space ($(free_space_mb "$WORK_DIR")MB free in ${WORK_DIR})"
+            echo "  • Free up space, or point VNS_TEMP_DIR at a bigger disk"
+            echo ""
+        fi
         echo "  • Allocated ${ALLOCATED_MEMORY_GB}GB but processing still failed"
         echo ""
         echo "💾 Memory Solutions:"
         echo "  • Try more memory: export VNS_MEMORY_GB=$((ALLOCATED_MEMORY_GB + 4))"
+        if [ "$FILTER_ROUTING" != "true" ]; then
+            echo "  • Strip non-routing data before import: export VNS_FILTER_ROUTING=true"
+        fi
         echo "  • Close other applications to free memory"  
         echo "  • Check Docker Desktop has sufficient memory allocated"
         echo ""
         echo "🗺️  Region Solutions:"
+        echo "  • Build Geofabrik's sub-regions instead: ./run.sh ${REGION_ID} --auto-split"
         echo "  • Process individual states instead of large regions"
         echo "  • Split massive regions into smaller geographic chunks"
         echo "  • Consider using cloud instance with more RAM"
@@ -596,6 +2838,11 @@ if [ "$NEED_PROCESSING" = "true" ]; then
         echo "  • ${POLY_FILE}"
         echo "  • ${KML_FILE}"
         echo ""
+        # GraphHopper's own output already scrolled past; repeat its end here
+        echo "📄 Last lines of the GraphHopper output:"
+        tail -n 20 "$IMPORT_LOG" 2>/dev/null | sed 's/^/   │ /'
+        echo "   Complete output: ${IMPORT_LOG} (attach it to a bug report)"
+        echo ""
         echo "📋 TROUBLESHOOTING LOG:"
         echo "  • Full log saved to: ./logs/ folder"
         echo "  • Share this log when reporting issues"
@@ -627,10 +2874,22 @@ if [ "$NEED_PROCESSING" = "true" ]; then
         echo "📈 This data helps us refine our 91% accurate prediction models!"
         echo "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━"
         echo ""
+        # The marker tells run.sh that memory ran out, so it can offer to
+        # build the region's sub-regions instead. Not an exit code: under
+        # set -e any failing command could return the same one.
+        if [ "$(import_ran_out_of_memory)" = "true" ]; then
+            touch "${REGION_LOG_DIR}/out-of-memory"
+        fi
         exit 1
     fi
 
+    CHILD_PID=""
+    clear_partials
+    echo "$IMPORT_SETTINGS" > "${IMPORT_SETTINGS_FILE}.tmp"
+    mv "${IMPORT_SETTINGS_FILE}.tmp" "$IMPORT_SETTINGS_FILE"
     echo "GraphHopper import complete. A new folder named '${GRAPH_FOLDER}' has been created."
+    mark_step_done import
+    end_step
     
     # Calculate actual processing time
     PROCESS_END_TIME=$(date +%s)
@@ -639,20 +2898,27 @@ if [ "$NEED_PROCESSING" = "true" ]; then
     # Log completion with prediction vs actual comparison
     log_minimal "graphhopper_complete: predicted_sec=$ESTIMATED_TIME_SEC, actual_sec=$ACTUAL_TIME_SEC, accuracy_percent=$(awk -v pred="$ESTIMATED_TIME_SEC" -v actual="$ACTUAL_TIME_SEC" 'BEGIN { if (pred > 0) { diff = (pred > actual) ? pred - actual : actual - pred; printf "%.0f", 100 - (diff/pred)*100 } else { print "0" } }')"
     log_model_data "timing_result" "region=$REGION_NAME, file_mb=$OSM_FILE_SIZE_MB, predicted_sec=$ESTIMATED_TIME_SEC, actual_sec=$ACTUAL_TIME_SEC, benchmark_ms=$BENCHMARK_SCORE"
+
+    if [ "$BENCHMARK_RUN" = "true" ]; then
+        save_benchmark "$ACTUAL_TIME_SEC" "$OSM_FILE_SIZE_MB"
+        rm -rf "$WORK_GRAPH_DIR" "$OSM_FILE" "$POLY_FILE" "$KML_FILE" "$IMPORT_SETTINGS_FILE" "$STATE_FILE"
+        RUN_RESULT="benchmark"
+        exit 0
+    fi
     
     # --- File Organization ---
     echo "Step 4: Organizing files for VNS compatibility..."
 
     # Move both boundary files into the newly created graph folder
-    mv "${POLY_FILE}" "./${GRAPH_FOLDER}/"
-    mv "${KML_FILE}" "./${GRAPH_FOLDER}/"
+    mv "${POLY_FILE}" "${WORK_GRAPH_DIR}/"
+    mv "${KML_FILE}" "${WORK_GRAPH_DIR}/"
 else
     echo "Step 2-3: ⚡ Skipping GraphHopper processing (using existing data)"
     echo "Step 4: Using cached GraphHopper data..."
     
     # If we have existing output, copy it to working directory
     if [ -d "./output/${GRAPH_FOLDER}" ]; then
-        cp -r "./output/${GRAPH_FOLDER}" "./"
+        cp -r "./output/${GRAPH_FOLDER}" "${WORK_DIR}/"
         echo "✅ Copied existing graph data from output directory"
     else
         echo "❌ Error: No cached graph data found. This shouldn't happen."
@@ -661,19 +2927,25 @@ else
     fi
     
     # Update boundary files in case they changed
-    cp "${POLY_FILE}" "./${GRAPH_FOLDER}/"
-    cp "${KML_FILE}" "./${GRAPH_FOLDER}/"
+    cp "${POLY_FILE}" "${WORK_GRAPH_DIR}/"
+    cp "${KML_FILE}" "${WORK_GRAPH_DIR}/"
 fi
 
+if [ "$NEED_PROCESSING" = "true" ]; then
+    run_hook post-import
+fi
+
+begin_step organize
+
 # Handle timestamp files
 if [ "$NEED_PROCESSING" = "true" ]; then
     # Extract the creation timestamp from the 'properties' file inside the graph folder
-    if [ ! -f "./${GRAPH_FOLDER}/properties" ]; then
+    if [ ! -f "${WORK_GRAPH_DIR}/properties" ]; then
         echo "Error: Properties file not found in ${GRAPH_FOLDER}. GraphHopper import may have failed."
         exit 1
     fi
 
-    TIMESTAMP=$(grep 'datareader.data_date' "./${GRAPH_FOLDER}/properties" | cut -d'=' -f2)
+    TIMESTAMP=$(grep 'datareader.data_date' "${WORK_GRAPH_DIR}/properties" | cut -d'=' -f2)
 
     if [ -z "$TIMESTAMP" ]; then
         echo "Warning: Could not automatically determine timestamp. Using current time."
@@ -681,39 +2953,282 @@ if [ "$NEED_PROCESSING" = "true" ]; then
     fi
 
     # Create both timestamp files required by VNS (matching the structure you found)
-    echo "${TIMESTAMP}" > "./${GRAPH_FOLDER}/timestamp"
-    echo "${TIMESTAMP}" > "./${GRAPH_FOLDER}/${REGION_NAME}.timestamp"
+    echo "${TIMESTAMP}" > "${WORK_GRAPH_DIR}/timestamp"
+    echo "${TIMESTAMP}" > "${WORK_GRAPH_DIR}/${REGION_NAME}.timestamp"
     echo "Timestamp files created with value: ${TIMESTAMP}"
 else
     echo "Timestamp files preserved from existing data"
 fi
 
+# --- Boundary Preview ---
+# A small SVG drawing of the .poly boundary travels with the package, so
+# whoever receives it can see at a glance which area it covers. Longitude is
+# scaled by cos(latitude) so the outline is not stretched away from the equator.
+BOUNDARY_PREVIEW="${VNS_PREVIEW:-true}"
+
+write_boundary_preview() {
+    local poly_file="$1"
+    local svg_file="$2"
+    awk -v title="$REGION_NAME" '
+        # Custom area names are free text: escape them for the XML
+        BEGIN {
+            gsub(/&/, "\\&amp;", title); gsub(/</, "\\&lt;", title)
+            gsub(/>/, "\\&gt;", title); gsub(/"/, "\\&quot;", title)
+        }
+        NR == 1 { next }
+        $1 == "END" { in_ring = 0; next }
+        !in_ring { in_ring = 1; rings++; next }
+        NF >= 2 {
+            count[rings]++
+            x[rings, count[rings]] = $1
+            y[rings, count[rings]] = $2
+            if (!points++) { min_x = max_x = $1; min_y = max_y = $2 }
+            if ($1 < min_x) min_x = $1; if ($1 > max_x) max_x = $1
+            if ($2 < min_y) min_y = $2; if ($2 > max_y) max_y = $2
+        }
+        END {
+            if (!points) exit 1
+            k = cos((min_y + max_y) / 2 * 3.14159265 / 180)
+            w = (max_x - min_x) * k; h = max_y - min_y
+            if (w <= 0) w = 0.001; if (h <= 0) h = 0.001
+            scale = 400 / (w > h ? w : h)
+            width = w * scale + 20; height = h * scale + 20
+            printf "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%.0f\" height=\"%.0f\" viewBox=\"0 0 %.0f %.0f\">\n", width, height + 36, width, height + 36
+            printf "<title>%s</title>\n", title
+            printf "<rect width=\"100%%\" height=\"100%%\" fill=\"#f4f6f8\"/>\n"
+            printf "<path fill=\"#5b8def\" fill-opacity=\"0.35\" stroke=\"#1d4ed8\" stroke-width=\"1.5\" fill-rule=\"evenodd\" d=\""
+            for (r = 1; r <= rings; r++) {
+                for (i = 1; i <= count[r]; i++)
+                    printf "%s%.1f,%.1f", (i == 1 ? "M" : "L"), 10 + (x[r, i] - min_x) * k * scale, 10 + (max_y - y[r, i]) * scale
+                if (count[r]) printf "Z"
+            }
+            printf "\"/>\n"
+            printf "<text x=\"10\" y=\"%.0f\" font-family=\"sans-serif\" font-size=\"14\" fill=\"#222\">%s</text>\n", height + 14, title
+            printf "<text x=\"10\" y=\"%.0f\" font-family=\"sans-serif\" font-size=\"11\" fill=\"#555\">%.3f,%.3f to %.3f,%.3f (lon,lat)</text>\n", height + 30, min_x, min_y, max_x, max_y
+            print "</svg>"
+        }' "$poly_file" > "${svg_file}.tmp" && mv "${svg_file}.tmp" "$svg_file"
+}
+
+if [ "$BOUNDARY_PREVIEW" = "true" ] && [ -f "${WORK_GRAPH_DIR}/${REGION_NAME}.poly" ]; then
+    if write_boundary_preview "${WORK_GRAPH_DIR}/${REGION_NAME}.poly" "${WORK_GRAPH_DIR}/${REGION_NAME}-preview.svg"; then
+        echo "🖼️  Boundary preview created: ${REGION_NAME}-preview.svg"
+    else
+        rm -f "${WORK_GRAPH_DIR}/${REGION_NAME}-preview.svg.tmp"
+        echo "⚠️  Could not draw a boundary preview from ${REGION_NAME}.poly"
+    fi
+fi
+
+# --- Graph Statistics ---
+# GraphHopper keeps its counters in the binary headers of the 'nodes' and
+# 'edges' files: a "GH" marker, the file length and segment size, then int
+# fields starting at byte 16 (big-endian). nodes: count at 20, then the
+# bounding box as minLon, maxLon, minLat, maxLat in 1e-7 degrees; edges:
+# count at 20. The import log is only a fallback for files we cannot read.
+
+# Read the signed big-endian int at a byte offset of a file
+read_header_int() {
+    local file="$1"
+    local offset="$2"
+    od -An -tu1 -j "$offset" -N 4 "$file" 2>/dev/null | awk 'NF == 4 {
+        value = (($1 * 256 + $2) * 256 + $3) * 256 + $4
+        if (value >= 2147483648) value -= 4294967296
+        printf "%d\n", value
+    }'
+}
+
+# True if a file starts with GraphHopper's "GH" header marker
+has_graphhopper_header() {
+    [ "$(od -An -tx1 -N 4 "$1" 2>/dev/null | tr -d ' \n')" = "00024748" ]
+}
+
+read_graph_stats() {
+    local graph_dir="$1"
+    GRAPH_NODES=""
+    GRAPH_EDGES=""
+    GRAPH_BBOX=""
+
+    if has_graphhopper_header "${graph_dir}/nodes" && has_graphhopper_header "${graph_dir}/edges"; then
+        local nodes
+        local edges
+        nodes=$(read_header_int "${graph_dir}/nodes" 20)
+        edges=$(read_header_int "${graph_dir}/edges" 20)
+        if [ -n "$nodes" ] && [ -n "$edges" ] && [ "$nodes" -gt 0 ] && [ "$edges" -ge 0 ]; then
+            GRAPH_NODES="$nodes"
+            GRAPH_EDGES="$edges"
+            GRAPH_BBOX=$(for offset in 24 28 32 36; do read_header_int "${graph_dir}/nodes" "$offset"; done | awk '
+                { v[NR] = $1 / 10000000 }
+                END {
+                    # minLon, maxLon, minLat, maxLat -> minLon,minLat,maxLon,maxLat
+                    if (NR == 4 && v[1] <= v[2] && v[3] <= v[4] && v[1] >= -180 && v[2] <= 180 && v[3] >= -90 && v[4] <= 90)
+                        printf "%.5f,%.5f,%.5f,%.5f\n", v[1], v[3], v[2], v[4]
+                }')
+        fi
+    fi
+
+    # Older logs are all we have when the headers are unreadable
+    if [ -z "$GRAPH_NODES" ] && [ -f "$IMPORT_LOG" ]; then
+        GRAPH_EDGES=$(grep -o 'edges:[0-9 ,.]*(' "$IMPORT_LOG" 2>/dev/null | tail -n 1 | tr -cd '0-9')
+        GRAPH_NODES=$(grep -o 'nodes:[0-9 ,.]*(' "$IMPORT_LOG" 2>/dev/null | tail -n 1 | tr -cd '0-9')
+    fi
+
+    # Bytes of the graph itself, without boundaries, previews and metadata
+    GRAPH_BYTES=$(find "$graph_dir" -maxdepth 1 -type f \
+        ! -name '*.poly' ! -name '*.kml' ! -name '*.svg' ! -name '*timestamp' ! -name 'metadata.json' \
+        -exec wc -c {} + 2>/dev/null | awk '$2 != "total" { sum += $1 } END { print sum + 0 }')
+}
+
+# Write metadata.json next to the graph, for tools that pick up the package
+write_graph_metadata() {
+    local metadata_file="${WORK_GRAPH_DIR}/metadata.json"
+    jq -n \
+        --arg region "$REGION_NAME" \
+        --arg region_id "$REGION_ID" \
+        --arg source_date "$(cat "${WORK_GRAPH_DIR}/timestamp" 2>/dev/null)" \
+        --arg built_at "$(date -u +"%Y-%m-%dT%H:%M:%SZ")" \
+        --arg graphhopper "$GRAPHHOPPER_VERSION" \
+        --arg nodes "$GRAPH_NODES" \
+        --arg edges "$GRAPH_EDGES" \
+        --argjson bytes "$GRAPH_BYTES" \
+        --arg bbox "$GRAPH_BBOX" \
+        'def num: if . == "" then null else tonumber end;
+         {region: $region, region_id: $region_id,
+          source_date: (if $source_date == "" then null else $source_date end),
+          built_at: $built_at, graphhopper_version: $graphhopper,
+          graph: {nodes: ($nodes | num), edges: ($edges | num), bytes: $bytes,
+                  bbox: (if $bbox == "" then null else $bbox | split(",") | map(tonumber) end)}}' \
+        > "${metadata_file}.tmp" && mv "${metadata_file}.tmp" "$metadata_file"
+}
+
+read_graph_stats "$WORK_GRAPH_DIR"
+if ! write_graph_metadata; then
+    rm -f "${WORK_GRAPH_DIR}/metadata.json.tmp"
+    echo "⚠️  Could not write metadata.json"
+fi
+log_minimal "graph_stats: nodes=${GRAPH_NODES:-unknown}, edges=${GRAPH_EDGES:-unknown}, bytes=$GRAPH_BYTES, bbox=${GRAPH_BBOX:-unknown}"
+
+# --- Build Comparison ---
+# OpenStreetMap data grows slowly, so a rebuilt graph that is much smaller than
+# the previous build of the same region usually means a truncated extract or
+# a broken import, not real map changes. Compare against the registry entry
+# before it is replaced and before the package goes anywhere.
+DIFF_THRESHOLD="${VNS_DIFF_THRESHOLD:-25}"
+BUILD_ANOMALIES=()
+
+compare_with_previous_build() {
+    if ! [[ "$DIFF_THRESHOLD" =~ ^[0-9]+$ ]]; then
+        echo "⚠️  Ignoring invalid VNS_DIFF_THRESHOLD '${DIFF_THRESHOLD}' - using 25"
+        DIFF_THRESHOLD=25
+    fi
+    if [ "$DIFF_THRESHOLD" -eq 0 ] || [ ! -s "$REGISTRY_FILE" ]; then
+        return 0
+    fi
+    local previous
+    previous=$(jq -c --arg region "$REGION_NAME" --arg region_id "$REGION_ID" \
+        '.[$region] | select(. != null and .region_id == $region_id)' "$REGISTRY_FILE" 2>/dev/null)
+    if [ -z "$previous" ]; then
+        return 0
+    fi
+
+    local field
+    local label
+    local current
+    local before
+    local change
+    while IFS=: read -r field label current; do
+        before=$(echo "$previous" | jq -r --arg field "$field" '.[$field] // empty')
+        if [ -z "$current" ] || [ -z "$before" ] || [ "$before" -le 0 ]; then
+            continue
+        fi
+        change=$(awk -v before="$before" -v now="$current" 'BEGIN { printf "%d", (now - before) * 100 / before }')
+        # Shrinking past the threshold, or more than doubling (wrong area?)
+        if [ "$change" -lt "-${DIFF_THRESHOLD}" ] || [ "$change" -gt 100 ]; then
+            BUILD_ANOMALIES+=("${label} ${before} → ${current} (${change}%)")
+        fi
+    done <<EOF
+graph_nodes:node count:${GRAPH_NODES}
+graph_edges:edge count:${GRAPH_EDGES}
+graph_bytes:graph size in bytes:${GRAPH_BYTES}
+EOF
+
+    if [ ${#BUILD_ANOMALIES[@]} -eq 0 ]; then
+        echo "✅ Graph is in line with the previous build ($(echo "$previous" | jq -r '.built_at[0:10]'))"
+        return 0
+    fi
+    echo ""
+    echo "⚠️  This graph differs sharply from the previous build of ${REGION_ID} ($(echo "$previous" | jq -r '.built_at[0:10]')):"
+    local anomaly
+    for anomaly in "${BUILD_ANOMALIES[@]}"; do
+        echo "   • ${anomaly}"
+    done
+    echo "   A truncated download or a failed import step is the usual cause. Check the"
+    echo "   import log before distributing the package. To rebuild from a fresh download:"
+    echo "   rm ./cache/${REGION_NAME}.osm.pbf && ./run.sh ${REGION_ID}"
+    echo "   (Changed --clip/--filter-routing settings explain a difference too.)"
+    echo ""
+    log_minimal "build_anomaly: region=$REGION_NAME, $(IFS=';'; echo "${BUILD_ANOMALIES[*]}")"
+}
+
+if [ "$NEED_PROCESSING" = "true" ]; then
+    compare_with_previous_build
+fi
+
 # --- Finalizing Output ---
 echo "Step 5: Moving final data to the output directory..."
 # The 'output' directory inside the container is mapped to the user's local machine.
 
-# Use cp instead of mv to avoid cross-device issues, then remove source
-# (Output directory cleanup already handled at the beginning)
-if cp -r "./${GRAPH_FOLDER}" "./output/"; then
-    rm -rf "./${GRAPH_FOLDER}"
-    echo "Data successfully moved to output directory"
+# A rename when the work directory is on the same file system as the output
+# (instant, no second copy of the graph), otherwise cp then remove the source,
+# so a failed copy leaves the graph in the work directory. A partial copy is
+# removed on exit. (Output directory cleanup already handled at the beginning)
+if [ "$PACKAGE_ONLY" = "true" ]; then
+    echo "📦 Package only: the graph is packaged straight from ${WORK_GRAPH_DIR}"
+    end_step
 else
-    echo "❌ Error: Failed to copy data to output directory"
-    echo "💾 Processed data preserved in: ./${GRAPH_FOLDER}"
-    echo "You can manually copy it to ./output/ if needed"
-    exit 1
+    MOVE_GRAPH=(cp -r "${WORK_GRAPH_DIR}" "./output/")
+    if [ "$(stat -c %d "$WORK_DIR")" = "$(stat -c %d ./output)" ]; then
+        MOVE_GRAPH=(mv "${WORK_GRAPH_DIR}" "./output/")
+    fi
+    track_partial "./output/${GRAPH_FOLDER}"
+    # In the background, so a cancellation stops a long copy at once
+    "${MOVE_GRAPH[@]}" &
+    CHILD_PID=$!
+    if ! wait "$CHILD_PID"; then
+        echo "❌ Error: Failed to copy data to output directory"
+        echo "💾 Processed data preserved in: ${WORK_GRAPH_DIR}"
+        echo "You can manually copy it to ./output/ if needed"
+        exit 1
+    fi
+    CHILD_PID=""
+    clear_partials
+    rm -rf "${WORK_GRAPH_DIR}"
+    echo "Data successfully moved to output directory"
+    end_step
 fi
 
-echo "Step 6: Creating ZIP file for easy transfer..."
-cd ./output/
-zip -r "${GRAPH_FOLDER}.zip" "${GRAPH_FOLDER}/"
-echo "ZIP file created: ${GRAPH_FOLDER}.zip ($(du -sh "${GRAPH_FOLDER}.zip" | cut -f1))"
-cd ..
+begin_step package
+echo "Step 6: Packaging output for easy transfer..."
+if [ -n "$PACKAGE_FILE" ]; then
+    track_partial "./output/${PACKAGE_FILE}" "./output/${PACKAGE_FILE}.sha256" "./output/${PACKAGE_FILE}.sha256.tmp"
+fi
+create_package
+clear_partials
+create_delta
+run_hook post-zip
+end_step
 
 echo "Cleanup: Removing temporary working files (keeping cache)..."
 rm -f "${OSM_FILE}" "${POLY_FILE}" "${KML_FILE}"
 
+record_build
+if [ "$KEEP_BUILDS" -gt 0 ] && ! archive_build; then
+    rm -rf "${BUILDS_DIR}/"*.tmp
+    echo "⚠️  Could not keep a dated copy of this build in ${BUILDS_DIR} (disk full?)"
+fi
+if [ "$PACKAGE_ONLY" = "true" ]; then
+    rm -rf "${WORK_GRAPH_DIR}"
+fi
 echo "Process finished."
+RUN_RESULT="success"
 echo ""
 # Final success logging
 if [ -n "$PROCESS_START_TIME" ]; then
@@ -725,14 +3240,34 @@ fi
 echo "🎉 VNS offline routing data successfully generated for ${REGION_NAME}!"
 echo ""
 echo "Generated files:"
-echo "  📁 Folder: ./output/${GRAPH_FOLDER}/"
-echo "  📦 ZIP file: ./output/${GRAPH_FOLDER}.zip"
+if [ "$PACKAGE_ONLY" != "true" ]; then
+    echo "  📁 Folder: ./output/${GRAPH_FOLDER}/"
+fi
+echo "  📈 Graph: ${GRAPH_NODES:-?} nodes, ${GRAPH_EDGES:-?} edges, $(( GRAPH_BYTES / 1024 / 1024 ))MB${GRAPH_BBOX:+ covering ${GRAPH_BBOX} (lon,lat)}"
+echo "  ⏱️  Time: $(step_breakdown) (total $(format_duration $(( $(date +%s) - RUN_STARTED_AT ))))"
+if [ -n "$PACKAGE_FILE" ]; then
+    echo "  📦 Package: ./output/${PACKAGE_FILE}"
+    echo "  🔐 Checksum: ./output/${PACKAGE_FILE}.sha256"
+    if [ -n "$DELTA_FILE" ]; then
+        echo "  🧩 Delta: ${DELTA_FILE}"
+    fi
+    if [ -f "./output/${PACKAGE_FILE}.zsync" ]; then
+        echo "  🔄 zsync: ./output/${PACKAGE_FILE}.zsync"
+    fi
+else
+    echo "  🔐 Checksums: ./output/${GRAPH_FOLDER}.sha256"
+fi
 echo "  💾 Cached data: ./cache/${REGION_NAME}* (for faster future updates)"
 echo ""
 echo "📱 INSTALLATION INSTRUCTIONS FOR VNS:"
 echo "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━"
-echo "1. Transfer the ZIP file to your Android device"
-echo "2. Extract the ZIP file to get the '${GRAPH_FOLDER}' folder"
+if [ -n "$PACKAGE_FILE" ]; then
+    echo "1. Transfer ${PACKAGE_FILE} to your Android device"
+    echo "2. Extract it to get the '${GRAPH_FOLDER}' folder"
+else
+    echo "1. Transfer the './output/${GRAPH_FOLDER}' folder to your Android device"
+    echo "2. (No archive to extract - directory output was selected)"
+fi
 echo "3. Copy the ENTIRE '${GRAPH_FOLDER}' folder to your device at:"
 echo ""
 echo "   📍 /storage/emulated/0/atak/tools/VNS/GH/${GRAPH_FOLDER}/"
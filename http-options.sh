@@ -0,0 +1,71 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - HTTP Options
+#
+# Description:
+# Sourced by the scripts that talk to Geofabrik, mirrors or notification
+# services. Turns the settings below into CURL_OPTS and WGET_OPTS, which every
+# curl and wget call adds after its own options, so a restricted network
+# (TLS-inspecting proxy, slow satellite link) is tuned in one place:
+#
+#   VNS_CA_BUNDLE=<file.pem>        CA certificates to trust, e.g. the system
+#                                   CAs plus the proxy's own CA
+#   VNS_TLS_MIN=1.2|1.3             oldest TLS version to accept
+#   VNS_CONNECT_TIMEOUT=<seconds>   give up on a connection attempt
+#   VNS_READ_TIMEOUT=<seconds>      give up when no data arrives for this long
+#   VNS_HTTP_KEEPALIVE=false        ask servers to close each connection
+#   VNS_CURL_OPTS / VNS_WGET_OPTS   any further options, e.g. "--ipv4"
+#
+# Each request is a separate curl or wget process, so there is no connection
+# pool to size.
+# ==============================================================================
+
+CURL_OPTS=()
+WGET_OPTS=()
+
+if [ -n "${VNS_CA_BUNDLE:-}" ]; then
+    if [ -f "$VNS_CA_BUNDLE" ]; then
+        CURL_OPTS+=(--cacert "$VNS_CA_BUNDLE")
+        WGET_OPTS+=(--ca-certificate="$VNS_CA_BUNDLE")
+    else
+        echo "⚠️  VNS_CA_BUNDLE '${VNS_CA_BUNDLE}' not found - using the system CA certificates" >&2
+    fi
+fi
+
+case "${VNS_TLS_MIN:-}" in
+    "") ;;
+    1.2)
+        CURL_OPTS+=(--tlsv1.2)
+        WGET_OPTS+=(--secure-protocol=TLSv1_2)
+        ;;
+    1.3)
+        CURL_OPTS+=(--tlsv1.3)
+        WGET_OPTS+=(--secure-protocol=TLSv1_3)
+        ;;
+    *)
+        echo "⚠️  VNS_TLS_MIN must be 1.2 or 1.3 (got '${VNS_TLS_MIN}') - ignored" >&2
+        ;;
+esac
+
+# After a call's own --timeout, so these win over its connect/read parts
+if [[ "${VNS_CONNECT_TIMEOUT:-}" =~ ^[0-9]+$ ]]; then
+    CURL_OPTS+=(--connect-timeout "$VNS_CONNECT_TIMEOUT")
+    WGET_OPTS+=(--connect-timeout="$VNS_CONNECT_TIMEOUT")
+fi
+if [[ "${VNS_READ_TIMEOUT:-}" =~ ^[0-9]+$ ]]; then
+    # curl has no read timeout: abort below 1 byte/s for that long instead
+    CURL_OPTS+=(--speed-limit 1 --speed-time "$VNS_READ_TIMEOUT")
+    WGET_OPTS+=(--read-timeout="$VNS_READ_TIMEOUT")
+fi
+
+# Some proxies mishandle persistent connections
+if [ "${VNS_HTTP_KEEPALIVE:-true}" = "false" ]; then
+    CURL_OPTS+=(-H "Connection: close")
+    WGET_OPTS+=(--no-http-keep-alive)
+fi
+
+# shellcheck disable=SC2206 # word splitting is intended
+CURL_OPTS+=(${VNS_CURL_OPTS:-})
+# shellcheck disable=SC2206
+WGET_OPTS+=(${VNS_WGET_OPTS:-})
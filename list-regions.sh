@@ -5,11 +5,56 @@
 #
 # Description:
 # Lists available Geofabrik download regions in clean, organized hierarchy.
-# Groups regions properly by continent with clear separation.
+# Groups regions properly by continent with clear separation. Regions already
+# built on this machine (./cache/registry.json) are marked with their build
+# date; --missing hides them. Regions with subregions show how many they
+# contain and, with --sizes, how large their download is. A search term
+# narrows the listing to matching regions, shown under their parents with
+# the matched text in [brackets]. It also matches ISO 3166 codes (DE,
+# US-NC), US state abbreviations (NC) and the names in aliases.tsv
+# (Deutschland). Pinned regions and the last few built ones are listed
+# first, so a region you build often is one copy away.
+#
+# Usage:
+# ./list-regions.sh             # all regions
+# ./list-regions.sh carolina    # only regions whose name or ID contains "carolina"
+# ./list-regions.sh NC          # by ISO code, state abbreviation or alias
+# ./list-regions.sh --missing   # only regions not built on this machine yet
+# ./list-regions.sh --sizes     # add download sizes (one request per region)
+# ./list-regions.sh --depth 1   # countries only, without their subregions
+# ./list-regions.sh --refresh   # revalidate the cached index now
+# ./list-regions.sh --pin us/delaware     # always list it first (--unpin to undo)
+# ./list-regions.sh --copy us/delaware    # copy "./run.sh us/delaware" to the clipboard
+# ./list-regions.sh --copy-url us/delaware  # copy the download URL instead
+# ./list-regions.sh --open us/delaware    # open its Geofabrik page in the browser
 # ==============================================================================
 
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+[ -f "$(dirname "$0")/http-options.sh" ] && . "$(dirname "$0")/http-options.sh"
+[ -f "$(dirname "$0")/desktop-open.sh" ] && . "$(dirname "$0")/desktop-open.sh"
+
 INDEX_URL="https://download.geofabrik.de/index-v1-nogeom.json"
 
+# Shared with generate-data.sh: revalidated with its ETag after 24h or --refresh
+INDEX_CACHE_FILE="./cache/geofabrik-index.json"
+INDEX_ETAG_FILE="${INDEX_CACHE_FILE}.etag"
+INDEX_CHECKED_FILE="${INDEX_CACHE_FILE}.checked"
+INDEX_MAX_AGE_MINUTES=$(( ${VNS_INDEX_MAX_AGE_HOURS:-24} * 60 ))
+REFRESH_INDEX="${VNS_REFRESH_INDEX:-false}"
+REGISTRY_FILE="./cache/registry.json"
+ALIASES_FILE="$(dirname "$0")/aliases.tsv"
+PINNED_FILE="./cache/pinned-regions.txt"
+RECENT_COUNT="${VNS_RECENT_REGIONS:-5}"
+HIDE_BUILT=false
+SHOW_SIZES=false
+DEPTH=0
+SEARCH=""
+PIN=""
+UNPIN=""
+COPY=""
+COPY_URL=false
+OPEN=""
+
 # Check if jq is installed
 check_jq() {
     if ! command -v jq >/dev/null 2>&1; then
@@ -56,8 +101,203 @@ dns_check_host() {
     echo "could not check (no getent/host/nslookup/python3 available)"
 }
 
+index_cache_fresh() {
+    [ "$REFRESH_INDEX" != "true" ] && [ -s "$INDEX_CACHE_FILE" ] && [ -f "$INDEX_CHECKED_FILE" ] \
+        && [ -z "$(find "$INDEX_CHECKED_FILE" -mmin +"$INDEX_MAX_AGE_MINUTES" 2>/dev/null)" ]
+}
+
+# One conditional fetch attempt; extra arguments (e.g. -4) are passed to curl.
+# Prints the index on success, including "304 Not Modified".
+fetch_index() {
+    # Unique names: a build running in the container may refresh the index too
+    local index_tmp
+    index_tmp=$(mktemp "${INDEX_CACHE_FILE}.XXXXXX") || return 1
+    local headers_file
+    headers_file=$(mktemp "${INDEX_CACHE_FILE}.headers.XXXXXX") || { rm -f "$index_tmp"; return 1; }
+    local conditional=()
+    if [ -s "$INDEX_CACHE_FILE" ] && [ -s "$INDEX_ETAG_FILE" ]; then
+        conditional=(-H "If-None-Match: $(cat "$INDEX_ETAG_FILE")")
+    fi
+
+    if ! curl "$@" -sS --fail --max-time 30 "${CURL_OPTS[@]}" "${conditional[@]}" \
+        -D "$headers_file" -o "$index_tmp" "$INDEX_URL"; then
+        rm -f "$index_tmp" "$headers_file"
+        return 1
+    fi
+
+    if ! grep -q "^HTTP/[0-9.]* 304" "$headers_file"; then
+        [ -s "$index_tmp" ] || { rm -f "$index_tmp" "$headers_file"; return 1; }
+        grep -i '^ETag:' "$headers_file" | tail -n 1 | sed 's/^[Ee][Tt][Aa][Gg]: *//' | tr -d '\r' > "$INDEX_ETAG_FILE"
+        chmod 644 "$index_tmp"
+        mv "$index_tmp" "$INDEX_CACHE_FILE"
+    fi
+    rm -f "$index_tmp" "$headers_file"
+    touch "$INDEX_CHECKED_FILE"
+    cat "$INDEX_CACHE_FILE"
+}
+
+# Number of subregions (at any depth) of every region that has any, as a
+# JSON object of id -> count
+subregion_counts() {
+    jq -c '(reduce .features[].properties as $p ({}; .[$p.id] = $p.parent)) as $parent
+        | reduce (.features[].properties.id) as $id ({};
+            reduce ($id | recurse($parent[.] // empty) | select(. != $id)) as $ancestor (.; .[$ancestor] += 1))'
+}
+
+# Download size of every region from the Content-Length of their
+# extracts, eight requests at a time, as a JSON object of id -> bytes. A
+# parent's extract contains all its subregions, so this is also their total.
+region_sizes() {
+    jq -r '.features[] | select(.properties.urls.pbf != null) | "\(.properties.id) \(.properties.urls.pbf)"' \
+        | xargs -P 8 -n 2 bash -c 'printf "%s\t%s\n" "${@: -2:1}" "$(curl -sSI --max-time 15 "${@:1:$#-2}" "${@: -1}" 2>/dev/null | grep -i "^Content-Length:" | tail -n 1 | tr -dc 0-9)"' _ "${CURL_OPTS[@]}" \
+        | jq -Rnc '[inputs | split("\t") | select(.[1] != "") | {key: .[0], value: (.[1] | tonumber)}] | from_entries'
+}
+
+# Add or remove a pinned region; the ID must be in the index
+update_pins() {
+    local json_data="$1"
+    local id="${PIN:-$UNPIN}"
+    if ! echo "$json_data" | jq -e --arg id "$id" 'any(.features[]; .properties.id == $id)' >/dev/null; then
+        echo "❌ Error: Unknown region ID '${id}' - pin the exact ID shown after ./run.sh"
+        exit 1
+    fi
+    mkdir -p "$(dirname "$PINNED_FILE")"
+    touch "$PINNED_FILE"
+    {
+        grep -vxF "$id" "$PINNED_FILE"
+        if [ -n "$PIN" ]; then
+            echo "$id"
+        fi
+    } > "${PINNED_FILE}.tmp"
+    mv "${PINNED_FILE}.tmp" "$PINNED_FILE"
+    if [ -n "$PIN" ]; then
+        echo "📌 Pinned ${id}"
+    else
+        echo "📌 Unpinned ${id}"
+    fi
+    echo ""
+}
+
+# Put text on the system clipboard; fails when no clipboard tool is found
+to_clipboard() {
+    if [ -n "${WAYLAND_DISPLAY:-}" ] && command -v wl-copy >/dev/null 2>&1; then
+        printf '%s' "$1" | wl-copy
+    elif command -v pbcopy >/dev/null 2>&1; then
+        printf '%s' "$1" | pbcopy
+    elif command -v clip.exe >/dev/null 2>&1; then
+        printf '%s' "$1" | clip.exe
+    elif command -v xclip >/dev/null 2>&1; then
+        printf '%s' "$1" | xclip -selection clipboard
+    elif command -v xsel >/dev/null 2>&1; then
+        printf '%s' "$1" | xsel --clipboard --input
+    else
+        return 1
+    fi
+}
+
+# Copy the run command (or download URL) of a region instead of listing
+copy_region() {
+    local json_data="$1"
+    local text
+    text=$(echo "$json_data" | jq -r --arg id "$COPY" --argjson url "$COPY_URL" \
+        '.features[].properties | select(.id == $id) | if $url then .urls.pbf else "./run.sh " + .id end')
+    if [ -z "$text" ]; then
+        echo "❌ Error: Unknown region ID '${COPY}' - use the exact ID shown after ./run.sh"
+        exit 1
+    fi
+    if to_clipboard "$text"; then
+        echo "📋 Copied: ${text}"
+    else
+        echo "📋 ${text}"
+        echo "   (no clipboard tool found - install wl-clipboard, xclip or xsel to copy directly)"
+    fi
+    exit 0
+}
+
+# Open the Geofabrik download page of a region (update date, subregions,
+# other formats) instead of listing
+open_region_page() {
+    local json_data="$1"
+    local url
+    url=$(echo "$json_data" | jq -r --arg id "$OPEN" \
+        '.features[].properties | select(.id == $id) | .urls.pbf // empty | sub("-latest\\.osm\\.pbf$"; ".html")')
+    if [ -z "$url" ]; then
+        echo "❌ Error: Unknown region ID '${OPEN}' - use the exact ID shown after ./run.sh"
+        exit 1
+    fi
+    if open_browser "$url"; then
+        echo "🔗 Opened ${url}"
+    else
+        echo "🔗 ${url}"
+    fi
+    exit 0
+}
+
+# Pinned regions, then the most recently built ones, above the full listing
+show_favorites() {
+    local json_data="$1"
+    local pinned="[]"
+    if [ -s "$PINNED_FILE" ]; then
+        pinned=$(jq -R -s -c 'split("\n") | map(select(length > 0))' "$PINNED_FILE")
+    fi
+    local recent="[]"
+    if [ -s "$REGISTRY_FILE" ]; then
+        recent=$(jq -c '[.[] | select(.region_id != null)] | sort_by(.built_at) | reverse | map(.region_id)' "$REGISTRY_FILE" 2>/dev/null || echo "[]")
+    fi
+    local lines
+    lines=$(echo "$json_data" | jq -r --argjson pinned "$pinned" --argjson recent "$recent" --argjson n "$RECENT_COUNT" '
+        (reduce .features[].properties as $p ({}; .[$p.id] = $p.name)) as $names
+        | ($pinned[] | select($names[.]) | "\($names[.])\t→ ./run.sh \(.)   📌 pinned"),
+          ([$recent[] | select($names[.]) | select(. as $id | $pinned | index([$id]) | not)]
+           | .[:$n][] | "\($names[.])\t→ ./run.sh \(.)")')
+    if [ -n "$lines" ]; then
+        echo "⭐ Pinned and Recent:"
+        echo "$lines" | format_output
+        echo ""
+    fi
+}
+
 # Main function
 main() {
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --refresh) REFRESH_INDEX=true ;;
+            --missing) HIDE_BUILT=true ;;
+            --sizes)   SHOW_SIZES=true ;;
+            --depth)
+                DEPTH="$2"
+                shift
+                ;;
+            --pin)
+                PIN="$2"
+                shift
+                ;;
+            --unpin)
+                UNPIN="$2"
+                shift
+                ;;
+            --copy)
+                COPY="$2"
+                shift
+                ;;
+            --copy-url)
+                COPY="$2"
+                COPY_URL=true
+                shift
+                ;;
+            --open)
+                OPEN="$2"
+                shift
+                ;;
+            -*)        ;;
+            *)         SEARCH="$1" ;;
+        esac
+        shift
+    done
+    if ! [[ "$DEPTH" =~ ^[0-9]+$ ]]; then
+        echo "Error: --depth needs a number of levels (0 lists all)"
+        exit 1
+    fi
     check_jq
     
     echo "🌍 VNS Offline Routing - Available Regions"
@@ -78,14 +318,19 @@ main() {
     # Clean the temp file up even if the user hits Ctrl-C mid-fetch.
     [ "$err_file" != "/dev/null" ] && trap 'rm -f "$err_file"' EXIT
 
-    while [ $retry_count -lt $max_retries ]; do
+    mkdir -p "$(dirname "$INDEX_CACHE_FILE")"
+    if index_cache_fresh; then
+        json_data=$(cat "$INDEX_CACHE_FILE")
+    fi
+
+    while [ -z "$json_data" ] && [ $retry_count -lt $max_retries ]; do
         # Try a normal (dual-stack) request first; on failure, retry forcing
         # IPv4 (-4) to work around hosts where IPv6 is configured but broken.
         # Real errors are captured to $err_file so we can show them if we give up.
-        if json_data=$(curl -sS --fail --max-time 30 "$INDEX_URL" 2>>"$err_file") && [ -n "$json_data" ]; then
+        if json_data=$(fetch_index 2>>"$err_file") && [ -n "$json_data" ]; then
             break
         fi
-        if json_data=$(curl -4 -sS --fail --max-time 30 "$INDEX_URL" 2>>"$err_file") && [ -n "$json_data" ]; then
+        if json_data=$(fetch_index -4 2>>"$err_file") && [ -n "$json_data" ]; then
             break
         fi
         retry_count=$((retry_count + 1))
@@ -120,6 +365,79 @@ main() {
     local total_count
     total_count=$(echo "$json_data" | jq '.features | length')
     
+    # Region ID -> build date of everything built on this machine
+    local built="{}"
+    if [ -s "$REGISTRY_FILE" ]; then
+        built=$(jq -c 'with_entries({key: .value.region_id, value: .value.built_at[0:10]})' "$REGISTRY_FILE" 2>/dev/null || echo "{}")
+    fi
+
+    local counts
+    counts=$(echo "$json_data" | subregion_counts)
+    local sizes="{}"
+    if [ "$SHOW_SIZES" = "true" ]; then
+        echo "📏 Checking download sizes..."
+        sizes=$(echo "$json_data" | region_sizes)
+        echo ""
+    fi
+    # "(12 subregions, 4.1 GB)" and the build badge after a region
+    local notes='
+        def size: if . >= 1073741824 then "\(. / 107374182.4 | round / 10) GB" else "\(. / 1048576 | round) MB" end;
+        def note($id; $word):
+            [($counts[$id] // empty | "\(.) \($word)"), ($sizes[$id] // empty | size)]
+            | (if length > 0 then "   (" + join(", ") + ")" else "" end)
+              + (if $built | has($id) then "   ✅ built " + $built[$id] else "" end);'
+
+    # Matching regions plus all their parents, as a JSON object of id -> true;
+    # regions found by code or alias map to that code or alias instead
+    local keep="null"
+    if [ -n "$SEARCH" ]; then
+        local aliases="{}"
+        if [ -f "$ALIASES_FILE" ]; then
+            aliases=$(awk -F'\t' '!/^#/ && NF >= 2 { print $1 "\t" $2 }' "$ALIASES_FILE" \
+                | jq -R -s -c '[split("\n")[] | select(length > 0) | split("\t") | {key: .[0], value: .[1]}] | from_entries')
+        fi
+        keep=$(echo "$json_data" | jq -c --arg q "$SEARCH" --argjson aliases "$aliases" '
+            (reduce .features[].properties as $p ({}; .[$p.id] = $p.parent)) as $parent
+            | ($q | ascii_upcase) as $code
+            | ($q | ascii_downcase | gsub("[ _]+"; "-")) as $wanted
+            | ($q | ascii_downcase) as $q
+            # Aliases ignore case; spaces and underscores count as "-", as in run.sh
+            | ([$aliases | to_entries[] | (.key | ascii_downcase | gsub("[ _]+"; "-")) as $a
+                | select($a | startswith($wanted)) | {key: .value, value: .key}] | from_entries) as $by_alias
+            | [.features[].properties
+               | if (.name | ascii_downcase | contains($q)) or (.id | contains($q)) then {key: .id, value: true}
+                 elif (.["iso3166-1:alpha2"] // []) + (.["iso3166-2"] // []) | index([$code]) then {key: .id, value: $code}
+                 elif (.["iso3166-2"] // []) | index(["US-" + $code]) then {key: .id, value: ("US-" + $code)}
+                 elif $by_alias[.id] then {key: .id, value: $by_alias[.id]}
+                 else empty end] as $matches
+            | [$matches[] | .key | recurse($parent[.] // empty) | select(. != null) | {key: ., value: true}]
+            | from_entries + ($matches | from_entries)')
+        if [ "$keep" = "{}" ]; then
+            echo "❌ No region matches '${SEARCH}'"
+            exit 1
+        fi
+    fi
+    # Name with the search term in [brackets], case-insensitively, or the
+    # code or alias it was found by after it
+    local marked='
+        def marked:
+            if $q == "" then .name
+            elif ($keep[.id] | type) == "string" then .name + " [" + $keep[.id] + "]"
+            else .name | sub("(?<m>" + ($q | gsub("(?<c>[][\\\\^$.|?*+(){}])"; "\\\(.c)")) + ")"; "[\(.m)]"; "i") end;'
+
+    if [ -n "$COPY" ]; then
+        copy_region "$json_data"
+    fi
+    if [ -n "$OPEN" ]; then
+        open_region_page "$json_data"
+    fi
+    if [ -n "$PIN$UNPIN" ]; then
+        update_pins "$json_data"
+    fi
+    if [ -z "$SEARCH" ]; then
+        show_favorites "$json_data"
+    fi
+
     echo "📍 Available Regions by Continent:"
     echo ""
     
@@ -129,17 +447,45 @@ main() {
     
     # Process each continent
     for continent in $continents; do
+        if [ "$keep" != "null" ] && ! echo "$keep" | jq -e --arg id "$continent" 'has($id)' >/dev/null; then
+            continue
+        fi
         local continent_name
         continent_name=$(echo "$json_data" | jq -r --arg cont "$continent" '.features[] | select(.properties.id == $cont) | .properties.name')
         
-        echo "📍 $continent_name:"
+        echo "📍 $continent_name:$(jq -rn --arg id "$continent" --argjson counts "$counts" --argjson sizes "$sizes" \
+            --argjson built "$built" "${notes}"' note($id; "regions") | ltrimstr("  ")')"
         
-        # Show children of this continent with proper alignment
-        echo "$json_data" | jq -r --arg cont "$continent" '
-            .features[] | 
-            select(.properties.parent == $cont) | 
-            .properties.name + "\t→ ./run.sh " + .properties.id
-        ' | sort | format_output
+        # Show the regions of this continent at every depth (e.g. Germany ->
+        # Baden-Württemberg -> Regierungsbezirke), indented under their parent
+        echo "$json_data" | jq -r --arg cont "$continent" --argjson built "$built" --argjson hide "$HIDE_BUILT" --argjson depth "$DEPTH" \
+            --argjson counts "$counts" --argjson sizes "$sizes" --argjson keep "$keep" --arg q "$SEARCH" \
+            "${notes}${marked}"'
+            (reduce .features[].properties as $p ({}; .[$p.parent // ""] += [$p])) as $children
+            | (reduce (.features[].properties | select((.["iso3166-1:alpha2"] // []) | length == 1)) as $p
+                ({}; .[$p["iso3166-1:alpha2"][0]] //= $p.name)) as $countries
+            # Composite extracts (dach, britain-and-ireland, alps, us-south)
+            # span several countries or states: several ISO codes, or none
+            # at all on a country-level region without subregions
+            | def composite:
+                ((.["iso3166-1:alpha2"] // []) | length > 1)
+                or (.parent == $cont and .["iso3166-1:alpha2"] == null and .["iso3166-2"] == null and $children[.id] == null);
+            def includes:
+                if (.["iso3166-1:alpha2"] // []) | length > 1
+                then "includes " + ([.["iso3166-1:alpha2"][] | $countries[.] // .] | join(", "))
+                else "see " + (.urls.pbf // "" | sub("-latest\\.osm\\.pbf$"; ".html")) end;
+            def shown: select(($hide and ($built | has(.id))) | not) | select($keep == null or (.id as $id | $keep | has($id)));
+            def subtree($parent; $indent):
+                ($children[$parent] // []) | sort_by(.name) | .[]
+                | select(composite | not) | shown
+                | .id as $id
+                | ($indent + marked + "\t→ ./run.sh " + $id + note($id; "subregions")),
+                  (if $depth == 0 or ($indent | length) / 2 + 1 < $depth then subtree($id; $indent + "  ") else empty end);
+            subtree($cont; ""),
+            ([($children[$cont] // [])[] | select(composite) | shown] | sort_by(.name)
+             | if length > 0 then "Composite extracts (overlap the regions above):\t" else empty end,
+               (.[] | ("  " + marked + "\t→ ./run.sh " + .id + note(.id; "subregions")), ("    " + includes + "\t")))
+        ' | format_output
         
         echo ""
     done
@@ -149,7 +495,16 @@ main() {
     echo "   • Smaller regions = faster processing"
     echo "   • Larger regions = more time and memory needed"
     echo ""
-    echo "📊 Total: $total_count regions available"
+    if [ "$keep" != "null" ]; then
+        echo "📊 Matching '${SEARCH}': shown with their parent regions, out of $total_count regions"
+    else
+        echo "📊 Total: $total_count regions available"
+    fi
+    local built_count
+    built_count=$(echo "$built" | jq 'length')
+    if [ "$built_count" -gt 0 ]; then
+        echo "✅ Built on this machine: ${built_count} (./status.sh checks them for newer data)"
+    fi
     echo "🔗 Browse online: https://download.geofabrik.de/"
 }
 
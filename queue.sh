@@ -0,0 +1,230 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Batch Queue
+#
+# Description:
+# Shows and edits the queue of a multi-region run.sh batch while it is
+# running. run.sh picks the next region from ./cache/batch-queue.<pid> each
+# time a region finishes, so changes take effect without cancelling the batch.
+#
+# Usage:
+# ./queue.sh                       # show finished, running and queued regions
+# ./queue.sh add <region> [...]    # append regions to the queue
+# ./queue.sh remove <region> [...] # drop queued regions
+# ./queue.sh first <region>        # process this region next
+# ./queue.sh up|down <region>      # move a region one place
+# ./queue.sh skip                  # cancel the running region, continue with the next
+# ./queue.sh --batch <pid> ...     # pick a batch when several are running
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+
+BATCH_HOST=$(uname -n)
+USAGE="Usage: ./queue.sh [--batch <pid>] [list | add <region>... | remove <region>... | first|up|down <region> | skip]"
+
+# A value from a batch plan: run.sh writes it as "<key> <value>" lines, one
+# per array element, and it is read as data, never sourced
+plan_values() {
+    local plan="$1"
+    local key="$2"
+    sed -n "s/^${key} //p" "$plan" 2>/dev/null
+}
+
+# Plans of the batches running on this machine: run.sh writes
+# ./cache/batch-plan.<pid> and records its host and PID in it
+live_batch_plans() {
+    local plan
+    local owner
+    for plan in ./cache/batch-plan.*; do
+        case "$plan" in
+            *.tmp) continue ;;
+        esac
+        if [ ! -f "$plan" ]; then
+            continue
+        fi
+        owner="$(plan_values "$plan" owner_host)|$(plan_values "$plan" owner_pid)"
+        if [ "${owner%%|*}" = "$BATCH_HOST" ] && [[ "${owner#*|}" =~ ^[0-9]+$ ]] \
+            && ps -p "${owner#*|}" -o args= 2>/dev/null | grep -q "run\.sh"; then
+            echo "$plan"
+        fi
+    done
+}
+
+# Same format run.sh reads: one region per line, '#' comments allowed
+read_batch_queue() {
+    local line
+    BATCH_QUEUE=()
+    if [ ! -f "$BATCH_QUEUE_FILE" ]; then
+        return 0
+    fi
+    while IFS= read -r line || [ -n "$line" ]; do
+        line="${line%%#*}"
+        line="${line//[[:space:]]/}"
+        if [ -n "$line" ]; then
+            BATCH_QUEUE+=("$line")
+        fi
+    done < "$BATCH_QUEUE_FILE"
+}
+
+write_batch_queue() {
+    printf '%s\n' "$@" > "${BATCH_QUEUE_FILE}.tmp" && mv "${BATCH_QUEUE_FILE}.tmp" "$BATCH_QUEUE_FILE"
+}
+
+# The same lock run.sh holds while it takes the next region off the queue
+lock_batch_queue() {
+    exec 6>>"${BATCH_QUEUE_FILE}.lock"
+    command -v flock >/dev/null 2>&1 && flock 6
+}
+
+# Position of a region in the queue, empty if it is not queued
+queue_position() {
+    local region="$1"
+    local i
+    for i in "${!BATCH_QUEUE[@]}"; do
+        if [ "${BATCH_QUEUE[$i]}" = "$region" ]; then
+            echo "$i"
+            return
+        fi
+    done
+}
+
+require_queued() {
+    local region="$1"
+    if [ -z "$(queue_position "$region")" ]; then
+        echo "Error: '${region}' is not in the queue"
+        exit 1
+    fi
+}
+
+show_queue() {
+    local i
+    echo "📋 Batch queue"
+    if [ ${#BATCH_DONE[@]} -gt 0 ]; then
+        echo "   ✅ Finished: ${BATCH_DONE[*]}"
+    fi
+    if [ -n "$BATCH_CURRENT" ]; then
+        echo "   ⏳ Running:  ${BATCH_CURRENT}"
+    fi
+    if [ ${#BATCH_QUEUE[@]} -eq 0 ]; then
+        echo "   (nothing queued)"
+    fi
+    for i in "${!BATCH_QUEUE[@]}"; do
+        printf "   %2d. %s\n" "$((i + 1))" "${BATCH_QUEUE[$i]}"
+    done
+}
+
+BATCH_PID=""
+if [ "${1:-}" = "--batch" ]; then
+    BATCH_PID="$2"
+    shift 2
+fi
+
+mapfile -t LIVE_PLANS < <(live_batch_plans)
+if [ -n "$BATCH_PID" ]; then
+    BATCH_PLAN_FILE="./cache/batch-plan.${BATCH_PID}"
+    if [[ ! " ${LIVE_PLANS[*]} " == *" ${BATCH_PLAN_FILE} "* ]]; then
+        echo "Error: No multi-region batch with PID ${BATCH_PID} is running."
+        exit 1
+    fi
+elif [ ${#LIVE_PLANS[@]} -eq 1 ]; then
+    BATCH_PLAN_FILE="${LIVE_PLANS[0]}"
+elif [ ${#LIVE_PLANS[@]} -gt 1 ]; then
+    echo "Several batches are running - pick one with --batch <pid>:"
+    for plan in "${LIVE_PLANS[@]}"; do
+        current=$(plan_values "$plan" current)
+        echo "   ${plan##*.}  ${current:-starting}"
+    done
+    exit 1
+fi
+
+BATCH_QUEUE_FILE="./cache/batch-queue.${BATCH_PLAN_FILE##*.}"
+if [ -z "${BATCH_PLAN_FILE:-}" ] || [ ! -f "$BATCH_QUEUE_FILE" ]; then
+    echo "No multi-region batch is running."
+    exit 1
+fi
+
+mapfile -t BATCH_DONE < <(plan_values "$BATCH_PLAN_FILE" done)
+BATCH_CURRENT=$(plan_values "$BATCH_PLAN_FILE" current)
+BATCH_CONTAINER=$(plan_values "$BATCH_PLAN_FILE" container)
+# Held until the edited queue is written back (released on exit)
+lock_batch_queue
+read_batch_queue
+
+COMMAND="${1:-list}"
+shift
+case "$COMMAND" in
+    list)
+        show_queue
+        exit 0
+        ;;
+    add)
+        if [ $# -eq 0 ]; then
+            echo "$USAGE"
+            exit 1
+        fi
+        for region in "$@"; do
+            if [ -n "$(queue_position "$region")" ] || [ "$region" = "$BATCH_CURRENT" ]; then
+                echo "⚠️  ${region} is already in the batch"
+            else
+                BATCH_QUEUE+=("$region")
+            fi
+        done
+        ;;
+    remove)
+        if [ $# -eq 0 ]; then
+            echo "$USAGE"
+            exit 1
+        fi
+        for region in "$@"; do
+            require_queued "$region"
+            pos=$(queue_position "$region")
+            BATCH_QUEUE=("${BATCH_QUEUE[@]:0:$pos}" "${BATCH_QUEUE[@]:$((pos + 1))}")
+        done
+        ;;
+    first|up|down)
+        if [ $# -ne 1 ]; then
+            echo "$USAGE"
+            exit 1
+        fi
+        require_queued "$1"
+        pos=$(queue_position "$1")
+        case "$COMMAND" in
+            first) target=0 ;;
+            up) target=$(( pos > 0 ? pos - 1 : 0 )) ;;
+            down) target=$(( pos < ${#BATCH_QUEUE[@]} - 1 ? pos + 1 : pos )) ;;
+        esac
+        rest=("${BATCH_QUEUE[@]:0:$pos}" "${BATCH_QUEUE[@]:$((pos + 1))}")
+        BATCH_QUEUE=("${rest[@]:0:$target}" "$1" "${rest[@]:$target}")
+        ;;
+    skip)
+        if [ -z "$BATCH_CURRENT" ] || [ -z "$BATCH_CONTAINER" ]; then
+            echo "Error: No region is being processed right now"
+            exit 1
+        fi
+        # generate-data.sh cleans up the partial graph on SIGTERM; the marker
+        # tells run.sh to record the region as skipped, not failed, and start
+        # the next one
+        exec 6>&-
+        echo "⏭️  Skipping ${BATCH_CURRENT}..."
+        touch "./cache/skip.${BATCH_CONTAINER}"
+        if ! docker stop -t 60 "$BATCH_CONTAINER" >/dev/null; then
+            rm -f "./cache/skip.${BATCH_CONTAINER}"
+            echo "❌ Error: Could not stop container ${BATCH_CONTAINER}"
+            exit 1
+        fi
+        exit 0
+        ;;
+    -h|--help)
+        echo "$USAGE"
+        exit 0
+        ;;
+    *)
+        echo "Error: Unknown command '${COMMAND}'"
+        echo "$USAGE"
+        exit 1
+        ;;
+esac
+
+write_batch_queue "${BATCH_QUEUE[@]}"
+show_queue
@@ -0,0 +1,170 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Region Names
+#
+# Description:
+# Sourced by the scripts that take region arguments (run.sh, airgap.sh).
+# resolve_region turns an index ID, alias (aliases.tsv), region name, ISO 3166
+# code (DE, US-NC) or US state abbreviation (NC) into its Geofabrik index ID.
+# The caller sets INDEX_CACHE_FILE and, to keep the index from being
+# downloaded, OFFLINE=true.
+# ==============================================================================
+
+ALIASES_FILE="$(dirname "$0")/aliases.tsv"
+# id<TAB>name<TAB>ISO 3166-1 codes<TAB>ISO 3166-2 codes<TAB>parent, one line
+# per region
+REGION_INDEX=""
+RESOLVED_REGION=""
+REGIONS_RESOLVED=false
+
+# "North Carolina" / north_carolina -> north-carolina
+normalize_region_name() {
+    echo "$1" | tr '[:upper:]' '[:lower:]' | sed 's/[[:space:]_]\{1,\}/-/g'
+}
+
+# Loads REGION_INDEX, downloading the index with list-regions.sh if needed.
+# Returns non-zero when jq or the index is unavailable.
+load_region_index() {
+    if [ -n "$REGION_INDEX" ]; then
+        return 0
+    fi
+    if ! command -v jq >/dev/null 2>&1; then
+        return 1
+    fi
+    if [ ! -s "$INDEX_CACHE_FILE" ] && [ "$OFFLINE" != "true" ]; then
+        echo "📡 Downloading the region index..."
+        ./list-regions.sh > /dev/null 2>&1
+    fi
+    if [ ! -s "$INDEX_CACHE_FILE" ]; then
+        return 1
+    fi
+    REGION_INDEX=$(jq -r '.features[].properties
+        | [.id, .name, ((.["iso3166-1:alpha2"] // []) | join(",")), ((.["iso3166-2"] // []) | join(",")), (.parent // "")]
+        | @tsv' "$INDEX_CACHE_FILE" | sort)
+}
+
+# Up to three index IDs closest to a mistyped region (edit distance on the
+# ID, its last path element or the region name)
+suggest_regions() {
+    local wanted="$1"
+    echo "$REGION_INDEX" | awk -F'\t' -v w="$wanted" '
+        function dist(a, b,    i, j, la, lb, d, cost, x) {
+            la = length(a); lb = length(b)
+            for (i = 0; i <= la; i++) d[i, 0] = i
+            for (j = 0; j <= lb; j++) d[0, j] = j
+            for (i = 1; i <= la; i++)
+                for (j = 1; j <= lb; j++) {
+                    cost = (substr(a, i, 1) == substr(b, j, 1)) ? 0 : 1
+                    x = d[i - 1, j] + 1
+                    if (d[i, j - 1] + 1 < x) x = d[i, j - 1] + 1
+                    if (d[i - 1, j - 1] + cost < x) x = d[i - 1, j - 1] + cost
+                    d[i, j] = x
+                }
+            return d[la, lb]
+        }
+        {
+            leaf = $1; sub(/.*\//, "", leaf)
+            name = tolower($2); gsub(/[ _]+/, "-", name)
+            best = dist(w, $1)
+            if ((x = dist(w, leaf)) < best) best = x
+            if ((x = dist(w, name)) < best) best = x
+            if (index(leaf, w) == 1 && length(w) >= 4) best = 1
+            limit = int(length(w) / 4); if (limit < 2) limit = 2
+            if (best <= limit) print best "\t" $1
+        }' | sort -n | head -n 3 | cut -f2
+}
+
+# First region (by ID) listing an ISO code in the given REGION_INDEX column:
+# 3 for ISO 3166-1, 4 for ISO 3166-2
+code_region() {
+    echo "$REGION_INDEX" | awk -F'\t' -v c="$1" -v col="$2" 'index("," $col ",", "," c ",") { print $1; exit }'
+}
+
+# A code that is both a country and a US state is asked about in a terminal
+# and refused otherwise: building the wrong one costs gigabytes and hours
+choose_country_or_state() {
+    local region="$1"
+    local country="$2"
+    local state="$3"
+    local answer=""
+    echo "❓ ${region} is both a country and a US state:"
+    echo "   1) ${country}"
+    echo "   2) ${state}"
+    if [ -t 0 ]; then
+        read_answer "Which one? [1/2] " answer || answer=""
+    fi
+    case "$answer" in
+        1) RESOLVED_REGION="$country" ;;
+        2) RESOLVED_REGION="$state" ;;
+        *)
+            echo "Error: Ambiguous region code: ${region}"
+            echo "Use the region ID instead, e.g. ./run.sh ${country} or ./run.sh ${state}"
+            exit 1
+            ;;
+    esac
+}
+
+# Sets RESOLVED_REGION to the index ID for a region argument; prints
+# did-you-mean suggestions and exits when nothing matches
+resolve_region() {
+    local region="$1"
+    local wanted
+    wanted=$(normalize_region_name "$region")
+    local code
+    code=$(echo "$region" | tr '[:lower:]' '[:upper:]')
+    RESOLVED_REGION=""
+
+    # Exact ID (case-insensitive)
+    if echo "$REGION_INDEX" | cut -f1 | grep -qxF "$wanted"; then
+        RESOLVED_REGION="$wanted"
+    fi
+
+    # Alias table
+    if [ -z "$RESOLVED_REGION" ] && [ -f "$ALIASES_FILE" ]; then
+        RESOLVED_REGION=$(awk -F'\t' -v w="$wanted" '!/^#/ && NF >= 2 {
+            a = tolower($1); gsub(/[ _]+/, "-", a)
+            if (a == w) { print $2; exit }
+        }' "$ALIASES_FILE")
+    fi
+
+    # Region name
+    if [ -z "$RESOLVED_REGION" ]; then
+        RESOLVED_REGION=$(echo "$REGION_INDEX" | awk -F'\t' -v w="$wanted" '
+            { n = tolower($2); gsub(/[ _]+/, "-", n) }
+            n == w { print $1; exit }')
+    fi
+
+    # ISO 3166-1 country code, ISO 3166-2 subdivision code or US state
+    # abbreviation, each the first match in index order (sorted by ID)
+    if [ -z "$RESOLVED_REGION" ]; then
+        local country subdivision state
+        country=$(code_region "$code" 3)
+        subdivision=$(code_region "$code" 4)
+        state=$(code_region "US-${code}" 4)
+        if [ -n "$country" ] && [ -n "$state" ] && [ "$country" != "$state" ]; then
+            # Two-letter codes can be a country and a US state (DE, NC, CA)
+            choose_country_or_state "$region" "$country" "$state"
+        else
+            RESOLVED_REGION="${country:-${subdivision:-${state}}}"
+        fi
+    fi
+
+    if [ -n "$RESOLVED_REGION" ]; then
+        if [ "$RESOLVED_REGION" != "$region" ]; then
+            echo "🔤 ${region} → ${RESOLVED_REGION}"
+            REGIONS_RESOLVED=true
+        fi
+        return
+    fi
+
+    echo "Error: Region not found: ${region}"
+    local suggestions
+    suggestions=$(suggest_regions "$wanted")
+    if [ -n "$suggestions" ]; then
+        echo "Did you mean:"
+        echo "$suggestions" | sed 's/^/   • /'
+    fi
+    echo "Run './list-regions.sh' to see all available regions"
+    exit 1
+}
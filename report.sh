@@ -0,0 +1,168 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Build Report
+#
+# Description:
+# Writes a Markdown or HTML report of built regions from ./cache/registry.json:
+# source data date, download size, import duration, graph size, output and
+# package sizes, checksums and warnings. Meant to be shared with the team
+# receiving the routing packages. run.sh writes one after every batch.
+#
+# Usage:
+# ./report.sh                              # all regions -> output/build-report.md
+# ./report.sh delaware malta               # only these regions
+# ./report.sh --output output/report.html  # HTML instead of Markdown
+# ./report.sh --output -                   # Markdown to stdout
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+
+REGISTRY_FILE="./cache/registry.json"
+REPORT_FILE="./output/build-report.md"
+FILTER=()
+USAGE="Usage: ./report.sh [--output <file.md|file.html|->] [region ...]"
+
+# jq helpers shared by both formats
+JQ_FORMATTERS='
+def size: if . == null then "-" else
+    [., 0] | until(.[0] < 1024 or .[1] == 4; [.[0] / 1024, .[1] + 1])
+    | "\(if .[1] == 0 then .[0] else (.[0] * 10 | round / 10) end) \(["B", "KB", "MB", "GB", "TB"][.[1]])"
+  end;
+def duration: if . == null then "-" elif . < 60 then "\(.)s"
+    elif . < 3600 then "\(. / 60 | floor)m \(. % 60)s"
+    else "\(. / 3600 | floor)h \(. % 3600 / 60 | floor)m" end;
+def count: if . == null then "-" else tostring end;
+def day: try (strptime("%a, %d %b %Y %H:%M:%S GMT") | strftime("%Y-%m-%d")) catch "custom area";
+def warnings: [
+    (if (.import_warnings // 0) > 0 then "\(.import_warnings) GraphHopper warnings (see output/logs/\(.region)/import.log)" else empty end),
+    (if (.anomalies // []) | length > 0 then "differs from previous build: \(.anomalies | join(", "))" else empty end),
+    (if .output_missing then "output folder deleted" else empty end)
+  ] | if length == 0 then "-" else join("; ") end;
+'
+
+markdown_report() {
+    local entries="$1"
+    echo "# VNS Routing Data Build Report"
+    echo ""
+    echo "Generated $(date -u +"%Y-%m-%d %H:%M") UTC on $(hostname)."
+    echo ""
+    echo "| Region | Source data | Download | Import time | Graph nodes | Graph edges | Output | Package | Warnings |"
+    echo "|--------|-------------|----------|-------------|-------------|-------------|--------|---------|----------|"
+    echo "$entries" | jq -r "${JQ_FORMATTERS}"'
+        "| \(.region_id) | \(.source_date | day) | \(.osm_bytes | size) | \(.import_seconds | duration) | \(.graph_nodes | count) | \(.graph_edges | count) | \(.size_bytes | size) | \(.package_bytes | if . == 0 then "-" else size end) | \(warnings) |"'
+    echo ""
+    echo "## Checksums (SHA-256)"
+    echo ""
+    echo "| Package | SHA-256 |"
+    echo "|---------|---------|"
+    echo "$entries" | jq -r 'select(.package_sha256 != null) | "| \(.package | sub("^output/"; "")) | `\(.package_sha256)` |"'
+    echo ""
+    echo "Built with GraphHopper $(echo "$entries" | jq -rs 'map(.graphhopper_version) | unique | join(", ")'). Verify a transferred package with \`sha256sum -c <package>.sha256\`."
+}
+
+html_report() {
+    local entries="$1"
+    cat <<EOF
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>VNS Routing Data Build Report</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; color: #222; }
+  table { border-collapse: collapse; margin-bottom: 2em; }
+  th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+  th { background: #f0f0f0; }
+  td.num { text-align: right; }
+  code { font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>VNS Routing Data Build Report</h1>
+<p>Generated $(date -u +"%Y-%m-%d %H:%M") UTC on $(hostname).</p>
+<table>
+<tr><th>Region</th><th>Source data</th><th>Download</th><th>Import time</th><th>Graph nodes</th><th>Graph edges</th><th>Output</th><th>Package</th><th>Warnings</th></tr>
+EOF
+    echo "$entries" | jq -r "${JQ_FORMATTERS}"'
+        "<tr><td>\(.region_id | @html)</td><td>\(.source_date | day)</td><td class=\"num\">\(.osm_bytes | size)</td><td class=\"num\">\(.import_seconds | duration)</td><td class=\"num\">\(.graph_nodes | count)</td><td class=\"num\">\(.graph_edges | count)</td><td class=\"num\">\(.size_bytes | size)</td><td class=\"num\">\(.package_bytes | if . == 0 then "-" else size end)</td><td>\(warnings | @html)</td></tr>"'
+    echo "</table>"
+    echo "<h2>Checksums (SHA-256)</h2>"
+    echo "<table>"
+    echo "<tr><th>Package</th><th>SHA-256</th></tr>"
+    echo "$entries" | jq -r 'select(.package_sha256 != null) | "<tr><td>\(.package | sub("^output/"; "") | @html)</td><td><code>\(.package_sha256)</code></td></tr>"'
+    echo "</table>"
+    echo "</body>"
+    echo "</html>"
+}
+
+main() {
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --output)
+                REPORT_FILE="$2"
+                shift
+                ;;
+            --output=*)
+                REPORT_FILE="${1#*=}"
+                ;;
+            -h|--help)
+                echo "$USAGE"
+                exit 0
+                ;;
+            -*)
+                echo "Error: Unknown option '$1'"
+                echo "$USAGE"
+                exit 1
+                ;;
+            *) FILTER+=("$(basename "$1")") ;;
+        esac
+        shift
+    done
+
+    if ! command -v jq >/dev/null 2>&1; then
+        echo "❌ Error: jq is required but not installed (see ./list-regions.sh for install hints)"
+        exit 1
+    fi
+    if [ ! -s "$REGISTRY_FILE" ]; then
+        echo "📭 No builds recorded yet - build a region with ./run.sh <region>"
+        exit 1
+    fi
+
+    local filter_json
+    filter_json=$(printf '%s\n' "${FILTER[@]}" | jq -R . | jq -sc 'map(select(. != ""))')
+    local entries
+    entries=$(jq -c --argjson only "$filter_json" \
+        'to_entries | map(.value) | map(select(($only | length) == 0 or (.region | IN($only[])))) | sort_by(.region) | .[]' \
+        "$REGISTRY_FILE")
+    if [ -z "$entries" ]; then
+        echo "❌ Error: None of the requested regions have been built"
+        exit 1
+    fi
+
+    # Flag regions whose output has since been deleted
+    local entry
+    local checked=""
+    while IFS= read -r entry; do
+        if [ -e "./$(echo "$entry" | jq -r '.output_path // .package')" ]; then
+            checked+="${entry}"$'\n'
+        else
+            checked+="$(echo "$entry" | jq -c '.output_missing = true')"$'\n'
+        fi
+    done <<< "$entries"
+
+    if [ "$REPORT_FILE" = "-" ]; then
+        markdown_report "$checked"
+        exit 0
+    fi
+
+    mkdir -p "$(dirname "$REPORT_FILE")"
+    case "$REPORT_FILE" in
+        *.html|*.htm) html_report "$checked" > "${REPORT_FILE}.tmp" ;;
+        *)            markdown_report "$checked" > "${REPORT_FILE}.tmp" ;;
+    esac
+    mv "${REPORT_FILE}.tmp" "$REPORT_FILE"
+    echo "📋 Build report written: ${REPORT_FILE}"
+}
+
+main "$@"
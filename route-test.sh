@@ -0,0 +1,140 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Route Test Server
+#
+# Description:
+# Serves a built region with GraphHopper's own web server and map UI, so you
+# can click two points and check the routes before pushing the package to
+# devices. The graph is copied inside the container, so the output folder
+# that goes to devices is mounted read-only and never modified.
+#
+# Usage:
+# ./route-test.sh us/delaware                 # http://localhost:8989/maps/
+# ./route-test.sh delaware --port 9000        # another port
+# ./route-test.sh delaware --no-browser       # only print the URL
+# Stop the server with Ctrl+C.
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+[ -f "$(dirname "$0")/desktop-open.sh" ] && . "$(dirname "$0")/desktop-open.sh"
+
+REGISTRY_IMAGE="ghcr.io/joshuafuller/atak-vns-offline-routing-generator:latest"
+LOCAL_IMAGE="vns-data-generator:latest"
+PORT=8989
+OPEN_BROWSER=true
+
+usage() {
+    echo "Usage: ./route-test.sh <region> [--port <port>] [--no-browser]"
+}
+
+# Wait for GraphHopper to finish loading the graph, then open the map
+open_when_ready() {
+    local url="$1"
+    local attempt
+    for attempt in $(seq 1 150); do
+        if curl -sf -o /dev/null "http://localhost:${PORT}/info" 2>/dev/null \
+            || wget -q -O /dev/null "http://localhost:${PORT}/info" 2>/dev/null; then
+            echo ""
+            echo "🧭 Route test server ready: ${url}"
+            echo "   Click two points on the map to route between them. Ctrl+C stops the server."
+            if [ "$OPEN_BROWSER" = "true" ]; then
+                open_browser "$url"
+            fi
+            return 0
+        fi
+        sleep 2
+    done
+    echo "⚠️  The server did not answer within 5 minutes - check the output above"
+}
+
+main() {
+    local region=""
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --port)
+                PORT="$2"
+                shift
+                ;;
+            --port=*)
+                PORT="${1#*=}"
+                ;;
+            --no-browser)
+                OPEN_BROWSER=false
+                ;;
+            -h|--help)
+                usage
+                exit 0
+                ;;
+            -*)
+                echo "Error: Unknown option '$1'"
+                usage
+                exit 1
+                ;;
+            *)
+                region="$1"
+                ;;
+        esac
+        shift
+    done
+
+    if [ -z "$region" ]; then
+        usage
+        exit 1
+    fi
+    if ! [[ "$PORT" =~ ^[0-9]+$ ]]; then
+        echo "Error: Invalid port '$PORT'"
+        exit 1
+    fi
+
+    local name
+    name=$(basename "$region")
+    if [ ! -f "./output/${name}/properties" ]; then
+        echo "❌ Error: No built graph in ./output/${name}"
+        echo "Build it first with: ./run.sh ${region} --format dir   (or any format)"
+        exit 1
+    fi
+
+    local image
+    if docker image inspect "$REGISTRY_IMAGE" >/dev/null 2>&1; then
+        image="$REGISTRY_IMAGE"
+    elif docker image inspect "$LOCAL_IMAGE" >/dev/null 2>&1; then
+        image="$LOCAL_IMAGE"
+    else
+        echo "❌ Error: No Docker image found - run ./run.sh once to get it"
+        exit 1
+    fi
+
+    # The graph is loaded into the heap; leave room for the server itself
+    local graph_mb
+    graph_mb=$(du -sm "./output/${name}" | cut -f1)
+    local heap_mb=$(( graph_mb * 3 / 2 + 512 ))
+
+    local url="http://localhost:${PORT}/maps/"
+    echo "🧭 Starting GraphHopper for ${name} (${graph_mb}MB graph, ${heap_mb}MB heap)..."
+    open_when_ready "$url" &
+    local waiter_pid=$!
+
+    # Listen on localhost only; the container is removed when it stops
+    # The simple server type mounts the app under /application unless the
+    # context path is set, and /info and /maps/ would 404
+    docker run --rm --init \
+        -p "127.0.0.1:${PORT}:8989" \
+        -v "$(pwd)/output:/app/output:ro" \
+        "$image" bash -c 'cp -r "/app/output/$0" /tmp/graph && exec java "-Xmx$1m" \
+            -Ddw.graphhopper.graph.location=/tmp/graph \
+            -Ddw.server.applicationContextPath=/ \
+            -jar graphhopper/graphhopper-web-1.0.jar server graphhopper/config-example.yml' \
+        "$name" "$heap_mb"
+    local status=$?
+
+    kill "$waiter_pid" 2>/dev/null
+    wait "$waiter_pid" 2>/dev/null
+    echo "🛑 Route test server stopped"
+    # 130: stopped with Ctrl+C
+    if [ "$status" -ne 0 ] && [ "$status" -ne 130 ]; then
+        exit "$status"
+    fi
+}
+
+main "$@"
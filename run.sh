@@ -8,11 +8,29 @@
 # Docker image and running the data generation process within a container.
 #
 # Usage:
-# ./run.sh <geofabrik-path>
+# ./run.sh <geofabrik-path> [<geofabrik-path> ...] [options]
 # e.g., ./run.sh us/delaware
-# e.g., ./run.sh europe/germany
+# e.g., ./run.sh europe/germany --format tar.gz
+# e.g., ./run.sh fort-liberty --bbox -79.35,35.05,-78.90,35.25
+# e.g., ./run.sh us/delaware us/maryland us/virginia --bundle mid-atlantic.zip
+#
+# e.g., ./run.sh --regions-file regions.txt      (or: cat regions.txt | ./run.sh -)
+# e.g., ./run.sh 'us/*' --exclude us/alaska,us/hawaii
+# e.g., ./run.sh us/delaware --open              (open ./output when done)
+# e.g., ./run.sh --benchmark                     (calibrate time estimates)
+# e.g., ./run.sh us-south --auto-split           (build its states if it runs out of memory)
+#
+# Several regions are processed one after another. --bundle <name.zip|name.tar.gz>
+# additionally packages all of them into one archive for deployment. An
+# interrupted batch can be picked up again with --resume. Other options are
+# passed straight through to generate-data.sh. VNS_* environment variables
+# (e.g. VNS_MEMORY_GB, VNS_FORMAT) are forwarded into the container.
 # ==============================================================================
 
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+[ -f "$(dirname "$0")/http-options.sh" ] && . "$(dirname "$0")/http-options.sh"
+[ -f "$(dirname "$0")/region-names.sh" ] && . "$(dirname "$0")/region-names.sh"
+
 # --- Configuration ---
 # Use pre-built image from GitHub Container Registry by default
 USE_PREBUILT=${USE_PREBUILT:-true}
@@ -21,18 +39,576 @@ REGISTRY_IMAGE="ghcr.io/joshuafuller/atak-vns-offline-routing-generator:latest"
 LOCAL_IMAGE_NAME="vns-data-generator"
 LOCAL_IMAGE_TAG="$VERSION"
 
+# Desktop notifications for long builds (set VNS_DESKTOP_NOTIFY=false to disable)
+DESKTOP_NOTIFY=${VNS_DESKTOP_NOTIFY:-true}
+NOTIFY_MIN_SECONDS=${VNS_NOTIFY_MIN_SECONDS:-60}
+# Push notifications to a phone through ntfy and/or Pushover; a bare ntfy
+# topic name is sent to VNS_NTFY_SERVER
+NTFY_TOPIC=${VNS_NTFY_TOPIC:-}
+NTFY_SERVER=${VNS_NTFY_SERVER:-https://ntfy.sh}
+PUSHOVER_TOKEN=${VNS_PUSHOVER_TOKEN:-}
+PUSHOVER_USER=${VNS_PUSHOVER_USER:-}
+# Open ./output in the file manager after a successful build (--open)
+OPEN_OUTPUT=${VNS_OPEN_OUTPUT:-false}
+
+# Show a native desktop notification: notify-send (Linux), osascript (macOS)
+# or a PowerShell toast (Windows Git Bash/WSL). Silently does nothing when no
+# notification mechanism is available, e.g. on a headless server.
+notify_desktop() {
+    local title="$1"
+    local message="$2"
+    if command -v notify-send >/dev/null 2>&1; then
+        notify-send "$title" "$message" 2>/dev/null
+    elif command -v osascript >/dev/null 2>&1; then
+        # Text is passed as script arguments so quotes cannot end the string
+        osascript -e 'on run argv' -e 'display notification (item 2 of argv) with title (item 1 of argv)' \
+            -e 'end run' "$title" "$message" 2>/dev/null
+    elif command -v powershell.exe >/dev/null 2>&1; then
+        # Text is passed through the environment to avoid quoting issues
+        VNS_NOTIFY_TITLE="$title" VNS_NOTIFY_BODY="$message" powershell.exe -NoProfile -Command '
+            [Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+            $xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+            $text = $xml.GetElementsByTagName("text")
+            $text.Item(0).AppendChild($xml.CreateTextNode($env:VNS_NOTIFY_TITLE)) > $null
+            $text.Item(1).AppendChild($xml.CreateTextNode($env:VNS_NOTIFY_BODY)) > $null
+            $appId = "{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe"
+            [Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($appId).Show([Windows.UI.Notifications.ToastNotification]::new($xml))
+        ' >/dev/null 2>&1
+    fi
+    return 0
+}
+
+# Send a push notification to ntfy and/or Pushover, whichever is configured.
+# A service that cannot be reached only prints a warning, and --offline sends
+# nothing. The topic is not printed: on ntfy.sh anyone who knows it can read
+# the messages.
+notify_push() {
+    local title="$1"
+    local message="$2"
+    local urgent="$3"
+    if [ -z "$NTFY_TOPIC" ] && { [ -z "$PUSHOVER_TOKEN" ] || [ -z "$PUSHOVER_USER" ]; }; then
+        return 0
+    fi
+    if [ "$OFFLINE" = "true" ]; then
+        return 0
+    fi
+    if ! command -v curl >/dev/null 2>&1; then
+        echo "⚠️  curl is not installed - push notification not sent"
+        return 0
+    fi
+
+    if [ -n "$NTFY_TOPIC" ]; then
+        local ntfy_url="$NTFY_TOPIC"
+        if [[ "$ntfy_url" != http://* ]] && [[ "$ntfy_url" != https://* ]]; then
+            ntfy_url="${NTFY_SERVER%/}/${NTFY_TOPIC}"
+        fi
+        local priority="default"
+        if [ "$urgent" = "true" ]; then
+            priority="high"
+        fi
+        if ! curl -fsS --max-time 15 "${CURL_OPTS[@]}" -H "Title: ${title}" -H "Priority: ${priority}" \
+            --data-binary "$message" "$ntfy_url" >/dev/null 2>&1; then
+            echo "⚠️  ntfy push notification failed"
+        fi
+    fi
+
+    if [ -n "$PUSHOVER_TOKEN" ] && [ -n "$PUSHOVER_USER" ]; then
+        local priority=0
+        if [ "$urgent" = "true" ]; then
+            priority=1
+        fi
+        if ! curl -fsS --max-time 15 "${CURL_OPTS[@]}" \
+            --form-string "token=${PUSHOVER_TOKEN}" --form-string "user=${PUSHOVER_USER}" \
+            --form-string "title=${title}" --form-string "message=${message}" \
+            --form-string "priority=${priority}" \
+            https://api.pushover.net/1/messages.json >/dev/null 2>&1; then
+            echo "⚠️  Pushover push notification failed"
+        fi
+    fi
+}
+
+# Notify about a finished build - only for builds long enough that the user
+# has probably switched away from the terminal
+notify_build_finished() {
+    local status="$1"
+    local elapsed=$(( $(date +%s) - BUILD_START_TIME ))
+    if [ "$elapsed" -lt "$NOTIFY_MIN_SECONDS" ]; then
+        return 0
+    fi
+    local title
+    local message
+    local urgent=false
+    if [ "$status" = "success" ]; then
+        title="VNS routing data ready"
+        message="${REGION_SUMMARY} finished in $(( elapsed / 60 )) min"
+    else
+        title="VNS build failed"
+        urgent=true
+        if [ "$BATCH_MODE" = "true" ] && [ ${#FAILED_REGIONS[@]} -gt 0 ]; then
+            message="${#FAILED_REGIONS[@]} of ${REGION_SUMMARY} failed after $(( elapsed / 60 )) min: ${FAILED_REGIONS[*]}"
+        else
+            message="${REGION_SUMMARY} failed after $(( elapsed / 60 )) min - check the terminal"
+        fi
+    fi
+    if [ "$DESKTOP_NOTIFY" = "true" ]; then
+        notify_desktop "$title" "$message"
+    fi
+    notify_push "$title" "$message" "$urgent"
+}
+
+# Show a folder in the desktop file manager: Explorer (WSL/Git Bash), xdg-open
+# (Linux) or Finder (macOS). Fails when there is none, e.g. over SSH.
+open_folder() {
+    local folder="$1"
+    if command -v explorer.exe >/dev/null 2>&1; then
+        if command -v wslpath >/dev/null 2>&1; then
+            folder=$(wslpath -w "$folder")
+        elif command -v cygpath >/dev/null 2>&1; then
+            folder=$(cygpath -w "$folder")
+        fi
+        # explorer.exe exits with 1 even when it opened the folder
+        explorer.exe "$folder" >/dev/null 2>&1
+        return 0
+    elif command -v xdg-open >/dev/null 2>&1 && [ -n "${DISPLAY:-}${WAYLAND_DISPLAY:-}" ]; then
+        xdg-open "$folder" >/dev/null 2>&1
+    elif [ "$(uname -s)" = "Darwin" ] && command -v open >/dev/null 2>&1; then
+        # Only macOS: on Linux 'open' is openvt and would start a console
+        open "$folder" >/dev/null 2>&1
+    else
+        return 1
+    fi
+}
+
 # --- Script Logic ---
 
+# Region IDs from a list file or stdin: one per line, '#' starts a comment
+read_region_list() {
+    local line
+    while IFS= read -r line || [ -n "$line" ]; do
+        line="${line%%#*}"
+        line="${line//[[:space:]]/}"
+        if [ -n "$line" ]; then
+            REGION_PATHS+=("$line")
+        fi
+    done
+}
+
+# Region paths come first ("-" reads them from stdin); everything from the
+# first option on is passed through to generate-data.sh, except --bundle,
+# --exclude, --regions-file, --resume and --open which are handled here.
+REGION_PATHS=()
+while [ $# -gt 0 ] && { [[ "$1" != -* ]] || [ "$1" = "-" ]; }; do
+    if [ "$1" = "-" ]; then
+        read_region_list
+    else
+        REGION_PATHS+=("$1")
+    fi
+    shift
+done
+
+BUNDLE_NAME=""
+GENERATE_ARGS=()
+RESUME_BATCH=false
+BENCHMARK=false
+AUTO_SPLIT=${VNS_AUTO_SPLIT:-false}
+EXCLUDE_PATTERNS=()
+while [ $# -gt 0 ]; do
+    case "$1" in
+        --resume)
+            RESUME_BATCH=true
+            ;;
+        --benchmark)
+            BENCHMARK=true
+            ;;
+        --auto-split)
+            AUTO_SPLIT=true
+            ;;
+        --open)
+            OPEN_OUTPUT=true
+            ;;
+        --bundle)
+            BUNDLE_NAME="$2"
+            shift
+            ;;
+        --bundle=*)
+            BUNDLE_NAME="${1#*=}"
+            ;;
+        --exclude|--exclude=*)
+            if [ "$1" = "--exclude" ]; then
+                IFS=',' read -r -a patterns <<< "$2"
+                shift
+            else
+                IFS=',' read -r -a patterns <<< "${1#*=}"
+            fi
+            EXCLUDE_PATTERNS+=("${patterns[@]}")
+            ;;
+        --regions-file|--regions-file=*)
+            if [ "$1" = "--regions-file" ]; then
+                REGIONS_FILE="$2"
+                shift
+            else
+                REGIONS_FILE="${1#*=}"
+            fi
+            if [ ! -r "$REGIONS_FILE" ]; then
+                echo "Error: Cannot read regions file '${REGIONS_FILE}'"
+                exit 1
+            fi
+            read_region_list < "$REGIONS_FILE"
+            ;;
+        *)
+            GENERATE_ARGS+=("$1")
+            ;;
+    esac
+    shift
+done
+
+# --- Benchmark ---
+# --benchmark builds nothing: it times the import of a small extract whose
+# import time on the reference system is known, and generate-data.sh stores
+# the resulting performance factor in ./cache/benchmark.json
+BENCHMARK_REGION="us/delaware"
+if [ "$BENCHMARK" = "true" ]; then
+    if [ ${#REGION_PATHS[@]} -gt 0 ] || [ -n "$BUNDLE_NAME" ] || [ "$RESUME_BATCH" = "true" ]; then
+        echo "Error: --benchmark takes no regions, --bundle or --resume"
+        exit 1
+    fi
+    REGION_PATHS=("$BENCHMARK_REGION")
+    GENERATE_ARGS+=(--benchmark)
+fi
+
+# --- Batch Resume ---
+# A multi-region batch records its plan and finished regions in
+# ./cache/batch-plan.<pid>, so a batch killed by a power loss or dropped SSH
+# session can continue where it stopped. The plan names the run.sh process
+# that owns it: plans of batches still running are never offered or removed,
+# and a resume is only offered when no regions were given.
+BATCH_PLAN_FILE="./cache/batch-plan.$$"
+BATCH_DONE=()
+BATCH_CURRENT=""
+BATCH_CONTAINER=""
+BATCH_OWNER_PID=$$
+BATCH_OWNER_HOST=$(uname -n)
+
+# The plan is plain "<key> <value>" lines, one per array element, and is
+# parsed rather than sourced: ./cache is writable from the build container.
+save_batch_plan() {
+    local value
+    {
+        echo "owner_pid ${BATCH_OWNER_PID}"
+        echo "owner_host ${BATCH_OWNER_HOST}"
+        echo "bundle ${BUNDLE_NAME}"
+        echo "current ${BATCH_CURRENT}"
+        echo "container ${BATCH_CONTAINER}"
+        for value in "${REGION_PATHS[@]}"; do
+            echo "region ${value}"
+        done
+        for value in "${GENERATE_ARGS[@]}"; do
+            echo "arg ${value}"
+        done
+        for value in "${BATCH_DONE[@]}"; do
+            echo "done ${value}"
+        done
+    } > "${BATCH_PLAN_FILE}.tmp" && mv "${BATCH_PLAN_FILE}.tmp" "$BATCH_PLAN_FILE"
+}
+
+# Read a plan written by save_batch_plan into the batch variables. Fails when
+# the file is missing or lists no regions.
+load_batch_plan() {
+    local plan="$1"
+    local line
+    local value
+    REGION_PATHS=()
+    GENERATE_ARGS=()
+    BATCH_DONE=()
+    BUNDLE_NAME=""
+    BATCH_CURRENT=""
+    BATCH_CONTAINER=""
+    BATCH_OWNER_PID=""
+    BATCH_OWNER_HOST=""
+    [ -f "$plan" ] || return 1
+    while IFS= read -r line || [ -n "$line" ]; do
+        value="${line#* }"
+        case "${line%% *}" in
+            owner_pid) [[ "$value" =~ ^[0-9]+$ ]] && BATCH_OWNER_PID="$value" ;;
+            owner_host) BATCH_OWNER_HOST="$value" ;;
+            bundle) BUNDLE_NAME="$value" ;;
+            current) BATCH_CURRENT="$value" ;;
+            container) BATCH_CONTAINER="$value" ;;
+            region) REGION_PATHS+=("$value") ;;
+            arg) GENERATE_ARGS+=("$value") ;;
+            done) BATCH_DONE+=("$value") ;;
+        esac
+    done < "$plan"
+    [ ${#REGION_PATHS[@]} -gt 0 ]
+}
+
+# Whether the run.sh that wrote a plan is still running. A plan from another
+# machine (shared project folder) cannot be checked and counts as running.
+batch_plan_live() {
+    local owner
+    owner=$(
+        load_batch_plan "$1" >/dev/null 2>&1
+        echo "${BATCH_OWNER_HOST}|${BATCH_OWNER_PID}"
+    )
+    local host="${owner%%|*}"
+    local pid="${owner#*|}"
+    if [ -z "$pid" ]; then
+        return 1
+    fi
+    if [ "$host" != "$BATCH_OWNER_HOST" ]; then
+        return 0
+    fi
+    ps -p "$pid" -o args= 2>/dev/null | grep -q "run\.sh"
+}
+
+region_done() {
+    local region_path="$1"
+    local done_path
+    for done_path in "${BATCH_DONE[@]}"; do
+        if [ "$done_path" = "$region_path" ]; then
+            echo "true"
+            return
+        fi
+    done
+    echo "false"
+}
+
+if [ "$RESUME_BATCH" = "true" ] && [ ${#REGION_PATHS[@]} -gt 0 ]; then
+    echo "Error: --resume continues the interrupted batch and takes no regions"
+    exit 1
+fi
+
+# The most recent plan whose batch is no longer running
+RESUME_PLAN=""
+if [ ${#REGION_PATHS[@]} -eq 0 ] && [ "$BENCHMARK" != "true" ]; then
+    for plan in ./cache/batch-plan.*; do
+        case "$plan" in
+            *.tmp) continue ;;
+        esac
+        if [ ! -f "$plan" ] || batch_plan_live "$plan"; then
+            continue
+        fi
+        if [ -z "$RESUME_PLAN" ] || [ "$plan" -nt "$RESUME_PLAN" ]; then
+            RESUME_PLAN="$plan"
+        fi
+    done
+fi
+
+if [ -n "$RESUME_PLAN" ]; then
+    # Read the plan in a subshell first so a declined resume leaves our
+    # own arguments untouched
+    PLAN_SUMMARY=$(
+        load_batch_plan "$RESUME_PLAN" || exit 1
+        echo "$(( ${#REGION_PATHS[@]} - ${#BATCH_DONE[@]} )) of ${#REGION_PATHS[@]}"
+    ) || PLAN_SUMMARY=""
+
+    if [ -z "$PLAN_SUMMARY" ]; then
+        echo "⚠️  Ignoring unreadable batch plan ${RESUME_PLAN}"
+        rm -f "$RESUME_PLAN"
+    elif [ "$RESUME_BATCH" != "true" ] && [ -t 0 ]; then
+        read_answer "Resume previous batch (${PLAN_SUMMARY} regions remaining)? [Y/n] " answer
+        case "$answer" in
+            [nN]*)
+                rm -f "$RESUME_PLAN" "./cache/batch-queue.${RESUME_PLAN##*.}" "./cache/batch-queue.${RESUME_PLAN##*.}.lock"
+                ;;
+            *)
+                RESUME_BATCH=true
+                ;;
+        esac
+    fi
+
+    if [ "$RESUME_BATCH" = "true" ] && [ -f "$RESUME_PLAN" ]; then
+        # Take the plan over: it now belongs to this run, which builds a
+        # fresh queue from it
+        mv "$RESUME_PLAN" "$BATCH_PLAN_FILE"
+        rm -f "./cache/batch-queue.${RESUME_PLAN##*.}" "./cache/batch-queue.${RESUME_PLAN##*.}.lock"
+        load_batch_plan "$BATCH_PLAN_FILE"
+        BATCH_OWNER_PID=$$
+        BATCH_OWNER_HOST=$(uname -n)
+        echo "🔄 Resuming previous batch: ${PLAN_SUMMARY} regions remaining"
+    fi
+elif [ "$RESUME_BATCH" = "true" ]; then
+    echo "Error: No interrupted batch to resume."
+    exit 1
+fi
+
 # Check if a region path was provided as an argument
-if [ -z "$1" ]; then
+if [ ${#REGION_PATHS[@]} -eq 0 ]; then
     echo "Error: No region path provided."
-    echo "Usage: ./run.sh <geofabrik-path>"
+    echo "Usage: ./run.sh <geofabrik-path> [<geofabrik-path> ...] [--format zip|tar.gz|dir] [--bundle <name.zip>]"
+    echo "       ./run.sh --regions-file <file> [options]   (or: ./run.sh - < file)"
+    echo "       ./run.sh --resume"
     echo "Example: ./run.sh us/delaware"
     exit 1
 fi
 
-REGION_PATH=$1
-REGION_NAME=$(basename "$REGION_PATH")
+# --- Region Patterns and Aliases ---
+# Region arguments may be shell-style patterns ('us/*', 'germany/*') that are
+# expanded against the cached Geofabrik index, and --exclude drops matching
+# regions again, e.g. ./run.sh 'us/*' --exclude us/alaska,us/hawaii
+# Names that are not index IDs are looked up as aliases (aliases.tsv), region
+# names, ISO 3166 codes (DE, US-NC) and US state abbreviations (NC).
+# resolve_region and the index it reads live in region-names.sh.
+INDEX_CACHE_FILE="./cache/geofabrik-index.json"
+OFFLINE="${VNS_OFFLINE:-false}"
+
+is_region_pattern() {
+    case "$1" in
+        *[*?[]*) echo "true" ;;
+        *) echo "false" ;;
+    esac
+}
+
+# Geofabrik file names end in -latest.osm.pbf; the index IDs do not
+normalize_region_pattern() {
+    local pattern="$1"
+    pattern="${pattern%.osm.pbf}"
+    pattern="${pattern%-latest}"
+    echo "$pattern"
+}
+
+# Exclusions match the full path or just the region name (alaska = us/alaska)
+region_excluded() {
+    local region_path="$1"
+    local pattern
+    for pattern in "${EXCLUDE_PATTERNS[@]}"; do
+        pattern=$(normalize_region_pattern "$pattern")
+        # shellcheck disable=SC2053
+        if [[ "$region_path" == $pattern ]] || [[ "$(basename "$region_path")" == $pattern ]]; then
+            echo "true"
+            return
+        fi
+    done
+    echo "false"
+}
+
+expand_region_patterns() {
+    local expanded=()
+    local region_path pattern id matches
+    for region_path in "${REGION_PATHS[@]}"; do
+        if [ "$(is_region_pattern "$region_path")" != "true" ]; then
+            if [ "$INDEX_AVAILABLE" = "true" ]; then
+                resolve_region "$region_path"
+                expanded+=("$RESOLVED_REGION")
+            else
+                expanded+=("$region_path")
+            fi
+            continue
+        fi
+        pattern=$(normalize_region_pattern "$region_path")
+        matches=0
+        # Most IDs carry no parent (bayern, not germany/bayern), so each is
+        # also matched as <parent>/<name>
+        while IFS=$'\t' read -r id parent_path; do
+            # shellcheck disable=SC2053
+            if [[ "$id" == $pattern ]] || { [ -n "$parent_path" ] && [[ "$parent_path" == $pattern ]]; }; then
+                expanded+=("$id")
+                matches=$((matches + 1))
+            fi
+        done <<< "$(echo "$REGION_INDEX" | awk -F'\t' '{
+            leaf = $1; sub(/.*\//, "", leaf)
+            print $1 "\t" ($5 == "" ? "" : $5 "/" leaf)
+        }')"
+        if [ "$matches" -eq 0 ]; then
+            echo "Error: No regions match '${region_path}'"
+            echo "Run './list-regions.sh' to see all available regions"
+            exit 1
+        fi
+        REGIONS_RESOLVED=true
+    done
+
+    # Drop excluded regions and duplicates from overlapping patterns
+    local seen=" "
+    REGION_PATHS=()
+    for region_path in "${expanded[@]}"; do
+        if [ "$(region_excluded "$region_path")" = "true" ]; then
+            REGIONS_RESOLVED=true
+            continue
+        fi
+        case "$seen" in
+            *" ${region_path} "*) continue ;;
+        esac
+        seen+="${region_path} "
+        REGION_PATHS+=("$region_path")
+    done
+}
+
+# Custom areas (--bbox/--poly) name their output freely; nothing to resolve.
+# --offline is also passed on to generate-data.sh.
+CUSTOM_AREA=false
+for arg in "${GENERATE_ARGS[@]}"; do
+    case "$arg" in
+        --bbox|--bbox=*|--poly|--poly=*) CUSTOM_AREA=true ;;
+        --offline) OFFLINE=true ;;
+    esac
+done
+
+if [ "$RESUME_BATCH" != "true" ] && [ "$CUSTOM_AREA" != "true" ]; then
+    PATTERNS_GIVEN=false
+    for region_path in "${REGION_PATHS[@]}"; do
+        if [ "$(is_region_pattern "$region_path")" = "true" ]; then
+            PATTERNS_GIVEN=true
+        fi
+    done
+
+    # Without jq or the index, plain region IDs go through unchecked and
+    # generate-data.sh reports unknown ones
+    INDEX_AVAILABLE=false
+    if load_region_index; then
+        INDEX_AVAILABLE=true
+    elif [ "$PATTERNS_GIVEN" = "true" ] || [ ${#EXCLUDE_PATTERNS[@]} -gt 0 ]; then
+        if ! command -v jq >/dev/null 2>&1; then
+            echo "❌ Error: Region patterns need jq (see ./list-regions.sh for install hints)"
+        else
+            echo "❌ Error: Could not download the region index - run ./list-regions.sh to see why"
+        fi
+        exit 1
+    fi
+
+    expand_region_patterns
+    if [ ${#REGION_PATHS[@]} -eq 0 ]; then
+        echo "Error: Every matching region was excluded."
+        exit 1
+    fi
+    if [ "$REGIONS_RESOLVED" = "true" ]; then
+        echo "🔎 ${#REGION_PATHS[@]} regions selected:"
+        printf '   • %s\n' "${REGION_PATHS[@]}"
+        if [ -t 0 ]; then
+            read_answer "Process these ${#REGION_PATHS[@]} regions? [Y/n] " answer
+            case "$answer" in
+                [nN]*)
+                    echo "Cancelled."
+                    exit 0
+                    ;;
+            esac
+        fi
+    fi
+fi
+
+case "$BUNDLE_NAME" in
+    ""|*.zip|*.tar.gz) ;;
+    *)
+        echo "Error: Bundle name must end in .zip or .tar.gz (got '${BUNDLE_NAME}')"
+        exit 1
+        ;;
+esac
+
+# Folder names and a short description of the regions, for the bundle,
+# the final folder tree and notifications
+set_region_names() {
+    local region_path
+    REGION_NAMES=()
+    for region_path in "${REGION_PATHS[@]}"; do
+        REGION_NAMES+=("$(basename "$region_path")")
+    done
+    if [ ${#REGION_NAMES[@]} -eq 1 ]; then
+        REGION_SUMMARY="${REGION_NAMES[0]}"
+    else
+        REGION_SUMMARY="${#REGION_NAMES[@]} regions"
+    fi
+}
+
+set_region_names
 
 # Create the output and cache directories on the host machine if they don't exist
 # Output: where the final data files will be placed
@@ -42,8 +618,28 @@ mkdir -p ./cache
 
 echo "--- VNS Offline Data Generator ---"
 
+# Offline, only an image already on this machine can be used and containers
+# get no network at all
+DOCKER_NETWORK_ARGS=()
+if [ "$OFFLINE" = "true" ]; then
+  DOCKER_NETWORK_ARGS=(--network none)
+  echo "📴 Offline mode: no downloads, containers run without network access"
+  if [ "$USE_PREBUILT" = "true" ] && docker image inspect "$REGISTRY_IMAGE" >/dev/null 2>&1; then
+    DOCKER_IMAGE="$REGISTRY_IMAGE"
+  elif docker image inspect "${LOCAL_IMAGE_NAME}:${LOCAL_IMAGE_TAG}" >/dev/null 2>&1; then
+    DOCKER_IMAGE="${LOCAL_IMAGE_NAME}:${LOCAL_IMAGE_TAG}"
+  else
+    echo "Error: Offline mode needs the Docker image on this machine, and it is not there."
+    echo "On a machine with internet access run:"
+    echo "  docker pull ${REGISTRY_IMAGE}"
+    echo "  docker save ${REGISTRY_IMAGE} | gzip > vns-image.tar.gz"
+    echo "then load it here with: docker load < vns-image.tar.gz"
+    exit 1
+  fi
+  echo "Using Docker image: $DOCKER_IMAGE"
+
 # Determine which Docker image to use
-if [ "$USE_PREBUILT" = "true" ]; then
+elif [ "$USE_PREBUILT" = "true" ]; then
   DOCKER_IMAGE="$REGISTRY_IMAGE"
   echo "Using pre-built Docker image: $DOCKER_IMAGE"
   
@@ -55,7 +651,7 @@ if [ "$USE_PREBUILT" = "true" ]; then
   fi
 fi
 
-if [ "$USE_PREBUILT" != "true" ]; then
+if [ "$OFFLINE" != "true" ] && [ "$USE_PREBUILT" != "true" ]; then
   DOCKER_IMAGE="${LOCAL_IMAGE_NAME}:${LOCAL_IMAGE_TAG}"
   
   # Check if the local Docker image exists
@@ -72,7 +668,7 @@ if [ "$USE_PREBUILT" != "true" ]; then
   fi
 fi
 
-echo "Starting data generation for: ${REGION_PATH}"
+echo "Starting data generation for: ${REGION_PATHS[*]}"
 echo "The process can take a very long time depending on the region's size."
 echo "Please be patient..."
 
@@ -85,17 +681,625 @@ echo "Please be patient..."
 # --rm: This flag automatically removes the container when it exits, keeping
 #   your system clean.
 
+# Forward VNS_* settings (and VERBOSE_LOG) into the container so that
+# e.g. "VNS_MEMORY_GB=16 ./run.sh us/california" reaches generate-data.sh.
+# VNS_JAVA only applies when generate-data.sh runs outside Docker, and the
+# push notification keys are only used here, so they stay out of the container.
+# VNS_ASCII does too: the container output already passes through this
+# script's filter, and generate-data.sh must stay the process docker stop
+# signals so its cancel cleanup runs.
+DOCKER_ENV_ARGS=()
+for var in $(compgen -e); do
+    case "$var" in
+        VNS_TEMP_DIR|VNS_HOOKS_DIR|VNS_SHARED_CACHE|VNS_MIRROR|VNS_CA_BUNDLE|VNS_JAVA|VNS_ASCII) ;;
+        VNS_NTFY_TOPIC|VNS_NTFY_SERVER|VNS_PUSHOVER_TOKEN|VNS_PUSHOVER_USER) ;;
+        VNS_*|VERBOSE_LOG) DOCKER_ENV_ARGS+=(-e "$var") ;;
+    esac
+done
+
+# VNS_TEMP_DIR is a host path: mount it and point the container at the mount
+if [ -n "$VNS_TEMP_DIR" ]; then
+    mkdir -p "$VNS_TEMP_DIR"
+    DOCKER_ENV_ARGS+=(-v "$(cd "$VNS_TEMP_DIR" && pwd):/app/temp" -e VNS_TEMP_DIR=/app/temp)
+    echo "Using temporary directory: ${VNS_TEMP_DIR}"
+fi
+
+# VNS_SHARED_CACHE is a host path too, usually an NFS or SMB mount shared by a
+# team. It is not created here: a missing folder means the share is not mounted.
+if [ -n "$VNS_SHARED_CACHE" ]; then
+    if [ ! -d "$VNS_SHARED_CACHE" ]; then
+        echo "❌ Error: Shared cache '${VNS_SHARED_CACHE}' not found - is the network share mounted?"
+        exit 1
+    fi
+    DOCKER_ENV_ARGS+=(-v "$(cd "$VNS_SHARED_CACHE" && pwd):/app/shared-cache" -e VNS_SHARED_CACHE=/app/shared-cache)
+    echo "Using shared download cache: ${VNS_SHARED_CACHE}"
+fi
+
+# VNS_CA_BUNDLE (see http-options.sh) is a host file: mount it read-only
+if [ -n "$VNS_CA_BUNDLE" ] && [ -f "$VNS_CA_BUNDLE" ]; then
+    DOCKER_ENV_ARGS+=(-v "$(cd "$(dirname "$VNS_CA_BUNDLE")" && pwd)/$(basename "$VNS_CA_BUNDLE"):/app/ca-bundle.pem:ro" \
+        -e VNS_CA_BUNDLE=/app/ca-bundle.pem)
+    echo "Using CA certificates from: ${VNS_CA_BUNDLE}"
+fi
+
+# Hook scripts live on the host (./hooks or VNS_HOOKS_DIR): mount them read-only
+HOOKS_HOST_DIR="${VNS_HOOKS_DIR:-./hooks}"
+if [ -d "$HOOKS_HOST_DIR" ]; then
+    DOCKER_ENV_ARGS+=(-v "$(cd "$HOOKS_HOST_DIR" && pwd):/app/hooks:ro" -e VNS_HOOKS_DIR=/app/hooks)
+    echo "Using hook scripts from: ${HOOKS_HOST_DIR}"
+fi
+
+# --- Download Server Check ---
+# Before the first container starts, Geofabrik and the mirrors listed in
+# VNS_MIRRORS (base URLs with Geofabrik's folder layout) each get one HEAD
+# request. The fastest server that answers is passed on as VNS_MIRROR; when
+# none answers, the run stops here instead of timing out in the middle of a
+# batch. Setting VNS_MIRROR uses that server without probing the others, and
+# VNS_NETWORK_CHECK=false skips the check.
+GEOFABRIK_URL="https://download.geofabrik.de/"
+NETWORK_CHECK=${VNS_NETWORK_CHECK:-true}
+
+# Prints the response time in milliseconds of a server that answers a HEAD
+# request, nothing for one that does not
+probe_server() {
+    local result
+    result=$(curl -sS -I -o /dev/null --max-time 10 "${CURL_OPTS[@]}" -w '%{http_code} %{time_total}' "$1" 2>/dev/null)
+    case "${result%% *}" in
+        2*|3*) awk -v seconds="${result#* }" 'BEGIN { printf "%d\n", seconds * 1000 }' ;;
+    esac
+}
+
+check_download_servers() {
+    if ! command -v curl >/dev/null 2>&1; then
+        echo "⚠️  curl is not installed - skipping the download server check"
+        return 0
+    fi
+    local servers=()
+    local mirror
+    if [ -n "$VNS_MIRROR" ]; then
+        servers=("$VNS_MIRROR")
+    else
+        servers=("$GEOFABRIK_URL")
+        for mirror in ${VNS_MIRRORS//,/ }; do
+            servers+=("$mirror")
+        done
+    fi
+
+    echo "📡 Checking download servers..."
+    local server
+    local latency
+    local best=""
+    local best_latency=""
+    for server in "${servers[@]}"; do
+        server="${server%/}/"
+        latency=$(probe_server "$server")
+        if [ -n "$latency" ]; then
+            printf "   ✅ %-45s %5s ms\n" "$server" "$latency"
+            if [ -z "$best" ] || [ "$latency" -lt "$best_latency" ]; then
+                best="$server"
+                best_latency="$latency"
+            fi
+        else
+            printf "   ❌ %-45s unreachable\n" "$server"
+        fi
+    done
+
+    if [ -z "$best" ]; then
+        echo "❌ Error: No connectivity to download servers"
+        echo "   Check the network connection, proxy and DNS - ./list-regions.sh prints detailed diagnostics."
+        echo "   Regions that are already cached can be built with --offline."
+        exit 1
+    fi
+    if [ "$best" != "$GEOFABRIK_URL" ]; then
+        echo "🌐 Downloading from mirror: ${best}"
+    fi
+    DOCKER_ENV_ARGS+=(-e "VNS_MIRROR=${best}")
+    MIRROR_CHOSEN=true
+}
+
+MIRROR_CHOSEN=false
+# Custom areas come from the Overpass API, not from a download server
+if [ "$OFFLINE" != "true" ] && [ "$CUSTOM_AREA" != "true" ] && [ "$NETWORK_CHECK" != "false" ]; then
+    check_download_servers
+fi
+# Without the check (or without curl) a mirror the user set is used unprobed
+if [ "$MIRROR_CHOSEN" != "true" ] && [ -n "${VNS_MIRROR:-}" ]; then
+    DOCKER_ENV_ARGS+=(-e "VNS_MIRROR=${VNS_MIRROR%/}/")
+fi
+
+# Run a command in a fresh container with the output and cache volumes
+run_in_container() {
+    docker run --rm \
+        -v "$(pwd)/output:/app/output" \
+        -v "$(pwd)/cache:/app/cache" \
+        "${DOCKER_NETWORK_ARGS[@]}" \
+        "${DOCKER_ENV_ARGS[@]}" \
+        "$@"
+}
+
+# Package the finished region folders into one archive, laid out the way VNS
+# expects them under atak/tools/VNS/GH/ (one folder per region)
+create_bundle() {
+    local bundle="$1"
+    shift
+    echo "📦 Creating bundle ./output/${bundle} with: $*"
+    # A signature of the previous bundle no longer matches
+    rm -f "./output/${bundle}.sha256.minisig" "./output/${bundle}.sha256.asc"
+    case "$bundle" in
+        *.zip)
+            run_in_container "$DOCKER_IMAGE" bash -c \
+                'cd /app/output && rm -f "$0.tmp" && zip -r -q "$0.tmp" "$@" && mv "$0.tmp" "$0" \
+                    && sha256sum "$0" > "$0.sha256.tmp" && mv "$0.sha256.tmp" "$0.sha256"' "$bundle" "$@"
+            ;;
+        *.tar.gz)
+            run_in_container "$DOCKER_IMAGE" bash -c \
+                'set -o pipefail; cd /app/output && tar -cf - "$@" | gzip > "$0.tmp" && mv "$0.tmp" "$0" \
+                    && sha256sum "$0" > "$0.sha256.tmp" && mv "$0.sha256.tmp" "$0.sha256"' "$bundle" "$@"
+            ;;
+    esac
+}
+
+# --- Package Signing ---
+# With VNS_SIGN_KEY set, the SHA-256 sidecar of every package built in this
+# run is signed on the host, so the key never enters the container. Signing
+# the sidecar instead of the package takes a second, and './verify.sh
+# --signature' checks both links: signature -> sidecar -> package.
+# VNS_SIGN_KEY is a minisign secret key file, or else a GPG key ID or email.
+SIGN_KEY=${VNS_SIGN_KEY:-}
+if [ -n "$SIGN_KEY" ]; then
+    if [ -f "$SIGN_KEY" ]; then
+        if ! command -v minisign >/dev/null 2>&1; then
+            echo "❌ Error: VNS_SIGN_KEY is a minisign key, but minisign is not installed"
+            echo "   Debian/Ubuntu: sudo apt install minisign   macOS: brew install minisign"
+            exit 1
+        fi
+    elif ! command -v gpg >/dev/null 2>&1; then
+        echo "❌ Error: VNS_SIGN_KEY '${SIGN_KEY}' is not a minisign key file and gpg is not installed"
+        exit 1
+    fi
+fi
+
+# Sign one sidecar: <sidecar>.minisig (minisign) or <sidecar>.asc (GPG)
+sign_sidecar() {
+    local sidecar="$1"
+    if [ -f "$SIGN_KEY" ]; then
+        minisign -S -s "$SIGN_KEY" -m "$sidecar" -x "${sidecar}.minisig.tmp" \
+            -t "VNS routing package $(basename "${sidecar%.sha256}")" >/dev/null || return 1
+        mv "${sidecar}.minisig.tmp" "${sidecar}.minisig"
+        echo "🔏 Signed: ${sidecar}.minisig"
+    else
+        gpg --yes --armor --local-user "$SIGN_KEY" --output "${sidecar}.asc.tmp" --detach-sign "$sidecar" || return 1
+        mv "${sidecar}.asc.tmp" "${sidecar}.asc"
+        echo "🔏 Signed: ${sidecar}.asc"
+    fi
+}
+
+# Sign the packages of the regions built in this run, and the bundle
+sign_outputs() {
+    local name output sidecar
+    local failed=0
+    local sidecars=()
+    for name in "$@"; do
+        output=$(batch_output "$name")
+        if [ -n "$output" ] && [ -f "${output}.sha256" ]; then
+            sidecars+=("${output}.sha256")
+        fi
+    done
+    if [ -n "$BUNDLE_NAME" ] && [ -f "./output/${BUNDLE_NAME}.sha256" ]; then
+        sidecars+=("./output/${BUNDLE_NAME}.sha256")
+    fi
+    echo ""
+    for sidecar in "${sidecars[@]}"; do
+        if ! sign_sidecar "$sidecar"; then
+            echo "❌ Error: Could not sign ${sidecar}"
+            rm -f "${sidecar}.minisig.tmp" "${sidecar}.asc.tmp"
+            failed=$((failed + 1))
+        fi
+    done
+    [ "$failed" -eq 0 ]
+}
+
+BUILD_START_TIME=$(date +%s)
+FAILED_REGIONS=()
+# --- Batch Queue ---
+# Regions still waiting in a multi-region run, one per line. The file is read
+# again before each region starts, so queue.sh (or a text editor) can reorder,
+# add or remove regions while the batch is running. Each batch has its own
+# queue, next to its plan.
+BATCH_QUEUE_FILE="./cache/batch-queue.$$"
+BATCH_MODE=false
+if [ ${#REGION_PATHS[@]} -gt 1 ]; then
+    BATCH_MODE=true
+fi
+
+read_batch_queue() {
+    local line
+    BATCH_QUEUE=()
+    if [ ! -f "$BATCH_QUEUE_FILE" ]; then
+        return 0
+    fi
+    while IFS= read -r line || [ -n "$line" ]; do
+        line="${line%%#*}"
+        line="${line//[[:space:]]/}"
+        if [ -n "$line" ]; then
+            BATCH_QUEUE+=("$line")
+        fi
+    done < "$BATCH_QUEUE_FILE"
+}
+
+write_batch_queue() {
+    printf '%s\n' "$@" > "${BATCH_QUEUE_FILE}.tmp" && mv "${BATCH_QUEUE_FILE}.tmp" "$BATCH_QUEUE_FILE"
+}
+
+# Held around every read-modify-write of the queue, here and in queue.sh, so
+# an edit is neither lost nor undone when the next region is taken off it
+lock_batch_queue() {
+    exec 6>>"${BATCH_QUEUE_FILE}.lock"
+    command -v flock >/dev/null 2>&1 && flock 6
+}
+
+unlock_batch_queue() {
+    exec 6>&-
+}
+
+if [ "$BATCH_MODE" = "true" ]; then
+    BATCH_QUEUE=()
+    for region_path in "${REGION_PATHS[@]}"; do
+        if [ "$(region_done "$region_path")" = "false" ]; then
+            BATCH_QUEUE+=("$region_path")
+        fi
+    done
+    lock_batch_queue
+    write_batch_queue "${BATCH_QUEUE[@]}"
+    unlock_batch_queue
+    if [ ${#BATCH_DONE[@]} -gt 0 ]; then
+        echo "✅ Already completed in the interrupted run: ${BATCH_DONE[*]}"
+    fi
+    echo "📋 Queue: ${BATCH_QUEUE_FILE} (edit it or use ./queue.sh to change the order while running)"
+fi
+
+# --- Skipping a Region ---
+# In a batch, Ctrl+C cancels only the region being processed: its container is
+# stopped (generate-data.sh removes the partial graph) and the batch moves on.
+# A second Ctrl+C within a few seconds stops the whole batch, which can then
+# be continued with --resume. './queue.sh skip' does the same from elsewhere
+# and leaves a marker named after the container, so a region only counts as
+# skipped when someone asked for it - a container killed by the kernel for
+# running out of memory also exits with 137, and that is a failure.
+SKIPPED_REGIONS=()
+STOP_BATCH=false
+SKIP_REQUESTED=false
+LAST_INTERRUPT=0
+
+skip_marker() {
+    echo "./cache/skip.${BATCH_CONTAINER}"
+}
+
+on_batch_interrupt() {
+    local now
+    now=$(date +%s)
+    echo ""
+    if [ $((now - LAST_INTERRUPT)) -le 3 ]; then
+        STOP_BATCH=true
+        echo "🛑 Stopping the batch after cleaning up ${BATCH_CURRENT}..."
+    else
+        echo "⏭️  Skipping ${BATCH_CURRENT} - press Ctrl+C again within 3 seconds to stop the whole batch"
+    fi
+    LAST_INTERRUPT=$now
+    SKIP_REQUESTED=true
+    # Give generate-data.sh time to clean up before docker kills it
+    docker stop -t 60 "$BATCH_CONTAINER" >/dev/null 2>&1 &
+}
+
+# Leave the plan and queue in place for --resume and exit. Skipped regions
+# are dropped from the plan - the user chose not to build them.
+pause_batch() {
+    lock_batch_queue
+    read_batch_queue
+    unlock_batch_queue
+    REGION_PATHS=("${BATCH_DONE[@]}" "${FAILED_REGIONS[@]}" "${BATCH_QUEUE[@]}")
+    BATCH_CURRENT=""
+    save_batch_plan
+    echo "⏸️  Batch stopped - run './run.sh --resume' to continue"
+    exit 130
+}
+
+# Run one region of a batch in a named container. The container runs in the
+# background so Ctrl+C reaches on_batch_interrupt instead of the container.
+run_batch_region() {
+    local region_path="$1"
+    local pid
+    local status
+    BATCH_CONTAINER="vns-batch-$$-$(( ${#BATCH_DONE[@]} + ${#FAILED_REGIONS[@]} + ${#SKIPPED_REGIONS[@]} + 1 ))"
+    save_batch_plan
+    run_in_container --name "$BATCH_CONTAINER" "${QUEUE_ARGS[@]}" "$DOCKER_IMAGE" ./generate-data.sh "$region_path" "${GENERATE_ARGS[@]}" &
+    pid=$!
+    while true; do
+        wait "$pid"
+        status=$?
+        if ! kill -0 "$pid" 2>/dev/null; then
+            break
+        fi
+    done
+    return "$status"
+}
+
+# --- Batch Summary ---
+# At the end of a batch, one line per region: how it ended, how long it took
+# and where its package is, or why it failed. Followed by the command that
+# retries the failed regions.
+BATCH_RESULTS=()
+
+# Package (or folder) a region was built into
+batch_output() {
+    local name="$1"
+    local candidate
+    for candidate in "./output/${name}.zip" "./output/${name}.tar.gz" "./output/${name}"; do
+        if [ -e "$candidate" ]; then
+            echo "$candidate"
+            return
+        fi
+    done
+}
+
+# Why a region failed, from the last run recorded in its build log
+batch_failure_reason() {
+    local log="./output/logs/${1}/build.log"
+    local reason
+    reason=$(sed -n 's/.*run finished: result=failure, step=\([^,]*\), exit_code=\(.*\)$/failed during \1 (exit code \2)/p' "$log" 2>/dev/null | tail -n 1)
+    if [ -n "$reason" ]; then
+        echo "${reason} - see ${log}"
+    else
+        echo "failed - see the output above"
+    fi
+}
+
+print_batch_summary() {
+    local entry region_path result seconds name output detail icon
+    echo ""
+    echo "📋 Batch Summary"
+    echo "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━"
+    for entry in "${BATCH_RESULTS[@]}"; do
+        IFS='|' read -r region_path result seconds <<< "$entry"
+        name=$(basename "$region_path")
+        case "$result" in
+            built)
+                icon="✅"
+                output=$(batch_output "$name")
+                detail="${output:-./output/${name}}${output:+ ($(du -sh "$output" | cut -f1))}"
+                ;;
+            skipped)
+                icon="⏭️ "
+                detail="skipped with Ctrl+C or ./queue.sh skip"
+                ;;
+            split)
+                icon="✂️ "
+                detail="ran out of memory - built as its sub-regions instead"
+                ;;
+            *)
+                icon="❌"
+                detail=$(batch_failure_reason "$name")
+                ;;
+        esac
+        printf "%s %-24s %-8s %3dm%02ds  %s\n" "$icon" "$region_path" "$result" $(( seconds / 60 )) $(( seconds % 60 )) "$detail"
+    done
+    if [ ${#FAILED_REGIONS[@]} -gt 0 ]; then
+        echo ""
+        echo "🔁 Retry the failed regions: ./run.sh ${FAILED_REGIONS[*]}"
+    fi
+}
+
+# --- Sub-region Fallback ---
+# generate-data.sh leaves an out-of-memory marker in the region's log folder
+# when the import ran out of memory even after its own retry. Geofabrik splits most large regions into smaller extracts
+# (us-south into states, germany into its states): with --auto-split those
+# are queued in place of the region, otherwise the user is asked, or shown
+# the command when nobody is at the terminal. The graphs cannot be merged
+# again - that would need the memory the region ran out of - but --bundle
+# still packages them together.
+SPLIT_REGIONS=()
+
+out_of_memory_marker() {
+    echo "./output/logs/$(basename "$1")/out-of-memory"
+}
+
+# Geofabrik sub-regions of a region: its children in the index, or else the
+# regions whose ISO 3166-2 codes it covers (how us-south lists its states),
+# without the parts of regions already listed
+sub_regions() {
+    jq -r --arg id "$1" '
+        [.features[].properties] as $all
+        | ([$all[] | select(.id == $id) | .["iso3166-2"] // []] | add // []) as $codes
+        | [$all[] | select(.parent == $id)] as $children
+        | (if ($children | length) > 0 then $children
+           elif ($codes | length) > 1 then
+               [$all[] | select(.id != $id and (.["iso3166-2"] // []) != []
+                   and ((.["iso3166-2"] - $codes) == []))]
+           else [] end) as $subs
+        | ($subs | map(.id)) as $ids
+        | $subs[] | select((.parent // "") as $parent | $ids | index($parent) | not) | .id' \
+        "$INDEX_CACHE_FILE" 2>/dev/null
+}
+
+# Sets SPLIT_REGIONS when a region that ran out of memory should be replaced
+# by its sub-regions
+offer_split() {
+    local region_path="$1"
+    SPLIT_REGIONS=()
+    if [ "$BENCHMARK" = "true" ] || [ "$CUSTOM_AREA" = "true" ] \
+        || ! command -v jq >/dev/null 2>&1 || [ ! -s "$INDEX_CACHE_FILE" ]; then
+        return 1
+    fi
+    local subs=()
+    mapfile -t subs < <(sub_regions "$region_path")
+    echo ""
+    if [ ${#subs[@]} -eq 0 ]; then
+        echo "💡 ${region_path} ran out of memory and Geofabrik has no smaller extracts of it"
+        return 1
+    fi
+    echo "✂️  ${region_path} ran out of memory. Geofabrik splits it into ${#subs[@]} sub-regions:"
+    printf '   • %s\n' "${subs[@]}"
+    local answer="n"
+    if [ "$AUTO_SPLIT" = "true" ]; then
+        answer="y"
+    elif [ -t 0 ]; then
+        read_answer "Build the sub-regions instead? [Y/n] " answer || answer="n"
+        answer="${answer:-y}"
+    else
+        echo "💡 Build them instead with: ./run.sh ${region_path} --auto-split"
+    fi
+    [[ "$answer" =~ ^[Yy] ]] || return 1
+    SPLIT_REGIONS=("${subs[@]}")
+}
+
+if [ "$BATCH_MODE" = "true" ]; then
+    trap on_batch_interrupt INT
+fi
+
+while true; do
+    QUEUE_ARGS=()
+    if [ "$BATCH_MODE" = "true" ]; then
+        if [ "$STOP_BATCH" = "true" ]; then
+            pause_batch
+        fi
+        lock_batch_queue
+        read_batch_queue
+        if [ ${#BATCH_QUEUE[@]} -eq 0 ]; then
+            unlock_batch_queue
+            break
+        fi
+        region_path="${BATCH_QUEUE[0]}"
+        BATCH_CURRENT="$region_path"
+        write_batch_queue "${BATCH_QUEUE[@]:1}"
+        unlock_batch_queue
+        # Keep the plan in step with queue edits so --resume and the bundle
+        # see the regions as they are now
+        REGION_PATHS=("${BATCH_DONE[@]}" "${FAILED_REGIONS[@]}" "${SKIPPED_REGIONS[@]}" "${BATCH_QUEUE[@]}")
+
+        REGION_INDEX=$(( ${#BATCH_DONE[@]} + ${#FAILED_REGIONS[@]} + ${#SKIPPED_REGIONS[@]} + 1 ))
+        echo ""
+        echo "=== [${REGION_INDEX}/${#REGION_PATHS[@]}] ${region_path} ==="
+        QUEUE_ARGS=(-e "VNS_QUEUE_DEPTH=$(( ${#BATCH_QUEUE[@]} - 1 ))")
+
+        region_started=$(date +%s)
+        rm -f "$(out_of_memory_marker "$region_path")"
+        run_batch_region "$region_path"
+        status=$?
+        region_seconds=$(( $(date +%s) - region_started ))
+        if [ -f "$(skip_marker)" ]; then
+            SKIP_REQUESTED=true
+            rm -f "$(skip_marker)"
+        fi
+        if [ "$status" -eq 0 ]; then
+            BATCH_DONE+=("$region_path")
+            BATCH_RESULTS+=("${region_path}|built|${region_seconds}")
+            save_batch_plan
+        elif [ "$STOP_BATCH" = "true" ]; then
+            # Put the region back so --resume starts with it
+            lock_batch_queue
+            read_batch_queue
+            write_batch_queue "$region_path" "${BATCH_QUEUE[@]}"
+            unlock_batch_queue
+            pause_batch
+        elif [ "$SKIP_REQUESTED" = "true" ]; then
+            SKIPPED_REGIONS+=("$region_path")
+            BATCH_RESULTS+=("${region_path}|skipped|${region_seconds}")
+            echo "⏭️  Skipped ${region_path}"
+        elif [ -f "$(out_of_memory_marker "$region_path")" ] && offer_split "$region_path"; then
+            # The sub-regions are next in line
+            lock_batch_queue
+            read_batch_queue
+            write_batch_queue "${SPLIT_REGIONS[@]}" "${BATCH_QUEUE[@]}"
+            unlock_batch_queue
+            BATCH_RESULTS+=("${region_path}|split|${region_seconds}")
+        else
+            FAILED_REGIONS+=("$region_path")
+            BATCH_RESULTS+=("${region_path}|failed|${region_seconds}")
+        fi
+        SKIP_REQUESTED=false
+    else
+        region_path="${REGION_PATHS[0]}"
+        region_started=$(date +%s)
+        rm -f "$(out_of_memory_marker "$region_path")"
+        run_in_container "$DOCKER_IMAGE" ./generate-data.sh "$region_path" "${GENERATE_ARGS[@]}"
+        status=$?
+        if [ "$status" -ne 0 ] && [ -f "$(out_of_memory_marker "$region_path")" ] && offer_split "$region_path"; then
+            # Carry on as a batch of the sub-regions
+            BATCH_MODE=true
+            BATCH_RESULTS+=("${region_path}|split|$(( $(date +%s) - region_started ))")
+            REGION_PATHS=("${SPLIT_REGIONS[@]}")
+            lock_batch_queue
+            write_batch_queue "${REGION_PATHS[@]}"
+            unlock_batch_queue
+            trap on_batch_interrupt INT
+            continue
+        elif [ "$status" -ne 0 ]; then
+            FAILED_REGIONS+=("$region_path")
+        fi
+        break
+    fi
+done
+
+if [ "$BENCHMARK" = "true" ]; then
+    echo "---"
+    if [ ${#FAILED_REGIONS[@]} -gt 0 ]; then
+        echo "❌ Error: Benchmark failed. Please check the logs above for details."
+        exit 1
+    fi
+    echo "✅ Benchmark complete - time estimates on this machine are now calibrated"
+    exit 0
+fi
+
+# The batch ran to the end (failures are reported below), nothing to resume
+if [ "$BATCH_MODE" = "true" ]; then
+    trap - INT
+    rm -f "$BATCH_PLAN_FILE" "$BATCH_QUEUE_FILE" "${BATCH_QUEUE_FILE}.lock"
+    # Skipped regions are left out of the bundle and the summary
+    REGION_PATHS=("${BATCH_DONE[@]}" "${FAILED_REGIONS[@]}")
+    set_region_names
+    # Shareable summary of what this batch built
+    if [ ${#BATCH_DONE[@]} -gt 0 ]; then
+        echo ""
+        run_in_container "$DOCKER_IMAGE" ./report.sh --output output/build-report.md "${BATCH_DONE[@]}" || true
+        run_in_container "$DOCKER_IMAGE" ./report.sh --output output/build-report.html "${BATCH_DONE[@]}" || true
+    fi
+    print_batch_summary
+fi
+
+if [ ${#FAILED_REGIONS[@]} -eq 0 ] && [ -n "$BUNDLE_NAME" ]; then
+    if ! create_bundle "$BUNDLE_NAME" "${REGION_NAMES[@]}"; then
+        echo "❌ Error: Failed to create bundle ${BUNDLE_NAME}"
+        FAILED_REGIONS+=("bundle")
+    fi
+fi
+
+if [ -n "$SIGN_KEY" ]; then
+    if [ "$BATCH_MODE" = "true" ]; then
+        SIGN_NAMES=()
+        for region_path in "${BATCH_DONE[@]}"; do
+            SIGN_NAMES+=("$(basename "$region_path")")
+        done
+    elif [ ${#FAILED_REGIONS[@]} -eq 0 ]; then
+        SIGN_NAMES=("${REGION_NAMES[@]}")
+    else
+        SIGN_NAMES=()
+    fi
+    if ! sign_outputs "${SIGN_NAMES[@]}"; then
+        FAILED_REGIONS+=("signing")
+    fi
+fi
+
 # Check the exit code of the Docker command
-if docker run --rm \
-    -v "$(pwd)/output:/app/output" \
-    -v "$(pwd)/cache:/app/cache" \
-    "$DOCKER_IMAGE" \
-    bash -c "./generate-data.sh ${REGION_PATH}"; then
+if [ ${#FAILED_REGIONS[@]} -eq 0 ]; then
     echo "---"
     echo "✅ Data generation completed successfully!"
     echo ""
     echo "📁 Generated files are located in: './output' directory"
-    echo "📦 Both folder and ZIP file are ready for transfer to your device"
+    if [ -n "$BUNDLE_NAME" ]; then
+        echo "📦 All regions bundled in: './output/${BUNDLE_NAME}' - extract it into the GH folder"
+    else
+        echo "📦 Routing data is ready for transfer to your device"
+    fi
     echo ""
     echo "📱 VNS SETUP EXAMPLE - Complete folder structure on your Android device:"
     echo "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━"
@@ -104,18 +1308,34 @@ if docker run --rm \
     echo "    └── tools/"
     echo "        └── VNS/"
     echo "            └── GH/"
-    echo "                ├── ${REGION_NAME}/          ← Your new routing data"
-    echo "                │   ├── ${REGION_NAME}.kml"
-    echo "                │   ├── ${REGION_NAME}.poly"
-    echo "                │   ├── edges"
-    echo "                │   ├── geometry"
-    echo "                │   ├── nodes"
-    echo "                │   └── ... (other files)"
+    for region_name in "${REGION_NAMES[@]}"; do
+        echo "                ├── ${region_name}/          ← Your new routing data"
+        echo "                │   ├── ${region_name}.kml"
+        echo "                │   ├── ${region_name}.poly"
+        echo "                │   ├── edges"
+        echo "                │   ├── geometry"
+        echo "                │   ├── nodes"
+        echo "                │   └── ... (other files)"
+    done
     echo "                ├── florida/        ← Example: Other data you might have"
     echo "                └── california/     ← Example: Additional routing data"
     echo ""
     echo "🔧 VNS will automatically detect all folders in the GH directory!"
+    notify_build_finished success
+    if [ "$OPEN_OUTPUT" = "true" ]; then
+        if open_folder ./output; then
+            echo "📂 Opened ./output in the file manager"
+        else
+            echo "📂 No file manager available - the files are in $(pwd)/output"
+        fi
+    fi
 else
     echo "---"
-    echo "❌ Error: Data generation failed. Please check the logs above for details."
+    if [ "$BATCH_MODE" = "true" ]; then
+        echo "❌ Error: Data generation failed for: ${FAILED_REGIONS[*]}. Please check the logs above for details."
+    else
+        echo "❌ Error: Data generation failed. Please check the logs above for details."
+    fi
+    notify_build_finished failure
+    exit 1
 fi
@@ -0,0 +1,63 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Output Catalog Check
+#
+# Description:
+# Runs write_output_index from generate-data.sh against the registry in
+# scripts/fixtures/registry.json and checks the index.json it writes. The
+# fixture mixes a Geofabrik HTTP date, a custom-area signature and "unknown"
+# as source_date, so a catalog that breaks on any of them is caught here.
+#
+# Usage:
+# ./scripts/check-output-index.sh
+# ==============================================================================
+
+SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
+FIXTURE="${SCRIPT_DIR}/fixtures/registry.json"
+
+if ! command -v jq >/dev/null 2>&1; then
+    echo "❌ Error: jq is required. Install: sudo apt-get install jq"
+    exit 1
+fi
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# Only the function itself: the rest of generate-data.sh runs a build
+eval "$(sed -n '/^write_output_index() {$/,/^}$/p' "${SCRIPT_DIR}/../generate-data.sh")"
+
+cd "$WORK_DIR" || exit 1
+mkdir -p output/malta
+touch output/delaware.zip output/fort-bragg.zip
+REGISTRY_FILE="$FIXTURE"
+OUTPUT_INDEX_FILE="./output/index.json"
+
+if ! write_output_index; then
+    echo "❌ write_output_index failed"
+    exit 1
+fi
+
+failed=0
+check() {
+    local description="$1"
+    local filter="$2"
+    if jq -e "$filter" "$OUTPUT_INDEX_FILE" >/dev/null; then
+        echo "✅ ${description}"
+    else
+        echo "❌ ${description}"
+        failed=1
+    fi
+}
+
+check "all three packages listed" '.packages | length == 3'
+check "HTTP date becomes the version" \
+    '.packages[] | select(.region == "delaware") | .version == "2026-09-30T20:21:02Z"'
+check "custom-area signature falls back to built_at" \
+    '.packages[] | select(.region == "fort-bragg") | .version == "2026-10-02T08:00:00Z"'
+check "unknown source date falls back to built_at" \
+    '.packages[] | select(.region == "malta") | .version == "2026-10-03T11:30:00Z"'
+check "folder output keeps its trailing slash" \
+    '.packages[] | select(.region == "malta") | .path == "malta/"'
+
+exit "$failed"
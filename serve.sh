@@ -0,0 +1,193 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - LAN Package Server
+#
+# Description:
+# Serves the packages in ./output over HTTP on the local network and shows a
+# QR code for each, in the terminal and on a download page, so an ATAK device
+# on the same Wi-Fi can fetch its routing data by scanning the laptop screen.
+# Only the packages and their checksums are reachable - logs, backups and the
+# rest of ./output are not. QR codes need qrencode; without it only the URLs
+# are shown.
+#
+# Usage:
+# ./serve.sh                    # http://<this machine>:8000/
+# ./serve.sh --port 9000        # another port
+# ./serve.sh --no-qr            # only print the URLs
+# Stop the server with Ctrl+C.
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+
+OUTPUT_DIR="./output"
+INDEX_FILE="${OUTPUT_DIR}/index.json"
+# What the server sees: links to the packages and the download page
+SERVE_DIR=""
+PORT=8000
+SHOW_QR=true
+
+usage() {
+    echo "Usage: ./serve.sh [--port <port>] [--no-qr]"
+}
+
+# Address of this machine on the local network: Linux, macOS, then the
+# interface that holds the default route
+lan_address() {
+    local address=""
+    if command -v hostname >/dev/null 2>&1; then
+        address=$(hostname -I 2>/dev/null | awk '{ print $1 }')
+    fi
+    if [ -z "$address" ] && command -v ipconfig >/dev/null 2>&1; then
+        address=$(ipconfig getifaddr en0 2>/dev/null || ipconfig getifaddr en1 2>/dev/null)
+    fi
+    if [ -z "$address" ] && command -v ip >/dev/null 2>&1; then
+        address=$(ip route get 1.1.1.1 2>/dev/null | sed -n 's/.* src \([0-9.]*\).*/\1/p')
+    fi
+    echo "${address:-localhost}"
+}
+
+# Packages to offer, relative to ./output: the catalog written after each
+# build, or else every package in the folder
+list_packages() {
+    if [ -s "$INDEX_FILE" ] && command -v jq >/dev/null 2>&1; then
+        jq -r '.packages[] | select(.format != "dir") | .path' "$INDEX_FILE"
+    else
+        (cd "$OUTPUT_DIR" && ls -1 -- *.zip *.tar.gz 2>/dev/null | grep -v '\.backup\.')
+    fi
+}
+
+# Link the packages and their .sha256 sidecars into SERVE_DIR
+stage_packages() {
+    local output_path
+    output_path=$(cd "$OUTPUT_DIR" && pwd)
+    local package
+    local file
+    for package in "$@"; do
+        mkdir -p "${SERVE_DIR}/$(dirname "$package")"
+        for file in "$package" "${package}.sha256"; do
+            if [ -f "${output_path}/${file}" ]; then
+                ln -s "${output_path}/${file}" "${SERVE_DIR}/${file}"
+            fi
+        done
+    done
+}
+
+# Download page with a scannable QR code per package (inline SVG from
+# qrencode), also served as the start page instead of a file listing
+write_download_page() {
+    local base_url="$1"
+    shift
+    local package
+    {
+        echo '<!DOCTYPE html><html><head><meta charset="utf-8">'
+        echo '<meta name="viewport" content="width=device-width, initial-scale=1">'
+        echo '<title>VNS routing packages</title>'
+        echo '<style>body{font-family:sans-serif;margin:2em}div{display:inline-block;margin:1em;text-align:center}svg{width:220px;height:220px}</style>'
+        echo '</head><body><h1>VNS routing packages</h1>'
+        echo '<p>Scan a code with the ATAK device, then extract the package into atak/tools/VNS/GH/.</p>'
+        for package in "$@"; do
+            echo "<div><a href=\"${package}\">"
+            if [ "$SHOW_QR" = "true" ] && command -v qrencode >/dev/null 2>&1; then
+                qrencode -t SVG -m 2 -o - "${base_url}${package}" | sed '1,/<svg/{/<svg/!d}'
+                echo '<br>'
+            fi
+            echo "${package}</a> ($(du -h "${OUTPUT_DIR}/${package}" | cut -f1))</div>"
+        done
+        echo '</body></html>'
+    } > "${SERVE_DIR}/packages.html"
+    ln -s packages.html "${SERVE_DIR}/index.html"
+}
+
+main() {
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --port)
+                PORT="$2"
+                shift
+                ;;
+            --port=*)
+                PORT="${1#*=}"
+                ;;
+            --no-qr)
+                SHOW_QR=false
+                ;;
+            -h|--help)
+                usage
+                exit 0
+                ;;
+            *)
+                echo "Error: Unknown option '$1'"
+                usage
+                exit 1
+                ;;
+        esac
+        shift
+    done
+
+    if ! [[ "$PORT" =~ ^[0-9]+$ ]]; then
+        echo "Error: Invalid port '$PORT'"
+        exit 1
+    fi
+    local python=""
+    if command -v python3 >/dev/null 2>&1; then
+        python=python3
+    elif command -v python >/dev/null 2>&1 && python -c 'import sys; sys.exit(sys.version_info[0] < 3)'; then
+        python=python
+    else
+        echo "❌ Error: Python 3 is required to serve the files but not installed"
+        exit 1
+    fi
+
+    local packages=()
+    local package
+    while IFS= read -r package; do
+        if [ -n "$package" ] && [ -f "${OUTPUT_DIR}/${package}" ]; then
+            packages+=("$package")
+        fi
+    done < <(list_packages)
+    if [ ${#packages[@]} -eq 0 ]; then
+        echo "📭 No packages in ${OUTPUT_DIR} - build a region first, e.g. ./run.sh us/delaware"
+        exit 1
+    fi
+
+    local base_url
+    base_url="http://$(lan_address):${PORT}/"
+    local have_qr=false
+    if [ "$SHOW_QR" = "true" ] && command -v qrencode >/dev/null 2>&1; then
+        have_qr=true
+    fi
+
+    echo "🌐 Serving the packages in ${OUTPUT_DIR} on the local network"
+    echo "=============================="
+    for package in "${packages[@]}"; do
+        echo ""
+        echo "📦 ${package}  ${base_url}${package}"
+        if [ "$have_qr" = "true" ]; then
+            qrencode -t ANSIUTF8 -m 2 "${base_url}${package}"
+        fi
+    done
+    SERVE_DIR=$(mktemp -d "${TMPDIR:-/tmp}/vns-serve.XXXXXX") || exit 1
+    trap 'rm -rf "$SERVE_DIR"' EXIT
+    stage_packages "${packages[@]}"
+    write_download_page "$base_url" "${packages[@]}"
+    echo ""
+    echo "📱 Download page: ${base_url}packages.html"
+    if [ "$have_qr" = "true" ]; then
+        qrencode -t ANSIUTF8 -m 2 "${base_url}packages.html"
+    elif [ "$SHOW_QR" = "true" ]; then
+        echo "💡 Install qrencode for scannable QR codes (Debian/Ubuntu: sudo apt install qrencode, macOS: brew install qrencode)"
+    fi
+    echo "⚠️  Anyone on this network can download these files. Ctrl+C stops the server."
+    echo ""
+
+    "$python" -m http.server "$PORT" --bind 0.0.0.0 --directory "$SERVE_DIR"
+    local status=$?
+    echo "🛑 Package server stopped"
+    # 130: stopped with Ctrl+C
+    if [ "$status" -ne 0 ] && [ "$status" -ne 130 ]; then
+        exit "$status"
+    fi
+}
+
+main "$@"
@@ -0,0 +1,159 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Build Status
+#
+# Description:
+# Lists every region built on this machine (from ./cache/registry.json) with
+# its build date, source data date, GraphHopper version and size, and flags
+# regions whose Geofabrik extract has been updated since they were built.
+#
+# Usage:
+# ./status.sh                  # all regions, checking Geofabrik for updates
+# ./status.sh delaware malta   # only these regions
+# ./status.sh --offline        # skip the update check
+# ./status.sh --json           # raw registry entries
+# ./status.sh --stale          # "region-id<TAB>format" of outdated regions (for scripts)
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+[ -f "$(dirname "$0")/http-options.sh" ] && . "$(dirname "$0")/http-options.sh"
+
+REGISTRY_FILE="./cache/registry.json"
+CHECK_UPDATES=true
+JSON_OUTPUT=false
+STALE_ONLY=false
+FILTER=()
+
+# Human-readable size from bytes
+format_size() {
+    awk -v b="$1" 'BEGIN {
+        split("B K M G T", unit, " ")
+        i = 1
+        while (b >= 1024 && i < 5) { b /= 1024; i++ }
+        printf (i == 1 ? "%d%s" : "%.1f%s"), b, unit[i]
+    }'
+}
+
+# Last-Modified of a Geofabrik file, empty if it cannot be reached
+remote_date() {
+    curl -sSI --max-time 15 "${CURL_OPTS[@]}" "$1" 2>/dev/null | grep -i '^Last-Modified:' | tail -n 1 | cut -d: -f2- | sed 's/^ *//' | tr -d '\r'
+}
+
+# Print one status word for a registry entry: current, stale, missing, custom or unknown
+build_status() {
+    local source_url="$1"
+    local source_date="$2"
+    local output_path="$3"
+    if [ ! -e "./${output_path}" ]; then
+        echo "missing"
+    elif [ -z "$source_url" ]; then
+        echo "custom"
+    elif [ "$CHECK_UPDATES" != "true" ]; then
+        echo "unchecked"
+    else
+        local latest
+        latest=$(remote_date "$source_url")
+        if [ -z "$latest" ]; then
+            echo "unknown"
+        elif [ "$latest" = "$source_date" ]; then
+            echo "current"
+        else
+            echo "stale"
+        fi
+    fi
+}
+
+main() {
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --offline) CHECK_UPDATES=false ;;
+            --json)    JSON_OUTPUT=true ;;
+            --stale)   STALE_ONLY=true ;;
+            -h|--help)
+                echo "Usage: ./status.sh [--offline] [--json | --stale] [region ...]"
+                exit 0
+                ;;
+            -*)
+                echo "Error: Unknown option '$1'"
+                echo "Usage: ./status.sh [--offline] [--json | --stale] [region ...]"
+                exit 1
+                ;;
+            *) FILTER+=("$(basename "$1")") ;;
+        esac
+        shift
+    done
+
+    if ! command -v jq >/dev/null 2>&1; then
+        echo "❌ Error: jq is required but not installed (see ./list-regions.sh for install hints)"
+        exit 1
+    fi
+    if [ ! -s "$REGISTRY_FILE" ]; then
+        [ "$STALE_ONLY" = "true" ] || echo "📭 No builds recorded yet - build a region with ./run.sh <region>"
+        exit 0
+    fi
+
+    local filter_json
+    filter_json=$(printf '%s\n' "${FILTER[@]}" | jq -R . | jq -sc 'map(select(. != ""))')
+    local entries
+    entries=$(jq -c --argjson only "$filter_json" \
+        'to_entries | map(.value) | map(select(($only | length) == 0 or (.region | IN($only[])))) | sort_by(.region) | .[]' \
+        "$REGISTRY_FILE")
+
+    if [ "$JSON_OUTPUT" = "true" ]; then
+        echo "$entries" | jq -s .
+        exit 0
+    fi
+
+    if [ "$STALE_ONLY" = "true" ]; then
+        local entry
+        while IFS= read -r entry; do
+            [ -n "$entry" ] || continue
+            if [ "$(build_status "$(echo "$entry" | jq -r '.source_url // ""')" \
+                "$(echo "$entry" | jq -r '.source_date')" \
+                "$(echo "$entry" | jq -r '.output_path // .package')")" = "stale" ]; then
+                echo "$entry" | jq -r '[.region_id, .format] | @tsv'
+            fi
+        done <<< "$entries"
+        exit 0
+    fi
+
+    echo "📚 VNS Build Status"
+    echo "==================="
+    [ "$CHECK_UPDATES" = "true" ] && echo "📡 Checking Geofabrik for newer data..."
+    echo ""
+    printf "  %-22s %-17s %-11s %-4s %8s  %s\n" "REGION" "BUILT (UTC)" "SOURCE" "GH" "SIZE" "STATUS"
+
+    local stale=0
+    local entry
+    while IFS= read -r entry; do
+        [ -n "$entry" ] || continue
+        local region built source_day graphhopper size_bytes source_url source_date output_path status label
+        region=$(echo "$entry" | jq -r '.region')
+        built=$(echo "$entry" | jq -r '.built_at | sub("T"; " ") | .[0:16]')
+        source_day=$(echo "$entry" | jq -r '.source_date | try (strptime("%a, %d %b %Y %H:%M:%S GMT") | strftime("%Y-%m-%d")) catch "custom"')
+        graphhopper=$(echo "$entry" | jq -r '.graphhopper_version')
+        size_bytes=$(echo "$entry" | jq -r '.size_bytes')
+        source_url=$(echo "$entry" | jq -r '.source_url // ""')
+        source_date=$(echo "$entry" | jq -r '.source_date')
+        output_path=$(echo "$entry" | jq -r '.output_path // .package')
+
+        status=$(build_status "$source_url" "$source_date" "$output_path")
+        case "$status" in
+            current)   label="✅ current" ;;
+            stale)     label="⚠️  update available"; stale=$((stale + 1)) ;;
+            missing)   label="🗑️  output deleted" ;;
+            custom)    label="🗺️  custom area" ;;
+            unchecked) label="-" ;;
+            *)         label="❓ could not check" ;;
+        esac
+        printf "  %-22s %-17s %-11s %-4s %8s  %s\n" "$region" "$built" "$source_day" "$graphhopper" "$(format_size "$size_bytes")" "$label"
+    done <<< "$entries"
+
+    echo ""
+    if [ "$stale" -gt 0 ]; then
+        echo "🔄 ${stale} region(s) have newer data on Geofabrik - rebuild them with ./update.sh"
+    fi
+}
+
+main "$@"
@@ -0,0 +1,77 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Update Stale Regions
+#
+# Description:
+# Rebuilds only the regions whose Geofabrik data changed since they were last
+# built (according to ./cache/registry.json), each in its original output
+# format. The core workflow for maintaining an offline routing library.
+#
+# Usage:
+# ./update.sh                    # rebuild every outdated region
+# ./update.sh delaware malta     # only consider these regions
+# ./update.sh --dry-run          # list what would be rebuilt
+# ./update.sh -- --filter-routing  # extra options for generate-data.sh
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+
+DRY_RUN=false
+REGIONS=()
+EXTRA_ARGS=()
+
+while [ $# -gt 0 ]; do
+    case "$1" in
+        --dry-run) DRY_RUN=true ;;
+        -h|--help)
+            echo "Usage: ./update.sh [--dry-run] [region ...] [-- <options for run.sh>]"
+            exit 0
+            ;;
+        --)
+            shift
+            EXTRA_ARGS=("$@")
+            break
+            ;;
+        -*)
+            echo "Error: Unknown option '$1'"
+            echo "Usage: ./update.sh [--dry-run] [region ...] [-- <options for run.sh>]"
+            exit 1
+            ;;
+        *) REGIONS+=("$1") ;;
+    esac
+    shift
+done
+
+echo "🔍 Checking built regions for newer Geofabrik data..."
+if ! STALE=$(./status.sh --stale "${REGIONS[@]}"); then
+    echo "❌ Error: Could not read the build registry"
+    exit 1
+fi
+
+if [ -z "$STALE" ]; then
+    echo "✅ All built regions are up to date - nothing to do"
+    exit 0
+fi
+
+echo "🔄 Regions with newer data:"
+echo "$STALE" | awk -F'\t' '{ printf "   • %s (%s)\n", $1, $2 }'
+
+if [ "$DRY_RUN" = "true" ]; then
+    echo ""
+    echo "Dry run - nothing rebuilt. Run ./update.sh without --dry-run to update."
+    exit 0
+fi
+
+# One run.sh invocation per output format, so each region keeps its format
+FAILED=0
+for format in $(echo "$STALE" | cut -f2 | sort -u); do
+    mapfile -t region_ids < <(echo "$STALE" | awk -F'\t' -v f="$format" '$2 == f { print $1 }')
+    echo ""
+    echo "▶️  Rebuilding ${#region_ids[@]} region(s) as ${format}..."
+    if ! ./run.sh "${region_ids[@]}" --format "$format" "${EXTRA_ARGS[@]}"; then
+        FAILED=1
+    fi
+done
+
+exit $FAILED
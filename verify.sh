@@ -0,0 +1,190 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Output Verifier
+#
+# Description:
+# Validates generated packages against the SHA-256 sidecar files written next
+# to them (e.g. delaware.zip.sha256). Run it on the receiving end after copying
+# packages over unreliable links to confirm nothing was corrupted in transit.
+#
+# Usage:
+# ./verify.sh                      # verify every sidecar in ./output
+# ./verify.sh delaware.zip         # verify one package (sidecar next to it)
+# ./verify.sh /media/usb/*.sha256  # verify explicit sidecar files
+# ./verify.sh --signature          # also require a valid minisign/GPG signature
+# ./verify.sh --signature --pubkey team.pub delaware.zip
+# ./verify.sh --signature --fingerprint <GPG key fingerprint> delaware.zip
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+
+OUTPUT_DIR="./output"
+CHECK_SIGNATURE=false
+# minisign public key of the team that signs the packages
+PUBLIC_KEY="${VNS_SIGN_PUBKEY:-./minisign.pub}"
+# Fingerprint of the team's GPG key: a good signature from any other key in
+# the keyring does not count
+GPG_FINGERPRINT="${VNS_SIGN_FINGERPRINT:-}"
+
+# Portable SHA-256 check: sha256sum on Linux/Git Bash, shasum on macOS
+check_sidecar() {
+    local sidecar_dir="$1"
+    local sidecar_name="$2"
+    if command -v sha256sum >/dev/null 2>&1; then
+        (cd "$sidecar_dir" && sha256sum -c --quiet "$sidecar_name")
+    elif command -v shasum >/dev/null 2>&1; then
+        (cd "$sidecar_dir" && shasum -a 256 -c --quiet "$sidecar_name")
+    else
+        echo "❌ Error: sha256sum or shasum is required but neither is installed."
+        exit 1
+    fi
+}
+
+# Check the signature of a sidecar: <sidecar>.minisig against PUBLIC_KEY, or
+# <sidecar>.asc made by the GPG key GPG_FINGERPRINT. Prints why it failed.
+check_signature() {
+    local sidecar="$1"
+    if [ -f "${sidecar}.minisig" ]; then
+        if ! command -v minisign >/dev/null 2>&1; then
+            echo "minisign is not installed"
+            return 1
+        fi
+        if [ ! -f "$PUBLIC_KEY" ]; then
+            echo "no public key at ${PUBLIC_KEY} (use --pubkey)"
+            return 1
+        fi
+        minisign -V -q -p "$PUBLIC_KEY" -m "$sidecar" -x "${sidecar}.minisig" >/dev/null 2>&1 || { echo "BAD SIGNATURE"; return 1; }
+    elif [ -f "${sidecar}.asc" ]; then
+        if ! command -v gpg >/dev/null 2>&1; then
+            echo "gpg is not installed"
+            return 1
+        fi
+        if [ -z "$GPG_FINGERPRINT" ]; then
+            echo "no GPG key fingerprint to check against (use --fingerprint)"
+            return 1
+        fi
+        # VALIDSIG names the signing key and, last, its primary key
+        local status
+        status=$(gpg --batch --status-fd 1 --verify "${sidecar}.asc" "$sidecar" 2>/dev/null) || { echo "BAD SIGNATURE"; return 1; }
+        if ! echo "$status" | awk -v fpr="$GPG_FINGERPRINT" '
+                $1 == "[GNUPG:]" && $2 == "VALIDSIG" && ($3 == fpr || $NF == fpr) { found = 1 }
+                END { exit !found }'; then
+            echo "SIGNED BY ANOTHER KEY"
+            return 1
+        fi
+    else
+        echo "NOT SIGNED"
+        return 1
+    fi
+}
+
+# Resolve an argument (package, folder or sidecar) to its sidecar file. A
+# name that does not exist as given is looked up in OUTPUT_DIR, so
+# "./verify.sh delaware.zip" works from the repo root.
+resolve_sidecar() {
+    local target="${1%/}"
+    local sidecar
+    case "$target" in
+        *.sha256) sidecar="$target" ;;
+        *)        sidecar="${target}.sha256" ;;
+    esac
+    if [ ! -e "$sidecar" ] && [ -e "${OUTPUT_DIR}/${sidecar}" ]; then
+        sidecar="${OUTPUT_DIR}/${sidecar}"
+    fi
+    echo "$sidecar"
+}
+
+main() {
+    local sidecars=()
+    local targets=()
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --signature)
+                CHECK_SIGNATURE=true
+                ;;
+            --pubkey)
+                PUBLIC_KEY="$2"
+                shift
+                ;;
+            --pubkey=*)
+                PUBLIC_KEY="${1#*=}"
+                ;;
+            --fingerprint)
+                GPG_FINGERPRINT="$2"
+                shift
+                ;;
+            --fingerprint=*)
+                GPG_FINGERPRINT="${1#*=}"
+                ;;
+            *)
+                targets+=("$1")
+                ;;
+        esac
+        shift
+    done
+    set -- "${targets[@]}"
+    # Accept the fingerprint as gpg prints it: spaced, any case
+    GPG_FINGERPRINT=$(echo "$GPG_FINGERPRINT" | tr -d ' ' | tr '[:lower:]' '[:upper:]')
+
+    if [ $# -eq 0 ]; then
+        if [ ! -d "$OUTPUT_DIR" ]; then
+            echo "❌ Error: No output directory found at $OUTPUT_DIR"
+            exit 1
+        fi
+        for sidecar in "$OUTPUT_DIR"/*.sha256; do
+            [ -e "$sidecar" ] && sidecars+=("$sidecar")
+        done
+    else
+        for arg in "$@"; do
+            sidecars+=("$(resolve_sidecar "$arg")")
+        done
+    fi
+
+    if [ ${#sidecars[@]} -eq 0 ]; then
+        echo "⚠️  No checksum files found to verify."
+        exit 1
+    fi
+
+    echo "🔐 VNS Output Verification"
+    echo "=========================="
+
+    local passed=0
+    local failed=0
+    local unsigned=0
+    local problem
+    for sidecar in "${sidecars[@]}"; do
+        printf "  %-40s " "$(basename "${sidecar%.sha256}")"
+        if [ ! -f "$sidecar" ]; then
+            echo "❌ missing checksum file"
+            failed=$((failed + 1))
+        elif ! check_sidecar "$(dirname "$sidecar")" "$(basename "$sidecar")" >/dev/null 2>&1; then
+            echo "❌ CHECKSUM MISMATCH"
+            failed=$((failed + 1))
+        elif [ "$CHECK_SIGNATURE" != "true" ]; then
+            echo "✅ OK"
+            passed=$((passed + 1))
+        elif problem=$(check_signature "$sidecar"); then
+            echo "✅ OK, signature valid"
+            passed=$((passed + 1))
+        else
+            echo "❌ ${problem}"
+            failed=$((failed + 1))
+            unsigned=$((unsigned + 1))
+        fi
+    done
+
+    echo ""
+    echo "📊 Results: $passed passed, $failed failed"
+    if [ "$unsigned" -gt 0 ]; then
+        echo "⚠️  Do not install packages without a valid signature - their origin cannot be proven."
+    fi
+    if [ "$failed" -gt "$unsigned" ]; then
+        echo "⚠️  Re-copy failed packages from the source before installing them."
+    fi
+    if [ "$failed" -gt 0 ]; then
+        exit 1
+    fi
+}
+
+main "$@"
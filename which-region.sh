@@ -0,0 +1,374 @@
+#!/bin/bash
+
+# ==============================================================================
+# VNS Offline Data Generator - Region Lookup by Coordinates
+#
+# Description:
+# Finds the Geofabrik regions containing a point and recommends a shortlist -
+# the smallest region, its neighbours and the wider regions around it - with
+# download sizes, so you don't need to know which administrative region your
+# area of operations falls in. Pick one with a single key to build it.
+# Given a GPX track or a KML file (route or area of operations) instead, it
+# lists every region the track passes through or the area overlaps.
+#
+# Usage:
+# ./which-region.sh 35.78,-78.64          # latitude,longitude
+# ./which-region.sh 35.78,-78.64 --yes    # build the smallest region right away
+# ./which-region.sh convoy-route.gpx      # regions along a track or route
+# ./which-region.sh ao.kml --yes          # build all regions of an AO right away
+# ==============================================================================
+
+[ -f "$(dirname "$0")/ascii-output.sh" ] && . "$(dirname "$0")/ascii-output.sh"
+[ -f "$(dirname "$0")/http-options.sh" ] && . "$(dirname "$0")/http-options.sh"
+
+# The index with boundaries is much larger than the one list-regions.sh uses;
+# it is cached and only downloaded again when Geofabrik has changed it.
+GEOM_INDEX_URL="https://download.geofabrik.de/index-v1.json"
+GEOM_INDEX_FILE="./cache/geofabrik-index-geometry.json"
+ASSUME_YES=false
+
+usage() {
+    echo "Usage: ./which-region.sh <lat>,<lon> [--yes]"
+    echo "       ./which-region.sh <track.gpx|area.kml> [--yes]"
+    echo "Example: ./which-region.sh 35.78,-78.64"
+}
+
+# Download the index if missing or changed (If-Modified-Since on the cached copy)
+fetch_geometry_index() {
+    mkdir -p "$(dirname "$GEOM_INDEX_FILE")"
+    local conditional=()
+    [ -s "$GEOM_INDEX_FILE" ] && conditional=(-z "$GEOM_INDEX_FILE")
+    if ! curl -sS --fail --max-time 300 -R "${CURL_OPTS[@]}" "${conditional[@]}" \
+        -o "${GEOM_INDEX_FILE}.tmp" "$GEOM_INDEX_URL"; then
+        rm -f "${GEOM_INDEX_FILE}.tmp"
+        if [ -s "$GEOM_INDEX_FILE" ]; then
+            echo "⚠️  Could not reach Geofabrik - using the cached region boundaries" >&2
+            return 0
+        fi
+        return 1
+    fi
+    # An unchanged index produces an empty response
+    if [ -s "${GEOM_INDEX_FILE}.tmp" ]; then
+        mv "${GEOM_INDEX_FILE}.tmp" "$GEOM_INDEX_FILE"
+    else
+        rm -f "${GEOM_INDEX_FILE}.tmp"
+    fi
+}
+
+# Print "area<TAB>id<TAB>name" for every region whose boundary contains the
+# point, smallest first. Area is in square degrees - only used for ordering.
+regions_containing() {
+    local lat="$1"
+    local lon="$2"
+    jq -r --argjson x "$lon" --argjson y "$lat" '
+        # Ray casting test against one ring of [lon, lat] points
+        def inring($x; $y):
+            . as $r | length as $n |
+            reduce range(0; $n) as $i (false;
+                $r[$i] as $p | $r[($i + $n - 1) % $n] as $q |
+                if (($p[1] > $y) != ($q[1] > $y))
+                   and ($x < ($q[0] - $p[0]) * ($y - $p[1]) / ($q[1] - $p[1]) + $p[0])
+                then not else . end);
+        def inpolygon($x; $y): (.[0] | inring($x; $y)) and ([.[1:][] | inring($x; $y)] | any | not);
+        def ringarea: . as $r | length as $n |
+            (reduce range(0; $n) as $i (0;
+                . + $r[$i][0] * $r[($i + 1) % $n][1] - $r[($i + 1) % $n][0] * $r[$i][1]) / 2) | fabs;
+        def polygons: if .type == "MultiPolygon" then .coordinates[] elif .type == "Polygon" then .coordinates else empty end;
+        .features[]
+        | select(.geometry != null)
+        | select(any(.geometry | polygons; inpolygon($x; $y)))
+        | [([.geometry | polygons | .[0] | ringarea] | add), .properties.id, .properties.name]
+        | @tsv' "$GEOM_INDEX_FILE" | sort -t$'\t' -k1,1g
+}
+
+# Print "lon lat" points covering the tracks, routes and waypoints of a GPX
+# file or the <coordinates> of a KML file. Legs are filled in every 0.05° so a
+# long straight leg cannot skip a region, and closed KML rings (areas of
+# operations) get a grid of points inside them. Points are rounded to 0.01°.
+file_points() {
+    local file="$1"
+    case "$file" in
+        *.gpx|*.GPX)
+            # All points as one "lon,lat lon,lat ..." line
+            tr '\n\r' '  ' < "$file" | grep -o '<\(trkpt\|rtept\|wpt\)[^>]*>' | awk '
+                {
+                    if (match($0, /lat=["\047][-+0-9.eE]+/)) lat = substr($0, RSTART + 5, RLENGTH - 5); else next
+                    if (match($0, /lon=["\047][-+0-9.eE]+/)) lon = substr($0, RSTART + 5, RLENGTH - 5); else next
+                    printf "%s,%s ", lon, lat
+                }
+                END { print "" }'
+            ;;
+        *.kml|*.KML)
+            # One line per <coordinates> element ("lon,lat[,alt] ...")
+            tr '\n\r\t' '   ' < "$file" | grep -o '<\(kml:\)\{0,1\}coordinates>[^<]*' | sed 's/^<[^>]*>//'
+            ;;
+        *)
+            return 1
+            ;;
+    esac | awk -v step=0.05 '
+        function emit(px, py) { printf "%.2f %.2f\n", px, py }
+        {
+            n = 0
+            for (f = 1; f <= NF; f++) {
+                if (split($f, c, ",") < 2 || c[1] == "" || c[2] == "") continue
+                n++; x[n] = c[1] + 0; y[n] = c[2] + 0
+            }
+            for (i = 1; i <= n; i++) {
+                if (i > 1) {
+                    dx = x[i] - x[i - 1]; dy = y[i] - y[i - 1]
+                    d = sqrt(dx * dx + dy * dy)
+                    for (s = step; s < d; s += step) emit(x[i - 1] + dx * s / d, y[i - 1] + dy * s / d)
+                }
+                emit(x[i], y[i])
+            }
+            # A closed ring is an area: sample its inside too
+            if (n >= 4 && x[1] == x[n] && y[1] == y[n]) {
+                minx = maxx = x[1]; miny = maxy = y[1]
+                for (i = 2; i <= n; i++) {
+                    if (x[i] < minx) minx = x[i]; if (x[i] > maxx) maxx = x[i]
+                    if (y[i] < miny) miny = y[i]; if (y[i] > maxy) maxy = y[i]
+                }
+                g = ((maxx - minx > maxy - miny) ? maxx - minx : maxy - miny) / 20
+                if (g < step) g = step
+                for (gx = minx + g / 2; gx < maxx; gx += g) {
+                    for (gy = miny + g / 2; gy < maxy; gy += g) {
+                        inside = 0
+                        for (i = 1; i < n; i++) {
+                            if (((y[i] > gy) != (y[i + 1] > gy)) && \
+                                (gx < (x[i + 1] - x[i]) * (gy - y[i]) / (y[i + 1] - y[i]) + x[i])) inside = !inside
+                        }
+                        if (inside) emit(gx, gy)
+                    }
+                }
+            }
+        }' | sort -u
+}
+
+# Print "points<TAB>id<TAB>name<TAB>has-subregions" for the smallest region
+# containing each of the points in a JSON file of [lon, lat] pairs, most
+# points first. Points outside every region are counted under the id "-".
+regions_along() {
+    local points_file="$1"
+    jq -r --slurpfile points "$points_file" '
+        def inring($x; $y):
+            . as $r | length as $n |
+            reduce range(0; $n) as $i (false;
+                $r[$i] as $p | $r[($i + $n - 1) % $n] as $q |
+                if (($p[1] > $y) != ($q[1] > $y))
+                   and ($x < ($q[0] - $p[0]) * ($y - $p[1]) / ($q[1] - $p[1]) + $p[0])
+                then not else . end);
+        def inpolygon($x; $y): (.[0] | inring($x; $y)) and ([.[1:][] | inring($x; $y)] | any | not);
+        def ringarea: . as $r | length as $n |
+            (reduce range(0; $n) as $i (0;
+                . + $r[$i][0] * $r[($i + 1) % $n][1] - $r[($i + 1) % $n][0] * $r[$i][1]) / 2) | fabs;
+        def polygons: if .type == "MultiPolygon" then .coordinates[] elif .type == "Polygon" then .coordinates else empty end;
+        [.features[].properties.parent | select(. != null)] as $parents
+        # Bounding boxes first: most points are only near a handful of regions
+        | [.features[] | select(.geometry != null)
+         | [.geometry | polygons] as $polys
+         | [$polys[] | .[0][]] as $outer
+         | {id: .properties.id, name: .properties.name, polys: $polys,
+            parent: (.properties.id | IN($parents[])),
+            area: ([$polys[] | .[0] | ringarea] | add),
+            bbox: [($outer | map(.[0]) | min), ($outer | map(.[1]) | min), ($outer | map(.[0]) | max), ($outer | map(.[1]) | max)]}] as $regions
+        | $points[0][] as $p
+        | [$regions[]
+           | select(.bbox[0] <= $p[0] and $p[0] <= .bbox[2] and .bbox[1] <= $p[1] and $p[1] <= .bbox[3])
+           | select(any(.polys[]; inpolygon($p[0]; $p[1])))]
+        | if length == 0 then "-\t-\tfalse" else (min_by(.area) | "\(.id)\t\(.name)\t\(.parent)") end' "$GEOM_INDEX_FILE" \
+        | sort | uniq -c | sort -rn | awk '{ count = $1; sub(/^ *[0-9]+ /, ""); print count "\t" $0 }'
+}
+
+# Print "id<TAB>name" for regions sharing a parent with the given region
+# whose bounding boxes touch it (i.e. neighbouring states or countries)
+neighbours_of() {
+    jq -r --arg id "$1" '
+        def polygons: if .type == "MultiPolygon" then .coordinates[] elif .type == "Polygon" then .coordinates else empty end;
+        def bbox: [.geometry | polygons | .[0][]] | [(map(.[0]) | min), (map(.[1]) | min), (map(.[0]) | max), (map(.[1]) | max)];
+        def touches($b): .[0] <= $b[2] + 0.05 and .[2] >= $b[0] - 0.05 and .[1] <= $b[3] + 0.05 and .[3] >= $b[1] - 0.05;
+        [.features[] | select(.geometry != null)] as $all
+        | ($all[] | select(.properties.id == $id)) as $self
+        | ($self | bbox) as $box
+        | $all[]
+        | select(.properties.parent == $self.properties.parent and .properties.id != $id)
+        | select(bbox | touches($box))
+        | [.properties.id, .properties.name] | @tsv' "$GEOM_INDEX_FILE" | sort -t$'\t' -k2
+}
+
+# Download size of a region's extract, from the server's Content-Length
+region_size() {
+    local url
+    url=$(jq -r --arg id "$1" '.features[] | select(.properties.id == $id) | .properties.urls.pbf // empty' "$GEOM_INDEX_FILE")
+    [ -n "$url" ] || { echo "?"; return; }
+    curl -sSI --max-time 15 "${CURL_OPTS[@]}" "$url" 2>/dev/null | grep -i '^Content-Length:' | tail -n 1 | awk '{
+        b = $2 + 0
+        if (b >= 1073741824) printf "%.1f GB", b / 1073741824
+        else if (b > 0) printf "%.0f MB", b / 1048576
+        else print "?"
+    }'
+}
+
+# Regions along a GPX track or covered by a KML route/area, with a prompt
+# to build them all
+track_regions() {
+    local file="$1"
+    local points_file="./cache/.which-region-points.$$"
+    trap 'rm -f "$points_file"' EXIT
+
+    echo "🗺️  Reading $(basename "$file")..."
+    file_points "$file" | awk '
+        BEGIN { printf "[" }
+        { printf "%s[%s,%s]", (NR > 1 ? "," : ""), $1, $2 }
+        END { print "]" }' > "$points_file"
+    local count
+    count=$(jq 'length' "$points_file" 2>/dev/null || echo 0)
+    if [ "${count:-0}" -eq 0 ]; then
+        echo "❌ Error: No track, route or coordinates found in ${file}"
+        exit 1
+    fi
+
+    echo "🔍 Matching ${count} points against the region boundaries..."
+    local matches
+    matches=$(regions_along "$points_file")
+
+    local ids=()
+    local parents=()
+    local points id name has_subregions
+    echo ""
+    echo "⭐ Regions along $(basename "$file"):"
+    while IFS=$'\t' read -r points id name has_subregions; do
+        if [ "$id" = "-" ]; then
+            echo "⚠️  ${points} of ${count} points are outside every Geofabrik region (at sea?)"
+            continue
+        fi
+        # Points in the gaps between subregions (usually along coasts) would
+        # otherwise pull in a whole country or continent
+        if [ "$has_subregions" = "true" ]; then
+            parents+=("$id")
+            echo "⚠️  ${points} points only fall inside ${name} (${id}), not in one of its subregions - skipped"
+            continue
+        fi
+        ids+=("$id")
+        printf "  %-26s %-24s %9s   %3d%% of the points\n" "$name" "$id" "$(region_size "$id")" $(( points * 100 / count ))
+    done <<< "$matches"
+    echo ""
+    if [ ${#ids[@]} -eq 0 ] && [ ${#parents[@]} -gt 0 ]; then
+        echo "💡 Only larger regions cover this file - build one with: ./run.sh ${parents[0]}"
+        exit 1
+    fi
+    if [ ${#ids[@]} -eq 0 ]; then
+        echo "❌ No Geofabrik region covers ${file}"
+        exit 1
+    fi
+
+    local answer=""
+    if [ "$ASSUME_YES" = "true" ]; then
+        answer="y"
+    elif [ -t 0 ]; then
+        read_answer "Build these ${#ids[@]} regions? [Y/n] " answer || answer="n"
+        answer="${answer:-y}"
+    else
+        echo "💡 Build them with: ./run.sh ${ids[*]}"
+    fi
+    if [[ "$answer" =~ ^[Yy] ]]; then
+        rm -f "$points_file"
+        exec ./run.sh "${ids[@]}"
+    fi
+}
+
+main() {
+    local point=""
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --yes|-y)  ASSUME_YES=true ;;
+            -h|--help) usage; exit 0 ;;
+            *)         point="$1" ;;
+        esac
+        shift
+    done
+
+    local lat lon
+    if [ -f "$point" ]; then
+        case "$point" in
+            *.gpx|*.GPX|*.kml|*.KML) ;;
+            *)
+                echo "Error: Unsupported file '${point}' - use a .gpx or .kml file"
+                exit 1
+                ;;
+        esac
+    else
+        IFS=',' read -r lat lon <<< "${point// /}"
+        if ! [[ "$lat" =~ ^-?[0-9]+(\.[0-9]+)?$ && "$lon" =~ ^-?[0-9]+(\.[0-9]+)?$ ]] || \
+            ! awk -v a="$lat" -v o="$lon" 'BEGIN { exit !(a >= -90 && a <= 90 && o >= -180 && o <= 180) }'; then
+            usage
+            exit 1
+        fi
+    fi
+
+    if ! command -v jq >/dev/null 2>&1; then
+        echo "❌ Error: jq is required but not installed (see ./list-regions.sh for install hints)"
+        exit 1
+    fi
+
+    echo "📡 Loading Geofabrik region boundaries..."
+    if ! fetch_geometry_index; then
+        echo "❌ Error: Failed to download ${GEOM_INDEX_URL}"
+        exit 1
+    fi
+
+    if [ -f "$point" ]; then
+        track_regions "$point"
+        exit 0
+    fi
+
+    echo "🔍 Looking up ${lat}, ${lon}..."
+    local matches
+    matches=$(regions_containing "$lat" "$lon")
+    if [ -z "$matches" ]; then
+        echo "❌ No Geofabrik region covers ${lat}, ${lon}"
+        exit 1
+    fi
+
+    # Shortlist: the smallest region, its neighbours, then the wider regions
+    local best
+    best=$(echo "$matches" | head -n 1 | cut -f2)
+    local ids=()
+    local names=()
+    local notes=()
+    local id name
+    ids+=("$best")
+    names+=("$(echo "$matches" | head -n 1 | cut -f3)")
+    notes+=("contains the point")
+    while IFS=$'\t' read -r id name; do
+        [ -n "$id" ] || continue
+        ids+=("$id"); names+=("$name"); notes+=("neighbour")
+    done < <(neighbours_of "$best" | head -n 5)
+    while IFS=$'\t' read -r _ id name; do
+        # Overlapping regions can be both a neighbour and a container
+        [ -n "$id" ] && [[ " ${ids[*]} " != *" ${id} "* ]] || continue
+        ids+=("$id"); names+=("$name"); notes+=("wider area")
+    done < <(echo "$matches" | tail -n +2 | head -n 3)
+
+    echo ""
+    echo "⭐ Recommended for ${lat}, ${lon} (checking download sizes...):"
+    local i
+    for i in "${!ids[@]}"; do
+        printf "  [%d] %-26s %-24s %9s   %s\n" $((i + 1)) "${names[$i]}" "${ids[$i]}" "$(region_size "${ids[$i]}")" "${notes[$i]}"
+    done
+    echo ""
+
+    local choice=""
+    if [ "$ASSUME_YES" = "true" ]; then
+        choice=1
+    elif [ -t 0 ]; then
+        read -r -n 1 -p "Press 1-${#ids[@]} to build a region, any other key to quit: " choice || choice=""
+        echo ""
+    else
+        echo "💡 Build one with: ./run.sh ${best}"
+    fi
+    if [[ "$choice" =~ ^[1-9]$ ]] && [ "$choice" -le ${#ids[@]} ]; then
+        exec ./run.sh "${ids[$((choice - 1))]}"
+    fi
+}
+
+main "$@"